@@ -4,39 +4,118 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
 	walletFile = "wallet.dat"
 )
 
+// AddressMeta is stored in wallet file together with keys of the address
+type AddressMeta struct {
+	Label     string
+	CreatedAt int64
+	Note      string
+}
+
 type WalletManager struct {
 	Wallets map[string]*Wallet
+	Meta    map[string]*AddressMeta // key is the Base58Check address, same as Wallets
 }
 
 func NewWalletManager() *WalletManager {
-	wm := &WalletManager{Wallets: make(map[string]*Wallet)}
+	wm := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
 	wm.LoadFile()
 	return wm
 }
 
-func (wm *WalletManager) CreateWallet() string {
-	wallet := NewWalletKeyPair()
+func (wm *WalletManager) CreateWallet(label string) string {
+	return wm.AddWallet(NewWalletKeyPair(), label)
+}
+
+// AddWallet imports a key pair generated elsewhere, e.g. by vanitygen
+func (wm *WalletManager) AddWallet(wallet *Wallet, label string) string {
 	address := wallet.GetAddress()
 	wm.Wallets[address] = wallet
+	wm.Meta[address] = &AddressMeta{Label: label, CreatedAt: time.Now().Unix()}
 	wm.SaveFile()
 	return address
 }
 
+// GetWallet accepts both Base58Check and bech32 address of a wallet
 func (wm *WalletManager) GetWallet(address string) *Wallet {
-	return wm.Wallets[address]
+	pubKeyHash, err := GetPubKeyHashFromAddress(address)
+	if err != nil {
+		return nil
+	}
+	return wm.Wallets[EncodeAddress(OutputPubKeyHash, pubKeyHash)]
+}
+
+// GetMeta returns metadata of a wallet address, wallet files created before
+// metadata was introduced have none, an empty one is returned for them
+func (wm *WalletManager) GetMeta(address string) *AddressMeta {
+	wallet := wm.GetWallet(address)
+	if wallet == nil {
+		return nil
+	}
+	address = wallet.GetAddress()
+	if wm.Meta[address] == nil {
+		wm.Meta[address] = &AddressMeta{}
+	}
+	return wm.Meta[address]
+}
+
+func (wm *WalletManager) SetLabel(address, label string) error {
+	meta := wm.GetMeta(address)
+	if meta == nil {
+		return errors.New("address is not in wallet")
+	}
+	meta.Label = label
+	wm.SaveFile()
+	return nil
+}
+
+func (wm *WalletManager) SetNote(address, note string) error {
+	meta := wm.GetMeta(address)
+	if meta == nil {
+		return errors.New("address is not in wallet")
+	}
+	meta.Note = note
+	wm.SaveFile()
+	return nil
+}
+
+func (wm *WalletManager) GetAddressesByLabel(label string) []string {
+	addresses := make([]string, 0)
+	for address := range wm.Wallets {
+		if wm.GetMeta(address).Label == label {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func (wm *WalletManager) ListLabels() []string {
+	labelSet := make(map[string]bool)
+	for address := range wm.Wallets {
+		labelSet[wm.GetMeta(address).Label] = true
+	}
+	labels := make([]string, 0, len(labelSet))
+	for label := range labelSet {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
 }
 
+// SaveFile replaces wallet file atomically, the previous file is kept as a backup
 func (wm *WalletManager) SaveFile() {
 	var buffer bytes.Buffer
 	gob.Register(elliptic.P256())
@@ -47,14 +126,16 @@ func (wm *WalletManager) SaveFile() {
 		panic(err)
 	}
 
-	f, err := os.OpenFile(walletFile, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		fmt.Println("open wallet file fail: ", err)
+	if err := EnsureDataDir(); err != nil {
+		fmt.Println("create data dir fail: ", err)
 		panic(err)
 	}
-	defer f.Close()
-
-	_, err2 := f.Write(buffer.Bytes())
+	filename := GetDataPath(walletFile)
+	if err := rotateWalletBackups(filename); err != nil {
+		fmt.Println("backup wallet file fail: ", err)
+		panic(err)
+	}
+	err2 := writeFileAtomic(filename, encodeWalletFile(buffer.Bytes()), 0600)
 	if err2 != nil {
 		fmt.Println("write wallet file fail: ", err2)
 		panic(err2)
@@ -62,37 +143,63 @@ func (wm *WalletManager) SaveFile() {
 }
 
 func (wm *WalletManager) LoadFile() {
-	if !IsFileExist(walletFile) {
+	if !IsFileExist(GetDataPath(walletFile)) {
 		return
 	}
-	// open wallet file
-	fp, err := os.Open(walletFile)
+	b, err := os.ReadFile(GetDataPath(walletFile))
 	if err != nil {
-		fmt.Println("open wallet file fail: ", err)
+		fmt.Println("read wallet file fail: ", err)
 		panic(err)
 	}
-	defer fp.Close()
-
-	b, err2 := io.ReadAll(fp)
+	payload, _, err2 := decodeWalletFile(b)
 	if err2 != nil {
-		fmt.Println("read wallet file fail: ", err2)
+		fmt.Printf("load wallet file fail: %s, restore it from %s.1 ~ %s.%d\n",
+			err2, walletFile, walletFile, walletBackupCount)
 		panic(err2)
 	}
-	// unserialize wallet file
-	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(b))
-	err3 := decoder.Decode(&wm)
+	err3 := wm.decode(payload)
 	if err3 != nil {
 		fmt.Println("decode wallet file fail: ", err3)
 		panic(err3)
 	}
-	log.Printf("Load wallet:\n%s\n", wm)
+	logDebug("Load wallet:\n%s", wm)
+}
+
+// decode unserializes wallet file payload into wm
+func (wm *WalletManager) decode(payload []byte) error {
+	gob.Register(elliptic.P256())
+	decoder := gob.NewDecoder(bytes.NewReader(payload))
+	return decoder.Decode(&wm)
+}
+
+// Backup copies the wallet file to path, path can be a dir or a file
+func (wm *WalletManager) Backup(path string) (string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, walletFile)
+	}
+	data, err := os.ReadFile(GetDataPath(walletFile))
+	if err != nil {
+		return "", err
+	}
+	if _, _, err = decodeWalletFile(data); err != nil {
+		return "", err
+	}
+	return path, writeFileAtomic(path, data, 0600)
 }
 
 func (wm *WalletManager) ListAllAddresses() []string {
 	addresses := make([]string, 0)
 	for address, wallet := range wm.Wallets {
-		addresses = append(addresses, address+" : "+fmt.Sprintf("%X", wallet.PriKey))
+		meta := wm.GetMeta(address)
+		created := "-"
+		if meta.CreatedAt != 0 {
+			created = time.Unix(meta.CreatedAt, 0).Format(time.DateTime)
+		}
+		line := fmt.Sprintf("%s : %s : %q : %s", address, wallet.GetBech32Address(), meta.Label, created)
+		if meta.Note != "" {
+			line += " : " + meta.Note
+		}
+		addresses = append(addresses, line)
 	}
 	return addresses
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalletLabels(t *testing.T) {
+	defer func(dir string) { dataDir = dir }(dataDir)
+	dataDir = t.TempDir()
+	wm := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
+	first := wm.CreateWallet("savings")
+	second := wm.CreateWallet("")
+	third := wm.CreateWallet("savings")
+
+	if err := wm.SetLabel(second, "spending"); err != nil {
+		t.Fatal(err)
+	}
+	// the bech32 address names the same wallet
+	if err := wm.SetNote(wm.GetWallet(first).GetBech32Address(), "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.SetLabel("mu68xiAkBxM5DuStCqD43szfxVpvryyHpB", "other"); err == nil {
+		t.Error("label is set on an address not in the wallet")
+	}
+	if err := wm.SetNote("1nvalid", "note"); err == nil {
+		t.Error("note is set on an invalid address")
+	}
+
+	// metadata is saved with the keys
+	loaded := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
+	loaded.LoadFile()
+	savings := []string{first, third}
+	if first > third {
+		savings = []string{third, first}
+	}
+	if got := loaded.GetAddressesByLabel("savings"); !reflect.DeepEqual(got, savings) {
+		t.Errorf("got addresses %v labeled savings, want %v", got, savings)
+	}
+	if got := loaded.GetAddressesByLabel("none"); len(got) != 0 {
+		t.Errorf("got addresses %v of an unused label", got)
+	}
+	if got := loaded.ListLabels(); !reflect.DeepEqual(got, []string{"savings", "spending"}) {
+		t.Errorf("got labels %v", got)
+	}
+	meta := loaded.GetMeta(first)
+	if meta.Note != "cold" || meta.CreatedAt == 0 {
+		t.Errorf("got meta %+v", meta)
+	}
+
+	// wallet files written before metadata have none, addresses get an empty one
+	delete(loaded.Meta, second)
+	if meta := loaded.GetMeta(second); meta == nil || meta.Label != "" {
+		t.Errorf("got meta %+v of an address without one", meta)
+	}
+}
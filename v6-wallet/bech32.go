@@ -0,0 +1,265 @@
+// bech32 and bech32m encoding described in BIP173 and BIP350
+// address format: `hrp` + "1" + data(5 bits per char) + checksum(6 chars)
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+type Bech32Encoding int
+
+const (
+	Bech32 Bech32Encoding = iota
+	Bech32m
+)
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+
+	bech32MaxLength = 90
+)
+
+func (e Bech32Encoding) String() string {
+	if e == Bech32m {
+		return "bech32m"
+	}
+	return "bech32"
+}
+
+func (e Bech32Encoding) checksumConst() uint32 {
+	if e == Bech32m {
+		return bech32mConst
+	}
+	return bech32Const
+}
+
+// Bech32Error records the position of the invalid character, -1 if unknown
+type Bech32Error struct {
+	Msg      string
+	Position int
+}
+
+func (e *Bech32Error) Error() string {
+	if e.Position < 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Position)
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+func bech32CreateChecksum(hrp string, data []byte, enc Bech32Encoding) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ enc.checksumConst()
+	ret := make([]byte, 6)
+	for i := 0; i < 6; i++ {
+		ret[i] = byte(mod>>(5*(5-i))) & 31
+	}
+	return ret
+}
+
+// Bech32Encode encodes 5-bit groups in data with human readable part hrp
+func Bech32Encode(hrp string, data []byte, enc Bech32Encoding) (string, error) {
+	hrp = strings.ToLower(hrp)
+	if len(hrp)+len(data)+7 > bech32MaxLength {
+		return "", errors.New("bech32 string too long")
+	}
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range append(data, bech32CreateChecksum(hrp, data, enc)...) {
+		if d > 31 {
+			return "", errors.New("bech32 data value out of range")
+		}
+		sb.WriteByte(bech32Charset[d])
+	}
+	return sb.String(), nil
+}
+
+// Bech32Decode returns the human readable part, 5-bit data groups without
+// checksum and the encoding that checksum matches
+func Bech32Decode(str string) (string, []byte, Bech32Encoding, error) {
+	if len(str) > bech32MaxLength {
+		return "", nil, Bech32, &Bech32Error{"bech32 string too long", -1}
+	}
+	hasLower, hasUpper := false, false
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c < 33 || c > 126 {
+			return "", nil, Bech32, &Bech32Error{fmt.Sprintf("invalid character %q", c), i}
+		}
+		if c >= 'a' && c <= 'z' {
+			hasLower = true
+		}
+		if c >= 'A' && c <= 'Z' {
+			hasUpper = true
+		}
+		if hasLower && hasUpper {
+			return "", nil, Bech32, &Bech32Error{"mixed case", i}
+		}
+	}
+	str = strings.ToLower(str)
+
+	sep := strings.LastIndexByte(str, '1')
+	if sep < 1 {
+		return "", nil, Bech32, &Bech32Error{"missing human readable part", -1}
+	}
+	if sep+7 > len(str) {
+		return "", nil, Bech32, &Bech32Error{"checksum too short", -1}
+	}
+	hrp := str[:sep]
+	data := make([]byte, 0, len(str)-sep-1)
+	for i := sep + 1; i < len(str); i++ {
+		d := strings.IndexByte(bech32Charset, str[i])
+		if d < 0 {
+			return "", nil, Bech32, &Bech32Error{fmt.Sprintf("invalid character %q", str[i]), i}
+		}
+		data = append(data, byte(d))
+	}
+
+	switch bech32Polymod(append(bech32HrpExpand(hrp), data...)) {
+	case bech32Const:
+		return hrp, data[:len(data)-6], Bech32, nil
+	case bech32mConst:
+		return hrp, data[:len(data)-6], Bech32m, nil
+	}
+	if pos := bech32LocateError(hrp, data); pos >= 0 {
+		return "", nil, Bech32, &Bech32Error{"invalid checksum, probably a typo", sep + 1 + pos}
+	}
+	return "", nil, Bech32, &Bech32Error{"invalid checksum", -1}
+}
+
+// bech32LocateError finds the data character which makes the checksum valid
+// after being replaced, the checksum guarantees it is unique
+func bech32LocateError(hrp string, data []byte) int {
+	expanded := bech32HrpExpand(hrp)
+	values := make([]byte, len(expanded)+len(data))
+	copy(values, expanded)
+	for pos := range data {
+		copy(values[len(expanded):], data)
+		for c := byte(0); c < 32; c++ {
+			if c == data[pos] {
+				continue
+			}
+			values[len(expanded)+pos] = c
+			mod := bech32Polymod(values)
+			if mod == bech32Const || mod == bech32mConst {
+				return pos
+			}
+		}
+	}
+	return -1
+}
+
+// ConvertBits regroups data from fromBits per element to toBits per element
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<toBits - 1
+	ret := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return ret, nil
+}
+
+// EncodeSegWitAddress encodes witness program, version 0 uses bech32 and others use bech32m
+func EncodeSegWitAddress(hrp string, version byte, program []byte) (string, error) {
+	enc := Bech32
+	if version > 0 {
+		enc = Bech32m
+	}
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	addr, err := Bech32Encode(hrp, append([]byte{version}, data...), enc)
+	if err != nil {
+		return "", err
+	}
+	// make sure the result can be decoded
+	if _, _, err := DecodeSegWitAddress(hrp, addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// DecodeSegWitAddress returns witness version and program of address
+func DecodeSegWitAddress(hrp, addr string) (byte, []byte, error) {
+	gotHrp, data, enc, err := Bech32Decode(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if gotHrp != hrp {
+		return 0, nil, fmt.Errorf("human readable part is %q, want %q", gotHrp, hrp)
+	}
+	if len(data) < 1 {
+		return 0, nil, errors.New("empty data section")
+	}
+	version := data[0]
+	if version > 16 {
+		return 0, nil, &Bech32Error{"invalid witness version", len(hrp) + 1}
+	}
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, fmt.Errorf("invalid witness program length %d for version 0", len(program))
+	}
+	if version == 0 && enc != Bech32 {
+		return 0, nil, errors.New("witness version 0 must use bech32")
+	}
+	if version != 0 && enc != Bech32m {
+		return 0, nil, fmt.Errorf("witness version %d must use bech32m", version)
+	}
+	return version, program, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// test vectors from BIP173 and BIP350
+func TestBech32Decode(t *testing.T) {
+	valid := []struct {
+		str string
+		enc Bech32Encoding
+	}{
+		{"A12UEL5L", Bech32},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", Bech32},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", Bech32},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", Bech32},
+		{"A1LQFN3A", Bech32m},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", Bech32m},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", Bech32m},
+	}
+	for _, item := range valid {
+		hrp, data, enc, err := Bech32Decode(item.str)
+		if err != nil {
+			t.Errorf("%s: %s", item.str, err)
+			continue
+		}
+		if enc != item.enc {
+			t.Errorf("%s: got %s, want %s", item.str, enc, item.enc)
+		}
+		str, err := Bech32Encode(hrp, data, enc)
+		if err != nil || str != strings.ToLower(item.str) {
+			t.Errorf("%s: encode again got %s, %v", item.str, str, err)
+		}
+	}
+
+	invalid := []string{
+		"\x201nwldj5",
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+	}
+	for _, str := range invalid {
+		if _, _, _, err := Bech32Decode(str); err == nil {
+			t.Errorf("%q: should be invalid", str)
+		}
+	}
+}
+
+func TestSegWitAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		hrp     string
+		version byte
+		program string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "bc", 0, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "tb", 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "bc", 1, "751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "bc", 16, "751e"},
+	}
+	for _, item := range tests {
+		version, program, err := DecodeSegWitAddress(item.hrp, item.addr)
+		if err != nil {
+			t.Errorf("%s: %s", item.addr, err)
+			continue
+		}
+		if version != item.version || hex.EncodeToString(program) != item.program {
+			t.Errorf("%s: got version %d program %x", item.addr, version, program)
+		}
+		addr, err := EncodeSegWitAddress(item.hrp, version, program)
+		if err != nil || addr != strings.ToLower(item.addr) {
+			t.Errorf("%s: encode again got %s, %v", item.addr, addr, err)
+		}
+	}
+
+	invalid := []string{
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", // version 1 with bech32
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", // version 16 with bech32
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",                           // invalid program length for version 0
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",                     // invalid checksum
+	}
+	for _, addr := range invalid {
+		if _, _, err := DecodeSegWitAddress("bc", addr); err == nil {
+			t.Errorf("%s: should be invalid", addr)
+		}
+	}
+}
+
+func TestBech32ErrorPosition(t *testing.T) {
+	addr := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	// replace the 10th character
+	typo := addr[:10] + "p" + addr[11:]
+	_, _, _, err := Bech32Decode(typo)
+	var bech32Err *Bech32Error
+	if !errors.As(err, &bech32Err) {
+		t.Fatalf("got %v, want a Bech32Error", err)
+	}
+	if bech32Err.Position != 10 {
+		t.Errorf("got position %d, want 10", bech32Err.Position)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"time"
 )
 
 type Block struct {
@@ -15,17 +15,24 @@ type Block struct {
 	TimeStamp    uint64
 	Bits         uint64 // complex level
 	Nonce        uint64
+	Height       uint64 // add it for simplify, BTC stores it in coinbase
 	Hash         []byte // add it for simplify, BTC don't have this field
 	Transactions []*Transaction
 }
 
-func NewBlock(txs []*Transaction, prevHash []byte) *Block {
+func NewBlock(txs []*Transaction, prevHash []byte, height uint64) *Block {
+	return newBlockAt(txs, prevHash, height, uint64(GetTime()))
+}
+
+// newBlockAt mines a block with timeStamp, which must be later than median time past
+func newBlockAt(txs []*Transaction, prevHash []byte, height uint64, timeStamp uint64) *Block {
 	b := Block{
-		Version:      0,
+		Version:      BlockVersion,
 		PrevHash:     prevHash,
 		MerkleRoot:   nil,
-		TimeStamp:    uint64(time.Now().Unix()),
+		TimeStamp:    timeStamp,
 		Nonce:        0,
+		Height:       height,
 		Hash:         nil,
 		Transactions: txs,
 	}
@@ -43,11 +50,12 @@ MerkleRoot  : %x
 TimeStamp   : %d
 Bits        : %d
 Nonce       : %d
+Height      : %d
 Hash        : %x
 Txs         : 
 %v`
 	return fmt.Sprintf(format, b.Version, b.PrevHash, b.MerkleRoot,
-		b.TimeStamp, b.Bits, b.Nonce, b.Hash, b.Transactions)
+		b.TimeStamp, b.Bits, b.Nonce, b.Height, b.Hash, b.Transactions)
 }
 
 func (b *Block) Serialize() ([]byte, error) {
@@ -81,3 +89,71 @@ func (b *Block) HashTransactionsMerkleRoot() {
 	hash := sha256.Sum256(value)
 	b.MerkleRoot = hash[:]
 }
+
+// Header returns a copy of block without transactions, proof of work can still be
+// verified as it covers merkle root only
+func (b *Block) Header() *Block {
+	header := *b
+	header.Transactions = nil
+	return &header
+}
+
+// CheckHeader validates proof of work of a block or a header
+func (b *Block) CheckHeader() error {
+	hash, isValid := NewProofOfWork(b).IsValid()
+	if !isValid || !bytes.Equal(hash, b.Hash) {
+		return errors.New("invalid proof of work")
+	}
+	return nil
+}
+
+// Check validates fields that don't depend on other blocks
+func (b *Block) Check() error {
+	if err := b.CheckHeader(); err != nil {
+		return err
+	}
+	for _, tx := range b.Transactions {
+		if !bytes.Equal(tx.CalcId(), tx.Id) {
+			return fmt.Errorf("transaction id %x doesn't match its content", tx.Id)
+		}
+		if err := tx.Check(); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.Id, err)
+		}
+	}
+	merkleRoot := b.MerkleRoot
+	b.HashTransactionsMerkleRoot()
+	if !bytes.Equal(merkleRoot, b.MerkleRoot) {
+		b.MerkleRoot = merkleRoot
+		return errors.New("merkle root doesn't match transactions")
+	}
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsMiningTx() {
+		return errors.New("first transaction must be the mining transaction")
+	}
+	if b.Transactions[0].TxInputs[0].Index != int64(b.Height) {
+		return errors.New("mining transaction doesn't commit to block height")
+	}
+	for i, tx := range b.Transactions[1:] {
+		if tx.IsMiningTx() {
+			return errors.New("more than one mining transaction")
+		}
+		for _, other := range b.Transactions[i+2:] {
+			if tx.ConflictsWith(other) {
+				return fmt.Errorf("transaction %x double spends in block", other.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckReward checks the mining transaction claims at most the block reward and fees,
+// the sum of what inputs of other transactions leave over their outputs
+func (b *Block) CheckReward(fees int64) error {
+	var reward int64 = 0
+	for _, output := range b.Transactions[0].TxOutputs {
+		reward += output.Value
+	}
+	if limit := activeNetwork.BlockReward(b.Height) + fees; reward > limit {
+		return fmt.Errorf("mining reward %d is more than %d", reward, limit)
+	}
+	return nil
+}
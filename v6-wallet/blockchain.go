@@ -1,137 +1,368 @@
-// blockchain store in bolt database using KV pair `[]byte(Hash): []byte(data)` format
-// The last block's hash correspond to `lastBlockHashKey`
+// blockchain is kept in a BlockStore, bolt database by default
 package main
 
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
-
-	"github.com/boltdb/bolt"
-)
-
-const (
-	dbName     = "blockchain.db"
-	bucketName = "bitcoin"
-
-	lastBlockHashKey = "lastBlockHash"
 )
 
 type BlockChain struct {
 	// Blocks []*Block
-	db   *bolt.DB
-	tail []byte
+	store BlockStore
+	tail  []byte
+	// blocks below pruneHeight only keep their headers, 0 if none is pruned
+	pruneHeight uint64
+	// bytes of stored blocks, pruned ones count as their headers
+	blockSize uint64
 }
 
-func CreateBlockChain(address, genesisInfo string) error {
-	if IsFileExist(dbName) {
-		return errors.New("blockchain store file exists")
+// NewBlockChain opens the chain kept in store, the utxo index is rebuilt if it's
+// behind the tail
+func NewBlockChain(store BlockStore) (*BlockChain, error) {
+	tail, err := store.GetTip()
+	if err != nil {
+		return nil, err
 	}
-	db, err := bolt.Open(dbName, 0600, nil)
+	bc := &BlockChain{store: store, tail: tail}
+	utxoTip, err := store.GetMeta(utxoTipKey)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !bytes.Equal(utxoTip, tail) {
+		if err := bc.reindexUtxo(); err != nil {
+			return nil, err
+		}
 	}
-	defer db.Close()
+	if bc.pruneHeight, err = bc.getMetaUint(pruneHeightKey); err != nil {
+		return nil, err
+	}
+	if bc.blockSize, err = bc.getMetaUint(blockSizeKey); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
-			bucket, err = tx.CreateBucket([]byte(bucketName))
-			if err != nil {
-				return fmt.Errorf("create bucket fail: %e", err)
-			}
+// getMetaUint reads a number kept by PutMeta, 0 if it's not set
+func (bc *BlockChain) getMetaUint(key string) (uint64, error) {
+	value, err := bc.store.GetMeta(key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid %s in store", key)
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+// CreateBlockChain creates the store with a genesis block and returns the block
+func CreateBlockChain(address, genesisInfo string) (*Block, error) {
+	if storeBackend.Exists() {
+		return nil, errors.New("blockchain store exists")
+	}
+	store, err := storeBackend.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	// mining transaction
+	miningTx := NewMiningTx(address, genesisInfo, 0)
+	// genesis block
+	genesisBlock := NewBlock([]*Transaction{miningTx}, []byte{}, 0)
+	bc := &BlockChain{store: store}
+	if err := bc.storeBlock(genesisBlock); err != nil {
+		return nil, err
+	}
+	return genesisBlock, nil
+}
+
+func GetBlockChain() (*BlockChain, error) {
+	if !storeBackend.Exists() {
+		return nil, errors.New("blockchain store not exists")
+	}
+	return OpenBlockChain()
+}
+
+// OpenBlockChain is used by nodes, if the store doesn't exist an empty one is created
+// and the node downloads all blocks including genesis from peers
+func OpenBlockChain() (*BlockChain, error) {
+	store, err := storeBackend.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open store fail: %v", err)
+	}
+	bc, err := NewBlockChain(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+func (bc *BlockChain) Close() error {
+	return bc.store.Close()
+}
+
+// AddBlock mines a new block with txs on the tail
+func (bc *BlockChain) AddBlock(txs []*Transaction) (*Block, error) {
+	height := bc.GetBestHeight() + 1
+	// varify transactions
+	var fees int64
+	for _, tx := range txs {
+		fee, err := bc.TxFee(tx)
+		if err != nil {
+			logWarn("verify transaction fail: %v", err)
+			return nil, errors.New("invalid transaction")
 		}
-		// mining transaction
-		miningTx := NewMiningTx(address, genesisInfo)
-		// genesis block
-		genesisBlock := NewBlock([]*Transaction{miningTx}, []byte{})
-		// serialize
-		blcokBytes, err2 := genesisBlock.Serialize()
-		if err2 != nil {
-			return fmt.Errorf("serialize Block fail: %e", err2)
+		fees += fee
+		if err := bc.checkTxLocks(tx, height); err != nil {
+			return nil, err
 		}
-		// store bytes and hash to db
-		err = bucket.Put(genesisBlock.Hash, blcokBytes)
+	}
+	// the miner collects the fees
+	if len(txs) > 0 && txs[0].IsMiningTx() && fees > 0 {
+		txs[0].TxOutputs[0].Value += fees
+		txs[0].SetHash()
+	}
+
+	// blocks mined in a row may share the clock time, but each must be later than median time past
+	timeStamp := uint64(GetTime())
+	if medianTime := bc.MedianTimePast(); timeStamp <= medianTime {
+		timeStamp = medianTime + 1
+	}
+	block := newBlockAt(txs, bc.tail, height, timeStamp)
+	if err := bc.storeBlock(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+var (
+	ErrBlockExists = errors.New("block already exists")
+	ErrOrphanBlock = errors.New("previous block is not the tail")
+)
+
+// AcceptBlock validates a block mined by others and appends it to the tail
+func (bc *BlockChain) AcceptBlock(block *Block) error {
+	if bc.HasBlock(block.Hash) {
+		return ErrBlockExists
+	}
+	return bc.connectBlock(block)
+}
+
+// connectBlock validates block and appends it to the tail, it may be in the store
+// already, e.g. a block disconnected by InvalidateBlock
+func (bc *BlockChain) connectBlock(block *Block) error {
+	var height uint64 = 0
+	if bc.tail != nil {
+		if !bytes.Equal(block.PrevHash, bc.tail) {
+			return ErrOrphanBlock
+		}
+		height = bc.GetBestHeight() + 1
+	} else if len(block.PrevHash) != 0 {
+		return ErrOrphanBlock
+	}
+	if block.Height != height {
+		return fmt.Errorf("block height is %d, want %d", block.Height, height)
+	}
+	if err := block.Check(); err != nil {
+		return err
+	}
+	if err := bc.checkBlockTime(block); err != nil {
+		return err
+	}
+	var fees int64
+	for _, tx := range block.Transactions {
+		fee, err := bc.TxFee(tx)
 		if err != nil {
 			return err
 		}
-		err = bucket.Put([]byte(lastBlockHashKey), genesisBlock.Hash)
-		if err != nil {
+		fees += fee
+		if err := bc.checkTxLocks(tx, height); err != nil {
 			return err
 		}
-		return nil
-	})
-	return err
+	}
+	if err := block.CheckReward(fees); err != nil {
+		return err
+	}
+	return bc.storeBlock(block)
 }
 
-func GetBlockChain() (*BlockChain, error) {
-	if !IsFileExist(dbName) {
-		return nil, errors.New("blockchain store file not exists")
+// storeBlock writes block with its undo data, updates the utxo index and moves tail
+// to it, old blocks are pruned afterwards if the store outgrows the prune target
+func (bc *BlockChain) storeBlock(block *Block) error {
+	blockBytes, err := block.Serialize()
+	if err != nil {
+		return err
 	}
-
-	db, err := bolt.Open(dbName, 0600, nil)
+	undo, err := bc.blockUndo(block)
 	if err != nil {
-		return nil, fmt.Errorf("open db fail: %e", err)
+		return err
 	}
-
-	var lastHash []byte
-	err = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
-			return errors.New("bucket not exists")
-		} else {
-			lastHash = bucket.Get([]byte(lastBlockHashKey))
+	undoBytes, err := undo.Serialize()
+	if err != nil {
+		return err
+	}
+	blockSize := bc.blockSize + uint64(len(blockBytes))
+	err = bc.store.Update(func(batch StoreBatch) error {
+		if err := batch.PutBlock(block.Hash, blockBytes); err != nil {
+			return err
 		}
-		return nil
+		if err := batch.PutUndo(block.Hash, undoBytes); err != nil {
+			return err
+		}
+		if err := updateUtxo(batch, block); err != nil {
+			return err
+		}
+		if err := batch.PutMeta(blockSizeKey, UintToByte(blockSize)); err != nil {
+			return err
+		}
+		if err := batch.PutMeta(utxoTipKey, block.Hash); err != nil {
+			return err
+		}
+		return batch.SetTip(block.Hash)
 	})
+	if err != nil {
+		return err
+	}
+	bc.tail = block.Hash
+	bc.blockSize = blockSize
+	bc.autoPrune()
+	return nil
+}
+
+// GetBlock returns an error wrapping ErrBlockPruned if only the header of the block
+// is kept
+func (bc *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	blockBytes, err := bc.store.GetBlock(hash)
 	if err != nil {
 		return nil, err
 	}
+	if blockBytes == nil {
+		return nil, fmt.Errorf("block %x not found", hash)
+	}
+	block, err := Deserialize(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	if block.IsPruned() {
+		return nil, bc.prunedError(block)
+	}
+	return block, nil
+}
 
-	return &BlockChain{db, lastHash}, nil
+func (bc *BlockChain) HasBlock(hash []byte) bool {
+	blockBytes, err := bc.store.GetBlock(hash)
+	return err == nil && len(hash) > 0 && blockBytes != nil
 }
 
-func (bc *BlockChain) Close() error {
-	return bc.db.Close()
+// GetTail returns hash of the last block, nil if the chain is empty
+func (bc *BlockChain) GetTail() []byte {
+	return bc.tail
 }
 
-func (bc *BlockChain) AddBlock(txs []*Transaction) error {
-	lastHash := bc.tail
-	block := NewBlock(txs, lastHash)
-	blockBytes, err := block.Serialize()
-	if err != nil {
-		return err
+// GetBlockLocator returns hashes from tail to genesis, dense at first then
+// exponentially sparse, so peers can find the fork point with few hashes
+func (bc *BlockChain) GetBlockLocator() [][]byte {
+	locator := make([][]byte, 0)
+	var genesis []byte
+	step, next := 1, 0
+	iter := bc.NewIterator()
+	for i, block := 0, iter.Next(); block != nil; i, block = i+1, iter.Next() {
+		genesis = block.Hash
+		if i == next {
+			locator = append(locator, block.Hash)
+			if len(locator) >= 10 {
+				step *= 2
+			}
+			next += step
+		}
+	}
+	if genesis != nil && !bytes.Equal(locator[len(locator)-1], genesis) {
+		locator = append(locator, genesis)
 	}
+	return locator
+}
 
-	// varify transactions
-	for _, tx := range txs {
-		if !bc.VerifyTransaction(tx) {
-			log.Println("verify transaction fail")
-			return errors.New("invalid transaction")
+// GetHeadersAfter finds the first hash of locator in the chain and returns at most
+// limit headers of blocks after it from old to new, or from genesis if none is found
+func (bc *BlockChain) GetHeadersAfter(locator [][]byte, limit int) []*Block {
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+	headers := make([]*Block, 0)
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		if known[string(block.Hash)] {
+			break
 		}
+		headers = append(headers, block.Header())
 	}
+	// reverse to old to new
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	if len(headers) > limit {
+		headers = headers[:limit]
+	}
+	return headers
+}
+
+// GetBestHeight returns the height of the last block
+func (bc *BlockChain) GetBestHeight() uint64 {
+	block := bc.NewIterator().Next()
+	if block == nil {
+		return 0
+	}
+	return block.Height
+}
 
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
-			return errors.New("bucket not exists")
+// GetBlockByHeight walks back from the tail to the block at height, a pruned block
+// is returned with its header only
+func (bc *BlockChain) GetBlockByHeight(height uint64) (*Block, error) {
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		if block.Height == height {
+			return block, nil
 		}
-		err = bucket.Put(block.Hash, blockBytes)
-		if err != nil {
-			return err
+		if block.Height < height {
+			break
 		}
-		err = bucket.Put([]byte(lastBlockHashKey), block.Hash)
-		if err != nil {
-			return err
+	}
+	return nil, fmt.Errorf("block at height %d not found", height)
+}
+
+// GetConfirmations returns the number of main chain blocks from block to the tail, 0 if
+// block isn't in the main chain
+func (bc *BlockChain) GetConfirmations(block *Block) uint64 {
+	iter := bc.NewIterator()
+	tail := iter.Next()
+	for b := tail; b != nil && b.Height >= block.Height; b = iter.Next() {
+		if b.Height == block.Height {
+			if bytes.Equal(b.Hash, block.Hash) {
+				return tail.Height - block.Height + 1
+			}
+			break
 		}
-		bc.tail = block.Hash
-		return nil
-	})
+	}
+	return 0
+}
 
-	return err
+// FindTransactionBlock returns the transaction and the block containing it, nil if not found
+func (bc *BlockChain) FindTransactionBlock(txid []byte) (*Transaction, *Block) {
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.Id, txid) {
+				return tx, block
+			}
+		}
+	}
+	return nil, nil
 }
 
 func (bc *BlockChain) FindTransaction(txid []byte) *Transaction {
@@ -146,51 +377,71 @@ func (bc *BlockChain) FindTransaction(txid []byte) *Transaction {
 	return nil
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, priKey *ecdsa.PrivateKey) bool {
-	if tx.IsMiningTx() {
-		return true
-	}
-	log.Println("Start SignTransaction()")
-	refedTxs := make(map[string]*Transaction)
-
+// prevOutputs looks up the outputs spent by tx in the utxo index, keyed by outPointKey,
+// nil if any of them is spent, missing or spent twice by tx
+func (bc *BlockChain) prevOutputs(tx *Transaction) map[string]TxOutput {
+	outputs := make(map[string]TxOutput)
 	for _, input := range tx.TxInputs {
-		refedTx := bc.FindTransaction(input.TxId)
-		if refedTx == nil {
-			return false
+		key := outPointKey(input.TxId, input.Index)
+		if _, ok := outputs[key]; ok {
+			logDebug("output %X:%d is spent twice", input.TxId, input.Index)
+			return nil
+		}
+		entry, err := bc.GetUtxo(input.TxId, input.Index)
+		if err != nil || entry == nil {
+			logDebug("output %X:%d is spent or doesn't exist", input.TxId, input.Index)
+			return nil
 		}
-		refedTxs[string(input.TxId)] = refedTx
+		outputs[key] = entry.Output
 	}
-
-	return tx.Sign(priKey, refedTxs)
+	return outputs
 }
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+
+func (bc *BlockChain) SignTransaction(tx *Transaction, priKey *ecdsa.PrivateKey) bool {
 	if tx.IsMiningTx() {
 		return true
 	}
-	log.Printf("Start VerifyTransaction(%X)\n", tx.Id)
-	refedTxs := make(map[string]*Transaction)
-
-	for _, input := range tx.TxInputs {
-		refedTx := bc.FindTransaction(input.TxId)
-		if refedTx == nil {
-			return false
-		}
-		refedTxs[string(input.TxId)] = refedTx
+	logDebug("Start SignTransaction()")
+	prevOutputs := bc.prevOutputs(tx)
+	if prevOutputs == nil {
+		return false
 	}
+	return tx.Sign(priKey, prevOutputs)
+}
 
-	return tx.Verify(refedTxs)
+// TxFee verifies tx and returns what its inputs leave over its outputs, inputs must
+// cover the outputs since peers relay transactions too
+func (bc *BlockChain) TxFee(tx *Transaction) (int64, error) {
+	if tx.IsMiningTx() {
+		return 0, nil
+	}
+	prevOutputs := bc.prevOutputs(tx)
+	if prevOutputs == nil || !tx.Verify(prevOutputs) {
+		return 0, fmt.Errorf("invalid transaction %x", tx.Id)
+	}
+	var inputTotal, outputTotal int64
+	for _, output := range prevOutputs {
+		inputTotal += output.Value
+	}
+	for _, output := range tx.TxOutputs {
+		outputTotal += output.Value
+	}
+	if outputTotal > inputTotal {
+		return 0, fmt.Errorf("transaction %x spends %d, more than its inputs %d", tx.Id, outputTotal, inputTotal)
+	}
+	return inputTotal - outputTotal, nil
 }
 
 ///////////////////////////////////////////////////////////////////////////
 
 type Iterator struct {
-	db          *bolt.DB
+	store       BlockStore
 	currentHash []byte
 }
 
 func (bc *BlockChain) NewIterator() *Iterator {
 	return &Iterator{
-		db:          bc.db,
+		store:       bc.store,
 		currentHash: bc.tail,
 	}
 }
@@ -200,95 +451,16 @@ func (i *Iterator) Next() *Block {
 		return nil
 	}
 
-	var block *Block
-	i.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		if bucket == nil {
-			return errors.New("bucket don't exists")
-		}
-		// get current block
-		blockBytes := bucket.Get(i.currentHash)
-		b, err := Deserialize(blockBytes)
-		if err != nil {
-			return err
-		}
-		block = b
-		// update current hash
-		i.currentHash = b.PrevHash
+	// get current block
+	blockBytes, err := i.store.GetBlock(i.currentHash)
+	if err != nil || blockBytes == nil {
 		return nil
-	})
-	return block
-}
-
-///////////////////////////////////////////////////////////////////////////
-
-type UTXOInfo struct {
-	TxId   []byte
-	Index  int64
-	Output TxOutput
-}
-
-func (bc *BlockChain) FindUtxo(pubKeyHash []byte) ([]UTXOInfo, int64) {
-	var utxos []UTXOInfo
-	var total int64 = 0
-
-	var spentOutputs = make(map[string][]int64)
-
-	// traversal blockchain
-	iter := bc.NewIterator()
-	for block := iter.Next(); block != nil; block = iter.Next() {
-		// traversal all transactions
-		for _, tx := range block.Transactions {
-			// traversal all outputs
-		TRAVERSAL_OUTPUTS:
-			for outputIdx, output := range tx.TxOutputs {
-				// is output related to address
-				if bytes.Equal(output.ScriptPubKeyHash, pubKeyHash) {
-					for _, spentIdx := range spentOutputs[string(tx.Id)] {
-						if int64(outputIdx) == spentIdx {
-							// if output is spent, continue
-							continue TRAVERSAL_OUTPUTS
-						}
-					}
-					// if output is unspent, add it to utxo
-					utxos = append(utxos, UTXOInfo{tx.Id, int64(outputIdx), output})
-					total += output.Value
-				}
-			}
-
-			// traversal all inputs except mining transaction
-			if tx.IsMiningTx() {
-				continue
-			}
-			for _, input := range tx.TxInputs {
-				// if input is related to address, add it to container
-				if bytes.Equal(pubKeyHash, GetPubKeyHashFromPubKey(input.PubKey)) {
-					spentKey := string(input.TxId)
-					spentOutputs[spentKey] = append(spentOutputs[spentKey], input.Index)
-				}
-			}
-		}
 	}
-
-	return utxos, total
-}
-
-func (bc *BlockChain) FindNeededUtxo(pubKeyHash []byte, amount int64) (int64, map[string][]int64) {
-	utxoInfos, total := bc.FindUtxo(pubKeyHash)
-	if total < amount {
-		return total, nil
-	}
-	retUtxoInfos := make(map[string][]int64)
-	var retTotal int64 = 0
-	// traversal utxo, compare with amount
-	for _, utxoInfo := range utxoInfos {
-		retTotal += utxoInfo.Output.Value
-		key := string(utxoInfo.TxId)
-		retUtxoInfos[key] = append(retUtxoInfos[key], utxoInfo.Index)
-		// if utxo is enough, return
-		if total >= amount {
-			break
-		}
+	block, err := Deserialize(blockBytes)
+	if err != nil {
+		return nil
 	}
-	return retTotal, retUtxoInfos
+	// update current hash
+	i.currentHash = block.PrevHash
+	return block
 }
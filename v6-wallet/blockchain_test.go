@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTxValues(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	from := wallet.GetAddress()
+	bc := newTestChain(t, from, 2)
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: wallet}}
+	// peerBlock mines txs after the tail like a peer would, the mining transaction claims reward
+	peerBlock := func(reward int64, txs ...*Transaction) *Block {
+		height := bc.GetBestHeight() + 1
+		coinbase := NewMiningTx(miner, "peer", height)
+		coinbase.TxOutputs[0].Value = reward
+		coinbase.SetHash()
+		return newBlockAt(append([]*Transaction{coinbase}, txs...), bc.GetTail(), height, bc.MedianTimePast()+1)
+	}
+	// resign changes tx and signs it again like its owner would
+	resign := func(tx *Transaction, change func(tx *Transaction)) *Transaction {
+		changed := &Transaction{TxInputs: append([]TxInput{}, tx.TxInputs...), TxOutputs: append([]TxOutput{}, tx.TxOutputs...), TimeStamp: tx.TimeStamp}
+		for i := range changed.TxInputs {
+			changed.TxInputs[i].ScriptSig = nil
+		}
+		change(changed)
+		changed.SetHash()
+		bc.SignTransaction(changed, wallet.PrivateKey())
+		return changed
+	}
+	// withFee sends 1 to miner and leaves fee to the miner of the block
+	withFee := func(fee int64) *Transaction {
+		tx, err := NewTransaction(from, miner, 1, fee, 0, 0, bc, wm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	if _, err := NewTransaction(from, miner, math.MaxInt64, 1, 0, 0, bc, wm); err == nil {
+		t.Error("transaction with amount and fee overflowing is created")
+	}
+	tx := withFee(2)
+	if fee, err := bc.TxFee(tx); err != nil || fee != 2 {
+		t.Fatalf("got fee %d: %v", fee, err)
+	}
+	// a signed transaction creating more than its inputs
+	inflated := resign(tx, func(tx *Transaction) { tx.TxOutputs[0].Value = 1000000 })
+	if _, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "test", bc.GetBestHeight()+1), inflated}); err == nil {
+		t.Error("transaction spending more than its inputs is mined")
+	}
+	if err := bc.AcceptBlock(peerBlock(RegTestParams.BlockReward(bc.GetBestHeight()+1), inflated)); err == nil {
+		t.Error("block with a transaction spending more than its inputs is accepted")
+	}
+
+	negative := resign(tx, func(tx *Transaction) {
+		tx.TxOutputs = append(tx.TxOutputs, TxOutput{tx.TxOutputs[0].ScriptPubKeyHash, -5, OutputPubKeyHash})
+	})
+	if err := negative.Check(); err == nil {
+		t.Error("negative output is valid")
+	}
+	twice := resign(tx, func(tx *Transaction) { tx.TxInputs = append(tx.TxInputs, tx.TxInputs[0]) })
+	if err := twice.Check(); err == nil {
+		t.Error("transaction spending an output twice is valid")
+	}
+
+	// the mining transaction may claim the fees, but no more
+	reward := RegTestParams.BlockReward(bc.GetBestHeight()+1) + 2
+	if err := bc.AcceptBlock(peerBlock(reward+1, tx)); err == nil {
+		t.Error("block claiming more than reward and fees is accepted")
+	}
+	if err := bc.AcceptBlock(peerBlock(reward, tx)); err != nil {
+		t.Fatal(err)
+	}
+	// AddBlock pays the fees to the miner
+	block, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "test", bc.GetBestHeight()+1), withFee(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := block.Transactions[0].TxOutputs[0].Value, RegTestParams.BlockReward(block.Height)+3; got != want {
+		t.Errorf("got mining reward %d, want %d", got, want)
+	}
+}
+
+func TestGetConfirmations(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, miner, 3)
+	blocks := chainBlocks(bc)
+	// a block competing with the one at height 1 and a block above the tail aren't in
+	// the main chain
+	stale := newBlockAt([]*Transaction{NewMiningTx(miner, "stale", 1)}, blocks[0].Hash, 1, blocks[1].TimeStamp+1)
+	above := newBlockAt([]*Transaction{NewMiningTx(miner, "above", 4)}, blocks[2].Hash, 4, blocks[2].TimeStamp+1)
+
+	for _, test := range []struct {
+		block *Block
+		want  uint64
+	}{{blocks[2], 1}, {blocks[0], 3}, {stale, 0}, {above, 0}} {
+		if got := bc.GetConfirmations(test.block); got != test.want {
+			t.Errorf("block at height %d: got %d confirmations, want %d", test.block.Height, got, test.want)
+		}
+		if got := NewBlockJSON(test.block, bc.GetConfirmations(test.block), false).Confirmations; got != test.want {
+			t.Errorf("block at height %d: got %d confirmations in JSON, want %d", test.block.Height, got, test.want)
+		}
+	}
+}
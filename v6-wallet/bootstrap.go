@@ -0,0 +1,115 @@
+// bootstrap files hold blocks in height order, each framed like bitcoin's blk*.dat:
+//
+//	magic(4) | block length(4, little endian) | block
+//
+// block is serialized as in the store, magic is of the network the chain belongs to
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WriteBootstrapBlock appends a framed block to w
+func WriteBootstrapBlock(w io.Writer, block *Block) error {
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	copy(header, activeNetwork.Magic[:])
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	_, err = w.Write(append(header, data...))
+	return err
+}
+
+// ReadBootstrapBlock reads the next framed block of r, io.EOF at the end of r
+func ReadBootstrapBlock(r io.Reader) (*Block, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated block header")
+		}
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], activeNetwork.Magic[:]) {
+		return nil, fmt.Errorf("magic doesn't match, it's not a bootstrap file of %s", activeNetwork.Name)
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if length > maxPayloadSize {
+		return nil, fmt.Errorf("block is too large: %d bytes", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.New("truncated block")
+	}
+	return Deserialize(data)
+}
+
+// ExportBlocks writes blocks from height from to height to of bc, it returns the number
+// of blocks written
+func ExportBlocks(bc *BlockChain, w io.Writer, from, to uint64) (int, error) {
+	if bc.GetTail() == nil {
+		return 0, errors.New("blockchain is empty")
+	}
+	best := bc.GetBestHeight()
+	if from > to || to > best {
+		return 0, fmt.Errorf("invalid range %d to %d, best height is %d", from, to, best)
+	}
+	if from < bc.PruneHeight() {
+		return 0, fmt.Errorf("blocks below height %d are pruned, export from it", bc.PruneHeight())
+	}
+	// the iterator walks back from the tail, so hashes are collected first
+	hashes := make([][]byte, 0, to-from+1)
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil && block.Height >= from; block = iter.Next() {
+		if block.Height <= to {
+			hashes = append(hashes, block.Hash)
+		}
+	}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := bc.GetBlock(hashes[i])
+		if err != nil {
+			return 0, err
+		}
+		if err := WriteBootstrapBlock(w, block); err != nil {
+			return 0, err
+		}
+	}
+	return len(hashes), nil
+}
+
+// ImportResult counts blocks of a bootstrap file
+type ImportResult struct {
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"` // already in chain, e.g. by an interrupted import
+	Height   uint64 `json:"height"`  // best height after import
+}
+
+// ImportBlocks validates and connects blocks read from r to bc, blocks already in bc are
+// skipped so an interrupted import can be run again. progress is called after each block.
+func ImportBlocks(bc *BlockChain, r io.Reader, progress func(*ImportResult)) (*ImportResult, error) {
+	result := &ImportResult{Height: bc.GetBestHeight()}
+	for {
+		block, err := ReadBootstrapBlock(r)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("read block %d of file fail: %v", result.Imported+result.Skipped, err)
+		}
+		if bc.HasBlock(block.Hash) {
+			result.Skipped++
+		} else {
+			if err := bc.AcceptBlock(block); err != nil {
+				return result, fmt.Errorf("block %x at height %d is rejected: %v", block.Hash, block.Height, err)
+			}
+			result.Imported++
+			result.Height = block.Height
+		}
+		progress(result)
+	}
+}
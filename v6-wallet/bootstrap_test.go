@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBootstrapBlock(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+
+	var blocks []*Block
+	var prevHash []byte
+	for height := uint64(0); height < 3; height++ {
+		block := NewBlock([]*Transaction{NewMiningTx("mu68xiAkBxM5DuStCqD43szfxVpvryyHpB", "bootstrap", height)}, prevHash, height)
+		blocks = append(blocks, block)
+		prevHash = block.Hash
+	}
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		if err := WriteBootstrapBlock(&buf, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	for _, want := range blocks {
+		block, err := ReadBootstrapBlock(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(block.Hash, want.Hash) || block.Height != want.Height {
+			t.Errorf("got block %x at %d, want %x at %d", block.Hash, block.Height, want.Hash, want.Height)
+		}
+	}
+	if _, err := ReadBootstrapBlock(r); err != io.EOF {
+		t.Errorf("got %v at end, want EOF", err)
+	}
+
+	r = bytes.NewReader(data[:len(data)-1])
+	for i := 0; i < len(blocks); i++ {
+		if _, err := ReadBootstrapBlock(r); i == len(blocks)-1 && (err == nil || err == io.EOF) {
+			t.Errorf("truncated block: got %v", err)
+		}
+	}
+
+	activeNetwork = &TestNetParams
+	if _, err := ReadBootstrapBlock(bytes.NewReader(data)); err == nil {
+		t.Error("block of another network is read")
+	}
+}
@@ -1,155 +1,928 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"sort"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// exit codes
+const (
+	exitOK    = 0
+	exitFail  = 1
+	exitUsage = 2
 )
 
 type Cli struct {
-	Create            bool
-	PrintNum          int
-	AddressGetBalance string
-	SendCoin          bool
+	name     string // name of the binary in usage
+	global   *flag.FlagSet
+	commands []*Command
+
+	// the console keeps chain and wallet open between commands
+	console bool
+	bc      *BlockChain
+	wm      *WalletManager
+	vars    map[string]string // $name of console, set by commands
+
+	Network   string
+	DataDir   string
+	DBBackend string
+	Conf      string
+	LogLevel  string
+	MockTime  int64
+	Format    string
+	Prune     uint64
 
-	CreateWallet     bool
-	ListAllAddresses bool
+	RPCPort     int
+	RPCUser     string
+	RPCPassword string
+	RPCConnect  string
+
+	// options of startnode
+	Port       int
+	Connect    string
+	Miner      string
+	ExternalIP string
+	Server     bool
+	RPCBind    string
+	Rest       bool
+	Explorer   bool
 }
 
 func NewCli() *Cli {
-	cli := &Cli{}
-	flag.BoolVar(&cli.Create, "create", false, "create a new blockchain: -create <miner-address>")
-	flag.IntVar(&cli.PrintNum, "print", 0, "print a specified number of blocks (0 < number < 20): -print <number>")
-	flag.StringVar(&cli.AddressGetBalance, "getbalance", "", "get balance of an address: -getbalance <address>")
-	flag.BoolVar(&cli.SendCoin, "send", false, "send to someone: -send <from-address> <to-address> <amount> <miner-address> <data>")
-	flag.BoolVar(&cli.CreateWallet, "createwallet", false, "create a new wallet")
-	flag.BoolVar(&cli.ListAllAddresses, "listAllAddresses", false, "list all addresses (and private key) in wallet")
-	flag.Parse()
+	cli := &Cli{name: filepath.Base(os.Args[0]), vars: make(map[string]string)}
+	fs := flag.NewFlagSet(cli.name, flag.ContinueOnError)
+	fs.StringVar(&cli.Network, "network", MainNetParams.Name, "network to use: mainnet, testnet or regtest")
+	fs.StringVar(&cli.DataDir, "datadir", defaultDataDir(), "base dir of blockchain and wallet files, each network uses a sub dir except mainnet")
+	fs.StringVar(&cli.DBBackend, "dbbackend", "bolt", "blockchain store backend: "+strings.Join(StoreBackendNames(), ", ")+", the memory store is lost when the process exits")
+	fs.StringVar(&cli.Conf, "conf", "", "config file, "+configFile+" in -datadir if empty")
+	fs.StringVar(&cli.LogLevel, "loglevel", "info", "level of messages written to "+logFile+" in data dir: debug, info, warn or error")
+	fs.StringVar(&cli.Format, "format", "text", "output format: text, or json with the same schema as the JSON-RPC result of a command")
+	fs.Uint64Var(&cli.Prune, "prune", 0, "keep stored blocks under this size in MB by dropping transactions of old blocks, 1 only prunes with pruneblockchain, 0 disables pruning")
+	fs.Int64Var(&cli.MockTime, "mocktime", 0, "use this unix time for new blocks and transactions on regtest instead of the clock")
+	fs.IntVar(&cli.RPCPort, "rpcport", 0, "port of the JSON-RPC server, default rpc port of the network if 0")
+	fs.StringVar(&cli.RPCUser, "rpcuser", "", "user of JSON-RPC basic auth, the cookie file in data dir is used if user or password is empty")
+	fs.StringVar(&cli.RPCPassword, "rpcpassword", "", "password of JSON-RPC basic auth")
+	fs.StringVar(&cli.RPCConnect, "rpcconnect", "", "send commands to the JSON-RPC server at <host:port> instead of running them locally")
+	fs.Usage = cli.usage
+	cli.global = fs
+	cli.commands = cli.newCommands()
 	return cli
 }
 
-func (cli *Cli) Run() {
-	if cli.CreateWallet {
-		wm := NewWalletManager()
-		address := wm.CreateWallet()
-		fmt.Printf("New wallet created: %s\n", address)
-		return
-	}
-	if cli.ListAllAddresses {
-		wm := NewWalletManager()
-		addresses := wm.ListAllAddresses()
-		fmt.Printf("All addresses in wallet:\n")
-		sort.Strings(addresses)
-		for _, address := range addresses {
-			fmt.Println(address)
+func (cli *Cli) command(name string) *Command {
+	for _, cmd := range cli.commands {
+		if cmd.Name == name {
+			return cmd
 		}
-		return
 	}
+	return nil
+}
 
-	if cli.Create {
-		if len(flag.Args()) != 2 {
-			fmt.Println("invalid command, command format: -create <miner-address> <genesis-info>")
-			return
+// Run executes the command in args and returns the exit code
+func (cli *Cli) Run(args []string) int {
+	if err := cli.global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
-		if !IsValidAddress(flag.Arg(0)) {
-			fmt.Println("invalid address: ", flag.Arg(0))
-			return
+		return exitUsage
+	}
+	if cli.global.NArg() == 0 {
+		cli.usage()
+		return exitUsage
+	}
+	return cli.execute(cli.global.Args())
+}
+
+// execute runs a command line without global options
+func (cli *Cli) execute(args []string) int {
+	cmd := cli.command(args[0])
+	if cmd == nil {
+		cli.printError(usageErrorf("unknown command %s, run %s help to list commands", args[0], cli.name), exitUsage)
+		return exitUsage
+	}
+	cmdArgs, err := parseInterspersed(cmd.Flags, args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
-		err2 := CreateBlockChain(flag.Arg(0), flag.Arg(1))
-		if err2 != nil {
-			fmt.Println("create blockchain fail: ", err2)
+		return exitUsage
+	}
+	if len(cmdArgs) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(cmdArgs) > cmd.MaxArgs) {
+		cli.printError(usageErrorf("wrong number of arguments"), exitUsage)
+		if cli.Format != "json" {
+			cmd.Flags.Usage()
 		}
-		return
+		return exitUsage
 	}
 
-	// following command will operate the blockchain.db file
-	bc, err := GetBlockChain()
+	err = cli.setup(cmd)
+	if err == nil {
+		err = cli.runCommand(cmd, cmdArgs)
+	}
 	if err != nil {
-		fmt.Println("can't get blockchain: ", err)
+		code := exitFail
+		if isUsageError(err) {
+			code = exitUsage
+		}
+		cli.printError(err, code)
+		if code == exitUsage && cli.Format != "json" {
+			cmd.Flags.Usage()
+		}
+		return code
 	}
-	defer bc.Close()
+	return exitOK
+}
 
-	if cli.PrintNum > 0 {
-		cli.Print(bc)
+type ErrorJSON struct {
+	Code    int    `json:"code"`              // exit code
+	RPCCode int    `json:"rpccode,omitempty"` // code of the JSON-RPC error
+	Message string `json:"message"`
+}
+
+// printError writes err to stderr, as {"error":{...}} in json format
+func (cli *Cli) printError(err error, code int) {
+	if cli.Format != "json" {
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	if cli.AddressGetBalance != "" {
-		if !IsValidAddress(cli.AddressGetBalance) {
-			fmt.Println("invalid address: ", cli.AddressGetBalance)
-			return
-		}
-		cli.GetBalance(bc, cli.AddressGetBalance)
+	errJSON := &ErrorJSON{Code: code, Message: err.Error()}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		errJSON.RPCCode = rpcErr.Code
+		errJSON.Message = rpcErr.Message
+	}
+	writeJSON(os.Stderr, map[string]*ErrorJSON{"error": errJSON})
+}
+
+// output prints value as JSON in json format, or calls text
+func (cli *Cli) output(value interface{}, text func()) {
+	if cli.Format == "json" {
+		writeJSON(os.Stdout, value)
 		return
 	}
-	if cli.SendCoin {
-		if len(flag.Args()) != 5 {
-			fmt.Println("invalid command")
-			return
+	text()
+}
+
+func writeJSON(w io.Writer, value interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// parseInterspersed allows options after positional args, which the flag package stops at
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
-		if !IsValidAddress(flag.Arg(0)) {
-			fmt.Println("invalid address: ", flag.Arg(0))
-			return
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
 		}
-		if !IsValidAddress(flag.Arg(1)) {
-			fmt.Println("invalid address: ", flag.Arg(1))
-			return
+		// all args after "--" are positional
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
 		}
-		if !IsValidAddress(flag.Arg(3)) {
-			fmt.Println("invalid address: ", flag.Arg(3))
-			return
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// setup applies settings, selects the network and opens the log, in the console only
+// settings of cmd are applied as the rest was done when it started
+func (cli *Cli) setup(cmd *Command) error {
+	// options of other commands may be in the config file too
+	known := make(map[string]bool)
+	cli.global.VisitAll(func(f *flag.Flag) { known[f.Name] = true })
+	for _, c := range cli.commands {
+		c.Flags.VisitAll(func(f *flag.Flag) { known[f.Name] = true })
+	}
+	if err := LoadSettings(known, cli.global, cmd.Flags); err != nil {
+		return err
+	}
+	if cli.console {
+		return nil
+	}
+	params, err := GetNetworkParams(cli.Network)
+	if err != nil {
+		return err
+	}
+	activeNetwork = params
+	dataDir = cli.DataDir
+	if err := SetStoreBackend(cli.DBBackend); err != nil {
+		return usageErrorf("%v", err)
+	}
+	SetPruneTarget(cli.Prune)
+	if cli.Format != "text" && cli.Format != "json" {
+		return usageErrorf("unknown format %s: text or json", cli.Format)
+	}
+	level, err := ParseLogLevel(cli.LogLevel)
+	if err != nil {
+		return err
+	}
+	if _, err := OpenLog(level); err != nil {
+		return fmt.Errorf("open log fail: %v", err)
+	}
+	if cli.MockTime != 0 {
+		if !activeNetwork.MineBlocksOnDemand {
+			return fmt.Errorf("mock time can't be set on %s", activeNetwork.Name)
 		}
-		amount, err := strconv.Atoi(flag.Arg(2))
-		if err != nil {
-			fmt.Println("the amount must be a number")
-			return
+		SetMockTime(cli.MockTime)
+	}
+	return nil
+}
+
+func (cli *Cli) runCommand(cmd *Command, args []string) error {
+	if cmd.Local != nil && (cli.RPCConnect == "" || cmd.RPC == nil) {
+		if cli.RPCConnect != "" {
+			return usageErrorf("%s can't be sent to rpc server, use rpc <method> [params...]", cmd.Name)
 		}
-		cli.Send(bc, flag.Arg(0), flag.Arg(1), int64(amount), flag.Arg(3), flag.Arg(4))
-		return
+		return cmd.Local(args)
+	}
+	method, params, err := cmd.RPC(args)
+	if err != nil {
+		return err
+	}
+	return cli.CallRPC(method, params)
+}
+
+// setVar sets $name in the console for the result of a command
+func (cli *Cli) setVar(name, value string) {
+	cli.vars[name] = value
+}
+
+func (cli *Cli) usage() {
+	w := cli.global.Output()
+	fmt.Fprintf(w, "usage: %s [global options] <command> [options] [args]\n\ncommands:\n", cli.name)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range cli.commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Summary)
 	}
-	fmt.Println("invalid command")
+	tw.Flush()
+	fmt.Fprintf(w, "\nglobal options:\n")
+	cli.global.PrintDefaults()
+	fmt.Fprintf(w, "\nrun %s help <command> or %s <command> -h for options of a command\n", cli.name, cli.name)
 }
 
-func (cli *Cli) Print(bc *BlockChain) {
+func (cli *Cli) commandUsage(cmd *Command) {
+	w := cmd.Flags.Output()
+	options := ""
+	hasFlags := false
+	cmd.Flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		options = " [options]"
+	}
+	args := ""
+	if cmd.Args != "" {
+		args = " " + cmd.Args
+	}
+	fmt.Fprintf(w, "usage: %s [global options] %s%s%s\n\n%s\n", cli.name, cmd.Name, options, args, cmd.Summary)
+	if cmd.Local == nil {
+		fmt.Fprintf(w, "it's sent to the JSON-RPC server of a running node\n")
+	} else if cmd.RPC != nil {
+		fmt.Fprintf(w, "it's sent to the JSON-RPC server with -rpcconnect\n")
+	}
+	if hasFlags {
+		fmt.Fprintf(w, "\noptions:\n")
+		cmd.Flags.PrintDefaults()
+	}
+}
+
+func (cli *Cli) Print(bc *BlockChain, count int) {
+	var blocks []*Block
 	iter := bc.NewIterator()
-	for i := 0; i < cli.PrintNum && i < 20; i++ {
-		block := iter.Next()
-		if block == nil {
-			break
-		}
-		fmt.Println("===============================================[Block]===============================================")
-		fmt.Println(block.String())
+	for block := iter.Next(); block != nil && len(blocks) < count; block = iter.Next() {
+		blocks = append(blocks, block)
 	}
+	blocksJSON := make([]*BlockJSON, 0, len(blocks))
+	for _, block := range blocks {
+		blocksJSON = append(blocksJSON, NewBlockJSON(block, blocks[0].Height-block.Height+1, true))
+	}
+	cli.output(blocksJSON, func() {
+		for _, block := range blocks {
+			fmt.Println("===============================================[Block]===============================================")
+			fmt.Println(block.String())
+		}
+	})
 }
 
-func (cli *Cli) GetBalance(bc *BlockChain, address string) {
+func (cli *Cli) GetBalance(bc *BlockChain, address string) error {
 	pubKeyHash, err := GetPubKeyHashFromAddress(address)
 	if err != nil {
-		fmt.Println("invalid address: ", address)
-		return
+		return fmt.Errorf("invalid address: %s", address)
 	}
 	_, total := bc.FindUtxo(pubKeyHash)
-	fmt.Printf("[%s] remain utxos: %d\n", address, total)
+	cli.output(total, func() { fmt.Printf("[%s] remain utxos: %d\n", address, total) })
+	return nil
+}
+
+// Send mines the transaction in a new block at once
+func (cli *Cli) Send(bc *BlockChain, from, to string, amount, fee int64, lockTime, sequence uint32, minerAddress string, data string) error {
+	miningTx := NewMiningTx(minerAddress, data, bc.GetBestHeight()+1)
+	tx, err := NewTransaction(from, to, amount, fee, lockTime, sequence, bc, cli.wallet())
+	if err != nil {
+		return fmt.Errorf("transfer [%d] from [%s] to [%s] failed: %v", amount, from, to, err)
+	}
+
+	block, err := bc.AddBlock([]*Transaction{miningTx, tx})
+	if err != nil {
+		return fmt.Errorf("transfer [%d] from [%s] to [%s] failed: %v", amount, from, to, err)
+	}
+	cli.setVar("lasttx", hex.EncodeToString(tx.Id))
+	cli.setVar("lastblock", hex.EncodeToString(block.Hash))
+	cli.output(hex.EncodeToString(tx.Id), func() {
+		fmt.Printf("Transfer [%d] from [%s] to [%s] success.\n", amount, from, to)
+	})
+	return nil
+}
+
+// Notarize commits data in a transaction paid by from, mined at once by minerAddress or
+// relayed to the node at addr if it's not empty
+func (cli *Cli) Notarize(bc *BlockChain, from string, data []byte, fee int64, minerAddress, addr string) error {
+	tx, err := NewDataTransaction(from, data, fee, bc, cli.wallet())
+	if err == nil {
+		err = cli.submitTx(bc, tx, minerAddress, addr)
+	}
+	if err != nil {
+		return fmt.Errorf("notarize %x failed: %v", data, err)
+	}
+	cli.output(hex.EncodeToString(tx.Id), func() { fmt.Printf("Data %x is committed in transaction %x\n", data, tx.Id) })
+	return nil
+}
+
+// submitTx mines tx at once in a block rewarding minerAddress, or relays it to the node
+// at addr if it's not empty
+func (cli *Cli) submitTx(bc *BlockChain, tx *Transaction, minerAddress, addr string) error {
+	if addr != "" {
+		if err := RelayTransaction(addr, tx); err != nil {
+			return fmt.Errorf("relay transaction to %s failed: %v", addr, err)
+		}
+	} else {
+		miningTx := NewMiningTx(minerAddress, "", bc.GetBestHeight()+1)
+		block, err := bc.AddBlock([]*Transaction{miningTx, tx})
+		if err != nil {
+			return err
+		}
+		cli.setVar("lastblock", hex.EncodeToString(block.Hash))
+	}
+	cli.setVar("lasttx", hex.EncodeToString(tx.Id))
+	return nil
+}
+
+// CreateSwap locks amount of from in a contract for to, the initiator makes the secret
+// and the participant passes secretHash of the initiator's contract
+func (cli *Cli) CreateSwap(bc *BlockChain, from, to string, amount, fee int64, secretHash []byte, timeout time.Duration, minerAddress, addr string) error {
+	var secret []byte
+	if secretHash == nil {
+		var err error
+		if secret, secretHash, err = NewSwapSecret(); err != nil {
+			return err
+		}
+	}
+	lockTime, err := SwapLockTime(timeout)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	tx, err := NewSwapTransaction(from, to, amount, fee, secretHash, lockTime, bc, cli.wallet())
+	if err == nil {
+		err = cli.submitTx(bc, tx, minerAddress, addr)
+	}
+	if err != nil {
+		return fmt.Errorf("create contract failed: %v", err)
+	}
+	swap, err := NewSwapJSON(tx)
+	if err != nil {
+		return err
+	}
+	if secret != nil {
+		swap.Secret = hex.EncodeToString(secret)
+	}
+	if addr == "" {
+		swap.Confirmations = 1
+	}
+	cli.ShowSwap(swap)
+	return nil
+}
+
+// SpendSwap redeems the contract in the transaction with txid with secret, or refunds
+// it if secret is nil
+func (cli *Cli) SpendSwap(bc *BlockChain, txid, secret []byte, fee int64, minerAddress, addr string) error {
+	contractTx := bc.FindTransaction(txid)
+	if contractTx == nil {
+		return fmt.Errorf("transaction %x not found", txid)
+	}
+	tx, err := NewSwapSpendTransaction(contractTx, secret, fee, bc, cli.wallet())
+	if err == nil {
+		err = cli.submitTx(bc, tx, minerAddress, addr)
+	}
+	action := "redeem"
+	if secret == nil {
+		action = "refund"
+	}
+	if err != nil {
+		return fmt.Errorf("%s contract %x failed: %v", action, txid, err)
+	}
+	cli.output(hex.EncodeToString(tx.Id), func() { fmt.Printf("Contract %x is %sed in transaction %x\n", txid, action, tx.Id) })
+	return nil
+}
+
+// ShowSwap prints a contract
+func (cli *Cli) ShowSwap(swap *SwapJSON) {
+	cli.output(swap, func() {
+		fmt.Printf("Contract      : %s:%d\n", swap.Txid, swap.Vout)
+		fmt.Printf("Amount        : %d\n", swap.Amount)
+		fmt.Printf("Recipient     : %s\n", swap.Recipient)
+		fmt.Printf("Refund        : %s after %s\n", swap.Refund, lockTimeString(swap.LockTime))
+		fmt.Printf("Secret hash   : %s\n", swap.SecretHash)
+		if swap.Secret != "" {
+			fmt.Printf("Secret        : %s\n", swap.Secret)
+		}
+		fmt.Printf("Confirmations : %d\n", swap.Confirmations)
+		if swap.SpentBy != "" {
+			fmt.Printf("Spent by      : %s\n", swap.SpentBy)
+		}
+	})
+}
+
+// ShowNotarization prints where data was committed
+func (cli *Cli) ShowNotarization(n *NotarizationJSON) {
+	cli.output(n, func() {
+		fmt.Printf("Data %s is committed in transaction %s\n", n.Data, n.Txid)
+		fmt.Printf("Block %s at height %d, %d confirmations\n", n.BlockHash, n.Height, n.Confirmations)
+		fmt.Printf("Time %s\n", time.Unix(int64(n.Time), 0).UTC().Format(time.RFC3339))
+	})
+}
+
+// ShowChainInfo prints info of the local chain or the result of getblockchaininfo
+func (cli *Cli) ShowChainInfo(info *ChainInfoJSON) {
+	cli.output(info, func() {
+		fmt.Printf("Network       : %s\n", info.Network)
+		fmt.Printf("Blocks        : %d\n", info.Blocks)
+		fmt.Printf("Headers       : %d\n", info.Headers)
+		fmt.Printf("Best block    : %s\n", info.BestBlockHash)
+		fmt.Printf("Median time   : %d\n", info.MedianTime)
+		fmt.Printf("Size on disk  : %d bytes\n", info.SizeOnDisk)
+		if info.Pruned {
+			fmt.Printf("Pruned        : yes, blocks from height %d are kept\n", info.PruneHeight)
+		} else {
+			fmt.Printf("Pruned        : no\n")
+		}
+	})
 }
 
-func (cli *Cli) Send(bc *BlockChain, from, to string, amount int64, minerPubKey string, data string) {
-	// bc, err := GetBlockChain()
-	// if err != nil {
-	// 	fmt.Println("Can't get blockchain: ", err)
-	// }
-	// defer bc.Close()
+// ChangeChain runs rollback, invalidateblock or reconsiderblock on the local chain
+func (cli *Cli) ChangeChain(f func(bc *BlockChain) ([]*Block, []*Block, error)) error {
+	return cli.withChain(func(bc *BlockChain) error {
+		disconnected, connected, err := f(bc)
+		if err != nil {
+			return err
+		}
+		result := NewChainChangeJSON(bc, disconnected, connected)
+		cli.setVar("lastblock", result.BestBlockHash)
+		cli.output(result, func() {
+			fmt.Printf("Disconnected %d blocks, connected %d blocks, tail is block %s at height %d\n",
+				result.Disconnected, result.Connected, result.BestBlockHash, result.Height)
+		})
+		return nil
+	})
+}
 
-	miningTx := NewMiningTx(minerPubKey, data)
-	tx, err := NewTransaction(from, to, amount, bc)
+// ExportChain writes blocks from height from to height to into a bootstrap file
+func (cli *Cli) ExportChain(bc *BlockChain, path string, from, to uint64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s fail: %v", path, err)
+	}
+	w := bufio.NewWriter(f)
+	n, err := ExportBlocks(bc, w, from, to)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
 	if err != nil {
-		fmt.Printf("Transfer [%d] from [%s] to [%s] failed: %s\n", amount, from, to, err)
+		os.Remove(path)
+		return fmt.Errorf("export chain fail: %v", err)
+	}
+	result := map[string]interface{}{"file": path, "blocks": n, "from": from, "to": to}
+	cli.output(result, func() { fmt.Printf("Exported %d blocks from height %d to %d to %s\n", n, from, to, path) })
+	return nil
+}
+
+// ImportChain connects blocks of a bootstrap file, an empty chain is created if there is none
+func (cli *Cli) ImportChain(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s fail: %v", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// check the network before an empty chain is created
+	r := &countingReader{r: f}
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(4); err != nil || !bytes.Equal(magic, activeNetwork.Magic[:]) {
+		return fmt.Errorf("%s is not a bootstrap file of %s", path, activeNetwork.Name)
+	}
+	if cli.bc == nil && !storeBackend.Exists() {
+		bc, err := OpenBlockChain()
+		if err != nil {
+			return fmt.Errorf("can't create blockchain: %v", err)
+		}
+		bc.Close()
+	}
+
+	return cli.withChain(func(bc *BlockChain) error {
+		last, printed := time.Now(), false
+		// progress goes to stderr, stdout only has the result
+		result, err := ImportBlocks(bc, br, func(result *ImportResult) {
+			if time.Since(last) < time.Second {
+				return
+			}
+			last, printed = time.Now(), true
+			fmt.Fprintf(os.Stderr, "\rimported %d blocks, skipped %d, height %d, %.0f%%    ",
+				result.Imported, result.Skipped, result.Height, float64(r.n)*100/float64(info.Size()))
+		})
+		if printed {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err != nil {
+			return fmt.Errorf("import chain fail after %d new blocks: %v", result.Imported, err)
+		}
+		cli.output(result, func() {
+			fmt.Printf("Imported %d blocks, skipped %d already in chain, best height %d\n",
+				result.Imported, result.Skipped, result.Height)
+		})
+		return nil
+	})
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// GenerateBlocks mines count empty blocks on regtest, for tests without a running node
+func (cli *Cli) GenerateBlocks(bc *BlockChain, count int, address string) error {
+	if !activeNetwork.MineBlocksOnDemand {
+		return fmt.Errorf("blocks can't be generated on %s", activeNetwork.Name)
+	}
+	hashes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		height := bc.GetBestHeight() + 1
+		block, err := bc.AddBlock([]*Transaction{NewMiningTx(address, "generated", height)})
+		if err != nil {
+			return fmt.Errorf("generate block at height %d fail: %v", height, err)
+		}
+		hashes = append(hashes, hex.EncodeToString(block.Hash))
+		cli.setVar("lastblock", hashes[i])
+	}
+	cli.output(hashes, func() {
+		for _, hash := range hashes {
+			fmt.Println(hash)
+		}
+	})
+	return nil
+}
+
+// SendToNode builds the transaction with local chain and relays it to a node to be mined
+func (cli *Cli) SendToNode(bc *BlockChain, from, to string, amount, fee int64, lockTime, sequence uint32, addr string) error {
+	tx, err := NewTransaction(from, to, amount, fee, lockTime, sequence, bc, cli.wallet())
+	if err != nil {
+		return fmt.Errorf("transfer [%d] from [%s] to [%s] failed: %v", amount, from, to, err)
+	}
+	if err := RelayTransaction(addr, tx); err != nil {
+		return fmt.Errorf("relay transaction to %s failed: %v", addr, err)
+	}
+	cli.setVar("lasttx", hex.EncodeToString(tx.Id))
+	cli.output(hex.EncodeToString(tx.Id), func() { fmt.Printf("Transaction %x relayed to %s\n", tx.Id, addr) })
+	return nil
+}
+
+func (cli *Cli) RunNode() error {
+	if cli.Miner != "" {
+		if err := ValidateAddress(cli.Miner); err != nil {
+			return usageErrorf("invalid address %s: %s", cli.Miner, err)
+		}
+	}
+	bc, err := OpenBlockChain()
+	if err != nil {
+		return fmt.Errorf("can't open blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	port := cli.Port
+	if port == 0 {
+		port = activeNetwork.DefaultPort
+	}
+	var seeds []string
+	if cli.Connect != "" {
+		seeds = strings.Split(cli.Connect, ",")
+	}
+	node := NewNode(bc, net.JoinHostPort(cli.ExternalIP, strconv.Itoa(port)), cli.Miner)
+	var server *RPCServer
+	if cli.Server || cli.Rest || cli.Explorer {
+		mux := http.NewServeMux()
+		if cli.Server {
+			server, err = NewRPCServer(node, cli.RPCUser, cli.RPCPassword)
+			if err != nil {
+				return fmt.Errorf("can't start rpc server: %v", err)
+			}
+			defer server.Close()
+			mux.Handle("/", server)
+			mux.HandleFunc("/ws", server.ServeWebSocket)
+		}
+		if cli.Rest {
+			RegisterREST(mux, node)
+		}
+		if cli.Explorer {
+			if err := RegisterExplorer(mux, node); err != nil {
+				return fmt.Errorf("can't load explorer pages: %v", err)
+			}
+		}
+		addr := cli.rpcAddr(cli.RPCBind)
+		fmt.Printf("HTTP server listening on %s, JSON-RPC: %v, REST: %v, explorer: %v\n", addr, cli.Server, cli.Rest, cli.Explorer)
+		go func() {
+			if err := ListenHTTP(addr, mux); err != nil {
+				fmt.Println("http server stopped: ", err)
+			}
+		}()
+	}
+	// close the store and remove the cookie file on ctrl-c
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		fmt.Println("Node stopping")
+		if server != nil {
+			server.Close()
+		}
+		node.mu.Lock() // wait for the block being written
+		bc.Close()
+		os.Exit(exitOK)
+	}()
+	if err := node.Start(port, seeds); err != nil {
+		return fmt.Errorf("node stopped: %v", err)
+	}
+	return nil
+}
+
+func (cli *Cli) rpcAddr(host string) string {
+	port := cli.RPCPort
+	if port == 0 {
+		port = activeNetwork.RPCPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// CallRPC sends the request to a node's rpc server and prints the result
+func (cli *Cli) CallRPC(method string, params []interface{}) error {
+	addr := cli.RPCConnect
+	if addr == "" {
+		addr = cli.rpcAddr("127.0.0.1")
+	}
+	client, err := NewRPCClient(addr, cli.RPCUser, cli.RPCPassword)
+	if err != nil {
+		return err
+	}
+	result, err := client.Call(method, params...)
+	if err != nil {
+		return fmt.Errorf("rpc %s fail: %w", method, err)
+	}
+	cli.output(result, func() { printJSON(os.Stdout, result) })
+	return nil
+}
+
+// printJSON prints strings without quotes and others indented
+func printJSON(w io.Writer, result json.RawMessage) {
+	var str string
+	if json.Unmarshal(result, &str) == nil {
+		fmt.Fprintln(w, str)
 		return
 	}
+	var out bytes.Buffer
+	if json.Indent(&out, result, "", "  ") != nil {
+		fmt.Fprintln(w, string(result))
+		return
+	}
+	fmt.Fprintln(w, out.String())
+}
+
+// ShowSyncStatus asks the node at p2p address addr, or the local node on the default port
+func (cli *Cli) ShowSyncStatus(addr string) error {
+	if addr == "" {
+		addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(activeNetwork.DefaultPort))
+	}
+	status, err := GetNodeStatus(addr)
+	if err != nil {
+		return fmt.Errorf("get sync status from %s fail: %v", addr, err)
+	}
+	if cli.Format == "json" {
+		writeJSON(os.Stdout, status)
+		return nil
+	}
+	state := "synced"
+	if status.HeaderHeight > status.BlockHeight {
+		state = "downloading blocks"
+	}
+	progress := 100.0
+	if status.HeaderHeight >= 0 {
+		progress = float64(status.BlockHeight+1) / float64(status.HeaderHeight+1) * 100
+	}
+	fmt.Printf("State         : %s\n", state)
+	fmt.Printf("Blocks        : %d\n", status.BlockHeight)
+	fmt.Printf("Headers       : %d\n", status.HeaderHeight)
+	fmt.Printf("Progress      : %.2f%%\n", progress)
+	fmt.Printf("In flight     : %d\n", status.InFlight)
+	fmt.Printf("Downloaded    : %d, waiting for parents\n", status.Downloaded)
+	fmt.Printf("Peers         : %d\n", len(status.Peers))
+	for _, peer := range status.Peers {
+		direction := "outbound"
+		if peer.Inbound {
+			direction = "inbound"
+		}
+		fmt.Printf("  %s %s %s best height %d, %d blocks in flight\n",
+			peer.Addr, direction, peer.UserAgent, peer.BestHeight, peer.InFlight)
+	}
+	return nil
+}
+
+type VanityJSON struct {
+	Address string  `json:"address"`
+	Keys    uint64  `json:"keys"` // keys tried
+	Seconds float64 `json:"seconds"`
+}
 
-	err = bc.AddBlock([]*Transaction{miningTx, tx})
+func (cli *Cli) VanityGen(prefix string) error {
+	difficulty, err := VanityDifficulty(prefix)
 	if err != nil {
-		fmt.Printf("Transfer [%d] from [%s] to [%s] failed: %s\n", amount, from, to, err)
+		return usageErrorf("%v", err)
+	}
+	// progress goes to stderr, stdout only has the result
+	fmt.Fprintf(os.Stderr, "Difficulty: %.0f keys expected, searching with %d cores\n", difficulty, runtime.NumCPU())
+
+	var tried uint64
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n := atomic.LoadUint64(&tried)
+				rate := float64(n) / time.Since(start).Seconds()
+				expected, _ := new(big.Float).Quo(difficulty, big.NewFloat(rate)).Float64()
+				fmt.Fprintf(os.Stderr, "\rtried %d keys, %.0f keys/s, expected time %s    ",
+					n, rate, time.Duration(expected*float64(time.Second)).Round(time.Second))
+			}
+		}
+	}()
+	wallet := SearchVanity(prefix, &tried)
+	close(done)
+
+	fmt.Fprintln(os.Stderr)
+
+	wm := cli.wallet()
+	address := wm.AddWallet(wallet, "vanity")
+	cli.setVar("lastaddress", address)
+	elapsed := time.Since(start)
+	result := &VanityJSON{address, atomic.LoadUint64(&tried), elapsed.Seconds()}
+	cli.output(result, func() {
+		fmt.Printf("Found %s after %d keys in %s, imported to wallet\n",
+			address, result.Keys, elapsed.Round(time.Millisecond))
+	})
+	return nil
+}
+
+type WalletFileJSON struct {
+	File    string `json:"file"`
+	OK      bool   `json:"ok"`
+	Version uint32 `json:"version,omitempty"`
+	Keys    int    `json:"keys,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// VerifyWalletFiles verifies the file at path, or the wallet file and all its backups if path is empty
+func (cli *Cli) VerifyWalletFiles(path string) error {
+	files := []string{path}
+	if path == "" {
+		files = []string{GetDataPath(walletFile)}
+		for n := 1; n <= walletBackupCount; n++ {
+			if IsFileExist(walletBackupPath(GetDataPath(walletFile), n)) {
+				files = append(files, walletBackupPath(GetDataPath(walletFile), n))
+			}
+		}
+	}
+	failed := 0
+	results := make([]WalletFileJSON, 0, len(files))
+	for _, file := range files {
+		wm, version, err := VerifyWalletFile(file)
+		if err != nil {
+			results = append(results, WalletFileJSON{File: file, Error: err.Error()})
+			failed++
+			continue
+		}
+		results = append(results, WalletFileJSON{File: file, OK: true, Version: version, Keys: len(wm.Wallets)})
+	}
+	cli.output(results, func() {
+		for _, result := range results {
+			if !result.OK {
+				fmt.Printf("%s: FAIL, %s\n", result.File, result.Error)
+				continue
+			}
+			fmt.Printf("%s: OK, version %d, %d keys\n", result.File, result.Version, result.Keys)
+		}
+	})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d wallet files failed", failed, len(files))
+	}
+	return nil
+}
+
+type LabelJSON struct {
+	Label   string `json:"label"`
+	Balance int64  `json:"balance"`
+}
+
+func (cli *Cli) ListLabelBalances(bc *BlockChain) {
+	wm := cli.wallet()
+	labels := make([]LabelJSON, 0)
+	for _, label := range wm.ListLabels() {
+		var total int64 = 0
+		for _, address := range wm.GetAddressesByLabel(label) {
+			pubKeyHash, _ := GetPubKeyHashFromAddress(address)
+			_, balance := bc.FindUtxo(pubKeyHash)
+			total += balance
+		}
+		labels = append(labels, LabelJSON{label, total})
+	}
+	cli.output(labels, func() {
+		for _, label := range labels {
+			fmt.Printf("%q : %d\n", label.Label, label.Balance)
+		}
+	})
+}
+
+func (cli *Cli) ListWalletTransactions(bc *BlockChain, count, skip int) {
+	wm := cli.wallet()
+	wtxs := ListTransactions(BuildWalletHistory(bc, wm), count, skip)
+	if cli.Format == "json" {
+		wtxsJSON := make([]*WalletTxJSON, 0, len(wtxs))
+		for _, wtx := range wtxs {
+			wtxsJSON = append(wtxsJSON, NewWalletTxJSON(wtx, wm, false))
+		}
+		writeJSON(os.Stdout, wtxsJSON)
 		return
 	}
-	fmt.Printf("Transfer [%d] from [%s] to [%s] success.\n", amount, from, to)
+	fmt.Printf("%-64s  %-8s  %8s  %4s  %6s  %6s  %s\n", "txid", "category", "amount", "fee", "confs", "height", "time")
+	for _, wtx := range wtxs {
+		fmt.Printf("%-64x  %-8s  %8d  %4d  %6d  %6d  %s\n", wtx.TxId, wtx.Category, wtx.Amount, wtx.Fee,
+			wtx.Confirmations, wtx.Height, time.Unix(wtx.Time, 0).Format(time.DateTime))
+	}
+}
+
+func (cli *Cli) GetWalletTransaction(bc *BlockChain, txid []byte) error {
+	wm := cli.wallet()
+	wtx, err := GetWalletTransaction(BuildWalletHistory(bc, wm), txid)
+	if err != nil {
+		return err
+	}
+	cli.output(NewWalletTxJSON(wtx, wm, true), func() {
+		fmt.Println(wtx)
+		fmt.Println("Outputs       :")
+		fmt.Print(wtx.Details(wm))
+	})
+	return nil
 }
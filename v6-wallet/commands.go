@@ -0,0 +1,828 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Command is a subcommand of the cli: bc [global options] <name> [options] [args]
+type Command struct {
+	Name    string
+	Args    string // usage of positional args
+	Summary string
+	MinArgs int
+	MaxArgs int // -1 for no limit
+	Flags   *flag.FlagSet
+
+	// Local runs the command in this process, nil if it needs a running node
+	Local func(args []string) error
+	// RPC returns the request sent instead with -rpcconnect, nil if there is none
+	RPC func(args []string) (string, []interface{}, error)
+}
+
+func (cli *Cli) newCommand(name, args, summary string, minArgs, maxArgs int) *Command {
+	cmd := &Command{
+		Name:    name,
+		Args:    args,
+		Summary: summary,
+		MinArgs: minArgs,
+		MaxArgs: maxArgs,
+		Flags:   flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+	cmd.Flags.Usage = func() { cli.commandUsage(cmd) }
+	return cmd
+}
+
+// withChain runs f with the blockchain store, which must exist. The console keeps
+// it open for later commands.
+func (cli *Cli) withChain(f func(bc *BlockChain) error) error {
+	if cli.bc != nil {
+		return f(cli.bc)
+	}
+	bc, err := GetBlockChain()
+	if err != nil {
+		return fmt.Errorf("can't get blockchain: %v", err)
+	}
+	if cli.console {
+		cli.bc = bc
+		return f(bc)
+	}
+	defer bc.Close()
+	return f(bc)
+}
+
+// wallet returns the wallet loaded from file, which the console loads once
+func (cli *Cli) wallet() *WalletManager {
+	if !cli.console {
+		return NewWalletManager()
+	}
+	if cli.wm == nil {
+		cli.wm = NewWalletManager()
+	}
+	return cli.wm
+}
+
+func parseCount(name, s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, usageErrorf("%s must be a positive number: %s", name, s)
+	}
+	return n, nil
+}
+
+func validateAddressArg(name, address string) error {
+	if address == "" {
+		return usageErrorf("--%s is required", name)
+	}
+	if err := ValidateAddress(address); err != nil {
+		return usageErrorf("invalid address %s: %s", address, err)
+	}
+	return nil
+}
+
+// newCommands builds all commands in the order of the usage
+func (cli *Cli) newCommands() []*Command {
+	var commands []*Command
+	add := func(cmd *Command) *Command {
+		commands = append(commands, cmd)
+		return cmd
+	}
+
+	// chain
+
+	cmd := add(cli.newCommand("create", "<miner-address> [genesis-info]", "create a new blockchain", 1, 2))
+	cmd.Local = func(args []string) error {
+		if err := validateAddressArg("miner-address", args[0]); err != nil {
+			return err
+		}
+		genesisInfo := activeNetwork.GenesisInfo
+		if len(args) == 2 {
+			genesisInfo = args[1]
+		}
+		block, err := CreateBlockChain(args[0], genesisInfo)
+		if err != nil {
+			return fmt.Errorf("create blockchain fail: %v", err)
+		}
+		cli.setVar("lastblock", hex.EncodeToString(block.Hash))
+		cli.output(NewBlockJSON(block, 1, true), func() {
+			fmt.Printf("Blockchain created on %s, genesis block %x\n", activeNetwork.Name, block.Hash)
+		})
+		return nil
+	}
+
+	cmd = add(cli.newCommand("print", "", "print blocks from the tail", 0, 0))
+	printCount := cmd.Flags.Int("count", 1, "number of blocks, 1 to 20")
+	cmd.Local = func(args []string) error {
+		if *printCount <= 0 || *printCount > 20 {
+			return usageErrorf("--count must be 1 to 20")
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			cli.Print(bc, *printCount)
+			return nil
+		})
+	}
+
+	cmd = add(cli.newCommand("getbalance", "<address>", "get balance of an address", 1, 1))
+	cmd.Local = func(args []string) error {
+		if err := validateAddressArg("address", args[0]); err != nil {
+			return err
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			return cli.GetBalance(bc, args[0])
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "getbalance", []interface{}{args[0]}, nil
+	}
+
+	cmd = add(cli.newCommand("send", "", "send coins, mined at once by --miner, or relayed to --connect", 0, 0))
+	from := cmd.Flags.String("from", "", "sender address in wallet")
+	to := cmd.Flags.String("to", "", "receiver address")
+	amount := cmd.Flags.Int64("amount", 0, "amount to send")
+	fee := cmd.Flags.Int64("fee", 0, "fee left to the miner")
+	sendMiner := cmd.Flags.String("miner", "", "mine the transaction at once and reward this address")
+	sendData := cmd.Flags.String("data", "", "data of the mining transaction with --miner")
+	sendConnect := cmd.Flags.String("connect", "", "relay the transaction to the node at <host:port> instead of mining it")
+	lockTime := cmd.Flags.Uint64("locktime", 0, "lock the transaction until after this height, or unix time from 500000000")
+	sequence := cmd.Flags.Uint64("sequence", 0, "sequence of every input, locks it for blocks or 512 seconds units since its output was mined")
+	checkSend := func() error {
+		if err := validateAddressArg("from", *from); err != nil {
+			return err
+		}
+		if err := validateAddressArg("to", *to); err != nil {
+			return err
+		}
+		if *amount <= 0 {
+			return usageErrorf("--amount must be positive")
+		}
+		if *fee < 0 {
+			return usageErrorf("--fee must not be negative")
+		}
+		if *lockTime > math.MaxUint32 || *sequence > math.MaxUint32 {
+			return usageErrorf("--locktime and --sequence must fit in 32 bits")
+		}
+		return nil
+	}
+	cmd.Local = func(args []string) error {
+		if err := checkSend(); err != nil {
+			return err
+		}
+		if *sendConnect == "" {
+			if err := validateAddressArg("miner", *sendMiner); err != nil {
+				return err
+			}
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			if *sendConnect != "" {
+				return cli.SendToNode(bc, *from, *to, *amount, *fee, uint32(*lockTime), uint32(*sequence), *sendConnect)
+			}
+			return cli.Send(bc, *from, *to, *amount, *fee, uint32(*lockTime), uint32(*sequence), *sendMiner, *sendData)
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		if err := checkSend(); err != nil {
+			return "", nil, err
+		}
+		params := []interface{}{*from, *to, *amount, *fee}
+		if *lockTime != 0 || *sequence != 0 {
+			params = append(params, *lockTime, *sequence)
+		}
+		return "send", params, nil
+	}
+
+	cmd = add(cli.newCommand("generate", "<count> [address]", "mine blocks at once on regtest, the address defaults to --miner of the node with -rpcconnect", 1, 2))
+	cmd.Local = func(args []string) error {
+		count, err := parseCount("count", args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) < 2 {
+			return usageErrorf("address is required without -rpcconnect")
+		}
+		if err := validateAddressArg("address", args[1]); err != nil {
+			return err
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			return cli.GenerateBlocks(bc, count, args[1])
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		count, err := parseCount("count", args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		params := []interface{}{count}
+		if len(args) == 2 {
+			params = append(params, args[1])
+		}
+		return "generate", params, nil
+	}
+
+	cmd = add(cli.newCommand("notarize", "<file|hex>", "commit sha256 of a file, or hex data, in a transaction mined at once by --miner, or relayed to --connect", 1, 1))
+	notarizeFrom := cmd.Flags.String("from", "", "address in wallet paying the fee")
+	notarizeFee := cmd.Flags.Int64("fee", 0, "fee left to the miner")
+	notarizeMiner := cmd.Flags.String("miner", "", "mine the transaction at once and reward this address")
+	notarizeConnect := cmd.Flags.String("connect", "", "relay the transaction to the node at <host:port> instead of mining it")
+	checkNotarize := func(arg string) ([]byte, error) {
+		if err := validateAddressArg("from", *notarizeFrom); err != nil {
+			return nil, err
+		}
+		if *notarizeFee < 0 {
+			return nil, usageErrorf("--fee must not be negative")
+		}
+		data, err := NotarizationData(arg)
+		if err != nil {
+			return nil, usageErrorf("%v", err)
+		}
+		return data, nil
+	}
+	cmd.Local = func(args []string) error {
+		data, err := checkNotarize(args[0])
+		if err != nil {
+			return err
+		}
+		if *notarizeConnect == "" {
+			if err := validateAddressArg("miner", *notarizeMiner); err != nil {
+				return err
+			}
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			return cli.Notarize(bc, *notarizeFrom, data, *notarizeFee, *notarizeMiner, *notarizeConnect)
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		data, err := checkNotarize(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "notarize", []interface{}{*notarizeFrom, hex.EncodeToString(data), *notarizeFee}, nil
+	}
+
+	cmd = add(cli.newCommand("verifynotarization", "<file|hex>", "find the block and time sha256 of a file, or hex data, was committed in", 1, 1))
+	cmd.Local = func(args []string) error {
+		data, err := NotarizationData(args[0])
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			tx, block, err := bc.FindNotarization(data)
+			if err != nil {
+				return err
+			}
+			cli.ShowNotarization(NewNotarizationJSON(data, tx, block, bc.GetBestHeight()))
+			return nil
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		data, err := NotarizationData(args[0])
+		if err != nil {
+			return "", nil, usageErrorf("%v", err)
+		}
+		return "verifynotarization", []interface{}{hex.EncodeToString(data)}, nil
+	}
+
+	// initiateswap and participateswap differ in the secret hash and the default timeout
+	addSwapCommand := func(name, summary string, participate bool, timeout time.Duration) {
+		cmd := add(cli.newCommand(name, "", summary, 0, 0))
+		from := cmd.Flags.String("from", "", "address in wallet locking the coins and refunded after the timeout")
+		to := cmd.Flags.String("to", "", "address redeeming the contract with the secret")
+		amount := cmd.Flags.Int64("amount", 0, "amount to lock")
+		fee := cmd.Flags.Int64("fee", 0, "fee left to the miner")
+		timeoutFlag := cmd.Flags.Duration("timeout", timeout, "refund the contract after this time")
+		miner := cmd.Flags.String("miner", "", "mine the transaction at once and reward this address")
+		connect := cmd.Flags.String("connect", "", "relay the transaction to the node at <host:port> instead of mining it")
+		var secretHashFlag *string
+		if participate {
+			secretHashFlag = cmd.Flags.String("secrethash", "", "secret hash of the initiator's contract")
+		}
+		checkSwap := func() ([]byte, error) {
+			if err := validateAddressArg("from", *from); err != nil {
+				return nil, err
+			}
+			if err := validateAddressArg("to", *to); err != nil {
+				return nil, err
+			}
+			if *amount <= 0 {
+				return nil, usageErrorf("--amount must be positive")
+			}
+			if *fee < 0 {
+				return nil, usageErrorf("--fee must not be negative")
+			}
+			if *timeoutFlag < time.Second {
+				return nil, usageErrorf("--timeout must be at least 1s")
+			}
+			if !participate {
+				return nil, nil
+			}
+			secretHash, err := hex.DecodeString(*secretHashFlag)
+			if err != nil || len(secretHash) != sha256.Size {
+				return nil, usageErrorf("--secrethash must be %d bytes in hex", sha256.Size)
+			}
+			return secretHash, nil
+		}
+		cmd.Local = func(args []string) error {
+			secretHash, err := checkSwap()
+			if err != nil {
+				return err
+			}
+			if *connect == "" {
+				if err := validateAddressArg("miner", *miner); err != nil {
+					return err
+				}
+			}
+			return cli.withChain(func(bc *BlockChain) error {
+				return cli.CreateSwap(bc, *from, *to, *amount, *fee, secretHash, *timeoutFlag, *miner, *connect)
+			})
+		}
+		cmd.RPC = func(args []string) (string, []interface{}, error) {
+			secretHash, err := checkSwap()
+			if err != nil {
+				return "", nil, err
+			}
+			params := []interface{}{*from, *to, *amount}
+			if participate {
+				params = append(params, hex.EncodeToString(secretHash))
+			}
+			return name, append(params, *fee, int64(*timeoutFlag/time.Second)), nil
+		}
+	}
+	addSwapCommand("initiateswap", "lock coins in a contract for --to with a new secret, the first step of an atomic swap", false, initiatorTimeout)
+	addSwapCommand("participateswap", "lock coins in a contract for --to with the secret hash of the initiator's contract on the other chain", true, participantTimeout)
+
+	// redeemswap and refundswap spend a contract, to the key in wallet
+	addSpendSwapCommand := func(name, usage, summary string, redeem bool) {
+		n := 1
+		if redeem {
+			n = 2
+		}
+		cmd := add(cli.newCommand(name, usage, summary, n, n))
+		fee := cmd.Flags.Int64("fee", 0, "fee left to the miner")
+		miner := cmd.Flags.String("miner", "", "mine the transaction at once and reward this address")
+		connect := cmd.Flags.String("connect", "", "relay the transaction to the node at <host:port> instead of mining it")
+		checkSpend := func(args []string) (txid, secret []byte, err error) {
+			if txid, err = hex.DecodeString(args[0]); err != nil {
+				return nil, nil, usageErrorf("invalid transaction id %s", args[0])
+			}
+			if redeem {
+				if secret, err = hex.DecodeString(args[1]); err != nil || len(secret) == 0 {
+					return nil, nil, usageErrorf("invalid secret %s", args[1])
+				}
+			}
+			if *fee < 0 {
+				return nil, nil, usageErrorf("--fee must not be negative")
+			}
+			return txid, secret, nil
+		}
+		cmd.Local = func(args []string) error {
+			txid, secret, err := checkSpend(args)
+			if err != nil {
+				return err
+			}
+			if *connect == "" {
+				if err := validateAddressArg("miner", *miner); err != nil {
+					return err
+				}
+			}
+			return cli.withChain(func(bc *BlockChain) error {
+				return cli.SpendSwap(bc, txid, secret, *fee, *miner, *connect)
+			})
+		}
+		cmd.RPC = func(args []string) (string, []interface{}, error) {
+			if _, _, err := checkSpend(args); err != nil {
+				return "", nil, err
+			}
+			params := make([]interface{}, 0, n+1)
+			for _, arg := range args {
+				params = append(params, arg)
+			}
+			return name, append(params, *fee), nil
+		}
+	}
+	addSpendSwapCommand("redeemswap", "<txid> <secret>", "redeem the contract of a transaction with the secret", true)
+	addSpendSwapCommand("refundswap", "<txid>", "refund the contract of a transaction after its lock time", false)
+
+	cmd = add(cli.newCommand("auditswap", "<txid>", "show the contract of a transaction, and the secret if it's redeemed", 1, 1))
+	cmd.Local = func(args []string) error {
+		txid, err := hex.DecodeString(args[0])
+		if err != nil {
+			return usageErrorf("invalid transaction id %s", args[0])
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			swap, err := bc.AuditSwap(txid)
+			if err != nil {
+				return err
+			}
+			cli.ShowSwap(swap)
+			return nil
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "auditswap", []interface{}{args[0]}, nil
+	}
+
+	cmd = add(cli.newCommand("getblockchaininfo", "", "show height, size and prune state of the chain", 0, 0))
+	cmd.Local = func(args []string) error {
+		return cli.withChain(func(bc *BlockChain) error {
+			cli.ShowChainInfo(NewChainInfoJSON(bc))
+			return nil
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "getblockchaininfo", nil, nil
+	}
+
+	cmd = add(cli.newCommand("pruneblockchain", "<height>", "drop transactions of blocks below height, needs -prune, the last 288 blocks are kept", 1, 1))
+	cmd.Local = func(args []string) error {
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return usageErrorf("invalid height %s", args[0])
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			pruneHeight, err := bc.PruneBlocks(height)
+			if err != nil {
+				return err
+			}
+			cli.output(pruneHeight, func() { fmt.Printf("Blocks from height %d are kept\n", pruneHeight) })
+			return nil
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", nil, usageErrorf("invalid height %s", args[0])
+		}
+		return "pruneblockchain", []interface{}{height}, nil
+	}
+
+	cmd = add(cli.newCommand("rollback", "<height>", "disconnect and delete blocks above height, peers send them again", 1, 1))
+	cmd.Local = func(args []string) error {
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return usageErrorf("invalid height %s", args[0])
+		}
+		return cli.ChangeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+			blocks, err := bc.Rollback(height)
+			return blocks, nil, err
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", nil, usageErrorf("invalid height %s", args[0])
+		}
+		return "rollback", []interface{}{height}, nil
+	}
+
+	cmd = add(cli.newCommand("invalidateblock", "<hash>", "disconnect a block and blocks after it, they are kept and not accepted again until reconsidered", 1, 1))
+	cmd.Local = func(args []string) error {
+		hash, err := hex.DecodeString(args[0])
+		if err != nil {
+			return usageErrorf("invalid block hash %s", args[0])
+		}
+		return cli.ChangeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+			blocks, err := bc.InvalidateBlock(hash)
+			return blocks, nil, err
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "invalidateblock", []interface{}{args[0]}, nil
+	}
+
+	cmd = add(cli.newCommand("reconsiderblock", "<hash>", "reconnect blocks disconnected by invalidateblock if they are higher than the chain", 1, 1))
+	cmd.Local = func(args []string) error {
+		hash, err := hex.DecodeString(args[0])
+		if err != nil {
+			return usageErrorf("invalid block hash %s", args[0])
+		}
+		return cli.ChangeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+			return bc.ReconsiderBlock(hash)
+		})
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "reconsiderblock", []interface{}{args[0]}, nil
+	}
+
+	cmd = add(cli.newCommand("exportchain", "<file> [from] [to]", "write blocks from height from (0) to height to (best) into a bootstrap file", 1, 3))
+	cmd.Local = func(args []string) error {
+		heights := make([]uint64, 0, 2)
+		for _, arg := range args[1:] {
+			height, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return usageErrorf("invalid height %s", arg)
+			}
+			heights = append(heights, height)
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			from, to := uint64(0), bc.GetBestHeight()
+			if len(heights) > 0 {
+				from = heights[0]
+			}
+			if len(heights) > 1 {
+				to = heights[1]
+			}
+			return cli.ExportChain(bc, args[0], from, to)
+		})
+	}
+
+	cmd = add(cli.newCommand("importchain", "<file>", "validate and connect blocks of a bootstrap file, run it again to resume", 1, 1))
+	cmd.Local = func(args []string) error {
+		return cli.ImportChain(args[0])
+	}
+
+	// wallet
+
+	cmd = add(cli.newCommand("createwallet", "[label]", "create a new wallet, print its Base58Check and bech32 address", 0, 1))
+	cmd.Local = func(args []string) error {
+		wm := cli.wallet()
+		address := wm.CreateWallet(argOrEmpty(args, 0))
+		bech32 := wm.GetWallet(address).GetBech32Address()
+		cli.setVar("lastaddress", address)
+		cli.output(map[string]string{"address": address, "bech32": bech32}, func() {
+			fmt.Printf("New wallet created: %s\n", address)
+			fmt.Printf("Bech32 address: %s\n", bech32)
+		})
+		return nil
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		var params []interface{}
+		if len(args) > 0 {
+			params = append(params, args[0])
+		}
+		return "createwallet", params, nil
+	}
+
+	cmd = add(cli.newCommand("listaddresses", "", "list all addresses in wallet with their labels", 0, 0))
+	cmd.Local = func(args []string) error {
+		wm := cli.wallet()
+		cli.output(NewAddressesJSON(wm), func() {
+			addresses := wm.ListAllAddresses()
+			fmt.Printf("All addresses in wallet (address : bech32 : label : created at : note):\n")
+			sort.Strings(addresses)
+			for _, address := range addresses {
+				fmt.Println(address)
+			}
+		})
+		return nil
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "listaddresses", nil, nil
+	}
+
+	cmd = add(cli.newCommand("listtransactions", "", "list recent wallet transactions", 0, 0))
+	txCount := cmd.Flags.Int("count", 10, "number of transactions")
+	txSkip := cmd.Flags.Int("skip", 0, "number of newest transactions to skip")
+	cmd.Local = func(args []string) error {
+		if *txCount < 0 || *txSkip < 0 {
+			return usageErrorf("--count and --skip must not be negative")
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			cli.ListWalletTransactions(bc, *txCount, *txSkip)
+			return nil
+		})
+	}
+
+	cmd = add(cli.newCommand("gettransaction", "<txid>", "get detail of a wallet transaction", 1, 1))
+	cmd.Local = func(args []string) error {
+		txid, err := hex.DecodeString(args[0])
+		if err != nil {
+			return usageErrorf("invalid txid: %s", args[0])
+		}
+		return cli.withChain(func(bc *BlockChain) error {
+			return cli.GetWalletTransaction(bc, txid)
+		})
+	}
+
+	cmd = add(cli.newCommand("setlabel", "<address> <label>", "set label of an address in wallet", 2, 2))
+	cmd.Local = func(args []string) error {
+		if err := cli.wallet().SetLabel(args[0], args[1]); err != nil {
+			return fmt.Errorf("set %s fail: %v", args[0], err)
+		}
+		cli.output(map[string]string{"address": args[0], "label": args[1]}, func() {})
+		return nil
+	}
+
+	cmd = add(cli.newCommand("setnote", "<address> <note>", "set note of an address in wallet", 2, 2))
+	cmd.Local = func(args []string) error {
+		if err := cli.wallet().SetNote(args[0], args[1]); err != nil {
+			return fmt.Errorf("set %s fail: %v", args[0], err)
+		}
+		cli.output(map[string]string{"address": args[0], "note": args[1]}, func() {})
+		return nil
+	}
+
+	cmd = add(cli.newCommand("getaddressesbylabel", "<label>", "list addresses with a label", 1, 1))
+	cmd.Local = func(args []string) error {
+		addresses := append([]string{}, cli.wallet().GetAddressesByLabel(args[0])...)
+		cli.output(addresses, func() {
+			for _, address := range addresses {
+				fmt.Println(address)
+			}
+		})
+		return nil
+	}
+
+	cmd = add(cli.newCommand("listlabels", "", "list all labels with their balances", 0, 0))
+	cmd.Local = func(args []string) error {
+		return cli.withChain(func(bc *BlockChain) error {
+			cli.ListLabelBalances(bc)
+			return nil
+		})
+	}
+
+	cmd = add(cli.newCommand("backupwallet", "<path>", "copy wallet file to a file or dir", 1, 1))
+	cmd.Local = func(args []string) error {
+		path, err := cli.wallet().Backup(args[0])
+		if err != nil {
+			return fmt.Errorf("backup wallet fail: %v", err)
+		}
+		cli.output(map[string]string{"path": path}, func() { fmt.Printf("Wallet backed up to %s\n", path) })
+		return nil
+	}
+
+	cmd = add(cli.newCommand("verifywallet", "[path]", "verify wallet file and its backups, or the file at path", 0, 1))
+	cmd.Local = func(args []string) error {
+		return cli.VerifyWalletFiles(argOrEmpty(args, 0))
+	}
+
+	cmd = add(cli.newCommand("signmessage", "<address> <message>", "sign a message with the key of an address", 2, 2))
+	cmd.Local = func(args []string) error {
+		wallet := cli.wallet().GetWallet(args[0])
+		if wallet == nil {
+			return fmt.Errorf("address is not in wallet: %s", args[0])
+		}
+		signature, err := SignMessage(wallet, args[1])
+		if err != nil {
+			return fmt.Errorf("sign message fail: %v", err)
+		}
+		cli.output(signature, func() { fmt.Println(signature) })
+		return nil
+	}
+
+	cmd = add(cli.newCommand("verifymessage", "<address> <signature> <message>", "verify a signed message", 3, 3))
+	cmd.Local = func(args []string) error {
+		if err := VerifyMessage(args[0], args[1], args[2]); err != nil {
+			return fmt.Errorf("verify message fail: %v", err)
+		}
+		cli.output(map[string]bool{"valid": true}, func() { fmt.Println("signature is valid") })
+		return nil
+	}
+
+	// raw data
+
+	cmd = add(cli.newCommand("decoderawtransaction", "<hex|->", "decode a hex encoded transaction, - reads it from stdin", 1, 1))
+	cmd.Local = func(args []string) error {
+		str, err := readHexArg(args[0])
+		if err != nil {
+			return err
+		}
+		tx, err := DecodeHexTransaction(str)
+		if err != nil {
+			return err
+		}
+		txJSON := NewTxJSON(tx)
+		cli.output(txJSON, func() { writeJSON(os.Stdout, txJSON) })
+		return nil
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		str, err := readHexArg(args[0])
+		return "decoderawtransaction", []interface{}{str}, err
+	}
+
+	cmd = add(cli.newCommand("decodeblock", "<hex|->", "decode a hex encoded block, - reads it from stdin", 1, 1))
+	cmd.Local = func(args []string) error {
+		str, err := readHexArg(args[0])
+		if err != nil {
+			return err
+		}
+		block, err := DecodeHexBlock(str)
+		if err != nil {
+			return err
+		}
+		blockJSON := NewDecodedBlockJSON(block)
+		cli.output(blockJSON, func() { writeJSON(os.Stdout, blockJSON) })
+		return nil
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		str, err := readHexArg(args[0])
+		return "decodeblock", []interface{}{str}, err
+	}
+
+	cmd = add(cli.newCommand("vanitygen", "<prefix>", "generate an address with a prefix and import it to wallet", 1, 1))
+	cmd.Local = func(args []string) error {
+		return cli.VanityGen(args[0])
+	}
+
+	// node
+
+	cmd = add(cli.newCommand("startnode", "", "start a p2p node", 0, 0))
+	cmd.Flags.IntVar(&cli.Port, "port", 0, "listening port of node, default port of the network if 0")
+	cmd.Flags.StringVar(&cli.Connect, "connect", "", "peers to connect, separated by comma")
+	cmd.Flags.StringVar(&cli.Miner, "miner", "", "node mines transactions it receives and rewards this address")
+	cmd.Flags.StringVar(&cli.ExternalIP, "externalip", "127.0.0.1", "ip address of node advertised to peers")
+	cmd.Flags.BoolVar(&cli.Server, "server", false, "serve JSON-RPC requests and websocket notifications at /ws")
+	cmd.Flags.StringVar(&cli.RPCBind, "rpcbind", "127.0.0.1", "ip address of the JSON-RPC server")
+	cmd.Flags.BoolVar(&cli.Rest, "rest", false, "serve read-only REST requests on the rpc port, no auth needed")
+	cmd.Flags.BoolVar(&cli.Explorer, "explorer", false, "serve block explorer web pages under /explorer/ on the rpc port")
+	cmd.Local = func(args []string) error {
+		return cli.RunNode()
+	}
+
+	cmd = add(cli.newCommand("getsyncstatus", "", "show sync progress of a running node", 0, 0))
+	syncConnect := cmd.Flags.String("connect", "", "p2p address <host:port> of the node, the local node on the default port if empty")
+	cmd.Local = func(args []string) error {
+		return cli.ShowSyncStatus(*syncConnect)
+	}
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		return "getsyncstatus", nil, nil
+	}
+
+	cmd = add(cli.newCommand("rpc", "<method> [params...]", "call a JSON-RPC method of a running node, rpc help lists methods", 1, -1))
+	cmd.RPC = func(args []string) (string, []interface{}, error) {
+		// numbers, booleans and JSON values are passed as is, others as strings
+		var params []interface{}
+		for _, arg := range args[1:] {
+			var value interface{}
+			if json.Unmarshal([]byte(arg), &value) == nil {
+				params = append(params, json.RawMessage(arg))
+			} else {
+				params = append(params, arg)
+			}
+		}
+		return args[0], params, nil
+	}
+
+	// cli
+
+	cmd = add(cli.newCommand("console", "", "run commands read from stdin with chain and wallet kept open, $lasttx, $lastblock and $lastaddress hold results", 0, 0))
+	cmd.Local = func(args []string) error {
+		return cli.RunConsole()
+	}
+
+	cmd = add(cli.newCommand("completion", "<bash|zsh>", "print shell completion script, e.g. source <(bc completion bash)", 1, 1))
+	cmd.Local = func(args []string) error {
+		return cli.PrintCompletion(args[0])
+	}
+
+	cmd = add(cli.newCommand("help", "[command]", "show usage of all commands or one of them", 0, 1))
+	cmd.Local = func(args []string) error {
+		if len(args) == 0 {
+			cli.usage()
+			return nil
+		}
+		c := cli.command(args[0])
+		if c == nil {
+			return usageErrorf("unknown command %s", args[0])
+		}
+		cli.commandUsage(c)
+		return nil
+	}
+
+	return commands
+}
+
+// readHexArg reads stdin if arg is -
+func readHexArg(arg string) (string, error) {
+	if arg != "-" {
+		return arg, nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("read stdin fail: %v", err)
+	}
+	return string(data), nil
+}
+
+func argOrEmpty(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// usageError is reported with the usage of the command and exit code 2
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, a...)}
+}
+
+func isUsageError(err error) bool {
+	var e *usageError
+	return errors.As(err, &e)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PrintCompletion writes the completion script of shell to stdout, zsh uses the bash
+// script through bashcompinit
+func (cli *Cli) PrintCompletion(shell string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = cli.bashCompletion()
+	case "zsh":
+		script = "autoload -U +X compinit && compinit\nautoload -U +X bashcompinit && bashcompinit\n" + cli.bashCompletion()
+	default:
+		return usageErrorf("unsupported shell %s: bash or zsh", shell)
+	}
+	_, err := fmt.Fprint(os.Stdout, script)
+	return err
+}
+
+func flagNames(fs *flag.FlagSet) string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, "--"+f.Name) })
+	return strings.Join(names, " ")
+}
+
+func (cli *Cli) bashCompletion() string {
+	// the function name can't have every char a file name can
+	fn := "_" + regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(cli.name, "_") + "_complete"
+	names := make([]string, 0, len(cli.commands))
+	for _, cmd := range cli.commands {
+		names = append(names, cmd.Name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s completion, load it with: source <(%s completion bash)\n", cli.name, cli.name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur prev cmd w\n")
+	b.WriteString("\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("\tprev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("\tfor w in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do\n")
+	fmt.Fprintf(&b, "\t\tcase \"$w\" in\n\t\t%s) cmd=\"$w\"; break ;;\n\t\tesac\n", strings.Join(names, "|"))
+	b.WriteString("\tdone\n")
+	b.WriteString("\tcase \"$prev\" in\n")
+	b.WriteString("\t-network|--network) COMPREPLY=($(compgen -W \"mainnet testnet regtest\" -- \"$cur\")); return ;;\n")
+	b.WriteString("\t-dbbackend|--dbbackend) COMPREPLY=($(compgen -W \"" + strings.Join(StoreBackendNames(), " ") + "\" -- \"$cur\")); return ;;\n")
+	b.WriteString("\t-format|--format) COMPREPLY=($(compgen -W \"text json\" -- \"$cur\")); return ;;\n")
+	b.WriteString("\t-loglevel|--loglevel) COMPREPLY=($(compgen -W \"" + strings.Join(logLevelNames, " ") + "\" -- \"$cur\")); return ;;\n")
+	b.WriteString("\tesac\n")
+	b.WriteString("\tcase \"$cmd\" in\n")
+	fmt.Fprintf(&b, "\t\"\") COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\")) ;;\n", strings.Join(names, " "), flagNames(cli.global))
+	b.WriteString("\thelp) COMPREPLY=($(compgen -W \"" + strings.Join(names, " ") + "\" -- \"$cur\")) ;;\n")
+	b.WriteString("\tcompletion) COMPREPLY=($(compgen -W \"bash zsh\" -- \"$cur\")) ;;\n")
+	for _, cmd := range cli.commands {
+		if options := flagNames(cmd.Flags); options != "" {
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", cmd.Name, options)
+		}
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", fn, cli.name)
+	return b.String()
+}
@@ -0,0 +1,161 @@
+// settings are read from, in order of precedence: the command line, environment variables
+// named BITCOIN_DEMO_<FLAG> (e.g. BITCOIN_DEMO_RPCPASSWORD), the config file and defaults.
+//
+// The config file is bitcoin-demo.conf in the data dir, or the one given by -conf, with
+// flag names as keys, options under [mainnet], [testnet] or [regtest] only apply to that network:
+//
+//	# comment
+//	network=regtest
+//	server=1
+//
+//	[regtest]
+//	rpcport=18500
+//	miner=mu68xiAkBxM5DuStCqD43szfxVpvryyHpB
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	configFile = "bitcoin-demo.conf"
+	envPrefix  = "BITCOIN_DEMO_"
+)
+
+// defaultDataDir is ~/.bitcoin-demo, or the working dir if home is unknown
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".bitcoin-demo")
+}
+
+// parseConfig returns options by section, options before any section are under ""
+func parseConfig(r io.Reader) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %s", lineNum, line)
+			}
+			params, err := GetNetworkParams(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			section = params.Name
+			if sections[section] == nil {
+				sections[section] = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: option must be key=value", lineNum)
+		}
+		sections[section][key] = strings.TrimSpace(value)
+	}
+	return sections, scanner.Err()
+}
+
+// LoadSettings fills flags of sets not given on the command line from environment and
+// config file, sets[0] holds network, datadir and conf. Options of the config file must
+// be known, but not necessarily in sets, as other commands may use them.
+func LoadSettings(known map[string]bool, sets ...*flag.FlagSet) error {
+	given := make(map[string]bool)
+	for _, fs := range sets {
+		fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	}
+
+	for _, fs := range sets {
+		var err error
+		fs.VisitAll(func(f *flag.Flag) {
+			name := envPrefix + strings.ToUpper(f.Name)
+			value, ok := os.LookupEnv(name)
+			if err != nil || given[f.Name] || !ok {
+				return
+			}
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid environment variable %s: %v", name, e)
+			}
+			given[f.Name] = true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	global := sets[0]
+	path := global.Lookup("conf").Value.String()
+	if path == "" {
+		path = filepath.Join(global.Lookup("datadir").Value.String(), configFile)
+		if !IsFileExist(path) {
+			return nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file: %v", err)
+	}
+	defer f.Close()
+	sections, err := parseConfig(f)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	// the network decides which section applies
+	if value, ok := sections[""]["network"]; ok && !given["network"] {
+		if err := global.Set("network", value); err != nil {
+			return fmt.Errorf("invalid config file %s: invalid option network: %v", path, err)
+		}
+		given["network"] = true
+	}
+	params, err := GetNetworkParams(global.Lookup("network").Value.String())
+	if err != nil {
+		return err
+	}
+	for _, section := range []string{params.Name, ""} {
+		if err := applyOptions(sections[section], known, given, sets); err != nil {
+			return fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// applyOptions sets flags not in given
+func applyOptions(options map[string]string, known, given map[string]bool, sets []*flag.FlagSet) error {
+	for key, value := range options {
+		if key == "datadir" || key == "conf" {
+			return fmt.Errorf("%s can't be set in config file", key)
+		}
+		if !known[key] {
+			return fmt.Errorf("unknown option %s", key)
+		}
+		if given[key] {
+			continue
+		}
+		for _, fs := range sets {
+			if fs.Lookup(key) == nil {
+				continue
+			}
+			if err := fs.Set(key, value); err != nil {
+				return fmt.Errorf("invalid option %s: %v", key, err)
+			}
+		}
+		given[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	conf := `
+# comment
+network = regtest
+server=1
+
+[test]
+rpcport=18500
+[regtest]
+miner=mu68xiAkBxM5DuStCqD43szfxVpvryyHpB
+rpcpassword=a=b
+`
+	sections, err := parseConfig(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"":        {"network": "regtest", "server": "1"},
+		"testnet": {"rpcport": "18500"},
+		"regtest": {"miner": "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB", "rpcpassword": "a=b"},
+	}
+	if len(sections) != len(want) {
+		t.Fatalf("got %d sections, want %d", len(sections), len(want))
+	}
+	for name, options := range want {
+		for key, value := range options {
+			if sections[name][key] != value {
+				t.Errorf("[%s] %s: got %q, want %q", name, key, sections[name][key], value)
+			}
+		}
+		if len(sections[name]) != len(options) {
+			t.Errorf("[%s]: got %d options, want %d", name, len(sections[name]), len(options))
+		}
+	}
+
+	for _, invalid := range []string{"server", "=1", "[regtest", "[unknown]"} {
+		if _, err := parseConfig(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q: no error", invalid)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// console builtins besides the commands
+var consoleBuiltins = []string{"set", "vars", "exit", "quit"}
+
+// RunConsole reads commands from stdin until exit or EOF, chain and wallet are kept open
+// between them. With a terminal it has line editing, history and tab completion.
+func (cli *Cli) RunConsole() error {
+	cli.console = true
+	defer func() {
+		if cli.bc != nil {
+			cli.bc.Close()
+			cli.bc = nil
+		}
+		cli.wm = nil
+		cli.console = false
+	}()
+
+	fd := int(os.Stdin.Fd())
+	interactive := term.IsTerminal(fd)
+	var readLine func() (string, error)
+	if interactive {
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, activeNetwork.Name+"> ")
+		t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+			if key != '\t' {
+				return "", 0, false
+			}
+			newLine, newPos, matches := completeLine(line, pos, cli.completions)
+			if len(matches) > 1 {
+				// the terminal is locked during the callback
+				go fmt.Fprintln(t, strings.Join(matches, "  "))
+			}
+			return newLine, newPos, newPos != pos
+		}
+		// commands print with the terminal restored, as raw mode needs \r\n
+		readLine = func() (string, error) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return "", err
+			}
+			defer term.Restore(fd, state)
+			return t.ReadLine()
+		}
+		fmt.Printf("%s console on %s, help lists commands, exit or ctrl-d quits\n", cli.name, activeNetwork.Name)
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read console fail: %v", err)
+		}
+		args, err := splitArgs(line, func(name string) (string, bool) {
+			value, ok := cli.vars[name]
+			return value, ok
+		})
+		if err != nil {
+			cli.printError(err, exitUsage)
+			continue
+		}
+		if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+		if err := cli.runConsoleLine(args); err != nil {
+			cli.printError(err, exitUsage)
+		}
+	}
+}
+
+// runConsoleLine runs a builtin or a command
+func (cli *Cli) runConsoleLine(args []string) error {
+	switch args[0] {
+	case "set":
+		if len(args) != 3 || !isVarName(args[1]) {
+			return usageErrorf("usage: set <name> <value>, name is letters, digits and _")
+		}
+		cli.setVar(args[1], args[2])
+	case "vars":
+		names := make([]string, 0, len(cli.vars))
+		for name := range cli.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make(map[string]string)
+		for _, name := range names {
+			values[name] = cli.vars[name]
+		}
+		cli.output(values, func() {
+			for _, name := range names {
+				fmt.Printf("$%s = %s\n", name, cli.vars[name])
+			}
+		})
+	case "console", "startnode":
+		return usageErrorf("%s can't run in console", args[0])
+	default:
+		// flags keep values of the last parse, so each line gets new commands
+		cli.commands = cli.newCommands()
+		cli.execute(args)
+	}
+	return nil
+}
+
+// completions returns candidates of word, the args before it are given
+func (cli *Cli) completions(args []string, word string) []string {
+	var candidates []string
+	switch {
+	case len(args) == 0:
+		candidates = append(candidates, consoleBuiltins...)
+		for _, cmd := range cli.commands {
+			candidates = append(candidates, cmd.Name)
+		}
+	case strings.HasPrefix(word, "$"):
+		for name := range cli.vars {
+			candidates = append(candidates, "$"+name)
+		}
+	case strings.HasPrefix(word, "-"):
+		if cmd := cli.command(args[0]); cmd != nil {
+			candidates = strings.Fields(flagNames(cmd.Flags))
+		}
+	case args[0] == "help":
+		for _, cmd := range cli.commands {
+			candidates = append(candidates, cmd.Name)
+		}
+	case cli.RPCConnect == "":
+		for address := range cli.wallet().Wallets {
+			candidates = append(candidates, address)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// completeLine completes the word before pos with candidates, it returns the new line
+// and position, and the matches if there are more than one
+func completeLine(line string, pos int, candidates func(args []string, word string) []string) (string, int, []string) {
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+	var matches []string
+	for _, candidate := range candidates(strings.Fields(line[:start]), word) {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, nil
+	}
+	if len(matches) == 1 {
+		completion := matches[0] + " "
+		return line[:start] + completion + line[pos:], start + len(completion), nil
+	}
+	prefix := matches[0]
+	for _, match := range matches[1:] {
+		prefix = commonPrefix(prefix, match)
+	}
+	return line[:start] + prefix + line[pos:], start + len(prefix), matches
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+func isVarName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitArgs splits line like a shell: args are separated by spaces, quotes keep them
+// together, \ escapes a char, and $name or ${name} is replaced by lookup except in
+// single quotes
+func splitArgs(line string, lookup func(name string) (string, bool)) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'' && c != '\'':
+			arg.WriteRune(c)
+		case c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+			inArg = true
+		case quote == 0 && (c == ' ' || c == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case c == '\\':
+			if i+1 == len(runes) {
+				return nil, usageErrorf("\\ at end of line")
+			}
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case c == '$':
+			name, end := varName(runes, i+1)
+			if name == "" {
+				arg.WriteRune(c)
+				inArg = true
+				continue
+			}
+			value, ok := lookup(name)
+			if !ok {
+				return nil, usageErrorf("unknown variable $%s, vars lists them", name)
+			}
+			arg.WriteString(value)
+			inArg = true
+			i = end - 1
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, usageErrorf("unterminated %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// varName returns the name of a variable at runes[i:] after $, and the index after it
+func varName(runes []rune, i int) (string, int) {
+	if i < len(runes) && runes[i] == '{' {
+		for j := i + 1; j < len(runes); j++ {
+			if runes[j] == '}' {
+				if !isVarName(string(runes[i+1 : j])) {
+					return "", i
+				}
+				return string(runes[i+1 : j]), j + 1
+			}
+		}
+		return "", i
+	}
+	j := i
+	for j < len(runes) && isVarName(string(runes[j])) {
+		j++
+	}
+	return string(runes[i:j]), j
+}
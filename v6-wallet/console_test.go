@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	vars := map[string]string{"lasttx": "abcd", "a": "x y"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  send  --amount 1 ", []string{"send", "--amount", "1"}},
+		{`setnote addr "two words" ''`, []string{"setnote", "addr", "two words", ""}},
+		{`gettransaction $lasttx`, []string{"gettransaction", "abcd"}},
+		{`echo ${lasttx}ef "$a" $a '$a' \$a`, []string{"echo", "abcdef", "x y", "x y", "$a", "$a"}},
+		{`a\ b $ c$`, []string{"a b", "$", "c$"}},
+	}
+	for _, test := range tests {
+		got, err := splitArgs(test.line, lookup)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+		}
+	}
+
+	for _, invalid := range []string{`"open`, `'open`, `end\`, `$unknown`} {
+		if _, err := splitArgs(invalid, lookup); err == nil {
+			t.Errorf("%q: no error", invalid)
+		}
+	}
+}
+
+func TestCompleteLine(t *testing.T) {
+	candidates := func(args []string, word string) []string {
+		if len(args) == 0 {
+			return []string{"getbalance", "gettransaction", "send"}
+		}
+		return []string{"--amount", "--from"}
+	}
+	tests := []struct {
+		line    string
+		pos     int
+		want    string
+		wantPos int
+		matches int
+	}{
+		{"se", 2, "send ", 5, 0},
+		{"ge", 2, "get", 3, 2},
+		{"send --f", 8, "send --from ", 12, 0},
+		{"send --x", 8, "send --x", 8, 0},
+		{"se --amount", 2, "send  --amount", 5, 0},
+	}
+	for _, test := range tests {
+		got, pos, matches := completeLine(test.line, test.pos, candidates)
+		if got != test.want || pos != test.wantPos || len(matches) != test.matches {
+			t.Errorf("%q: got %q %d %q", test.line, got, pos, matches)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// EventBus publishes what happens in a node to subscribers, e.g. websocket clients,
+// publishing never blocks, a subscriber that can't keep up is dropped
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	TopicNewTip            = "newtip"
+	TopicBlockConnected    = "blockconnected"
+	TopicBlockDisconnected = "blockdisconnected" // the tail is rolled back
+	TopicTxAccepted        = "txaccepted"        // accepted to mempool
+	TopicWalletTx          = "wallettx"          // paying to or spending from wallet keys, in mempool or a new block
+)
+
+var eventTopics = []string{TopicNewTip, TopicBlockConnected, TopicBlockDisconnected, TopicTxAccepted, TopicWalletTx}
+
+type Event struct {
+	Topic     string     `json:"topic"`
+	Time      int64      `json:"time"`
+	Height    int64      `json:"height"`
+	Hash      string     `json:"hash,omitempty"` // block hash, empty for mempool transactions
+	Block     *BlockJSON `json:"block,omitempty"`
+	Tx        *TxJSON    `json:"tx,omitempty"`
+	Addresses []string   `json:"addresses,omitempty"` // addresses of outputs and inputs, used by filters
+}
+
+type Subscription struct {
+	C      chan *Event
+	bus    *EventBus
+	mu     sync.Mutex
+	topics map[string]bool
+	addrs  map[string]bool // matches events involving any of them, all events if empty
+	closed bool
+}
+
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription to no topic, C is closed when it's dropped
+func (bus *EventBus) Subscribe(bufSize int) *Subscription {
+	sub := &Subscription{
+		C:      make(chan *Event, bufSize),
+		bus:    bus,
+		topics: make(map[string]bool),
+		addrs:  make(map[string]bool),
+	}
+	bus.mu.Lock()
+	bus.subs[sub] = true
+	bus.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe closes C of sub, it's safe to call more than once
+func (bus *EventBus) Unsubscribe(sub *Subscription) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		delete(bus.subs, sub)
+		close(sub.C)
+	}
+}
+
+// HasSubscribers tells if anyone listens to topic, so expensive events can be skipped
+func (bus *EventBus) HasSubscribers(topic string) bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for sub := range bus.subs {
+		sub.mu.Lock()
+		ok := sub.topics[topic]
+		sub.mu.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (bus *EventBus) Publish(e *Event) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for sub := range bus.subs {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			// too slow, drop it instead of blocking the node
+			sub.closed = true
+			delete(bus.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+func (sub *Subscription) matches(e *Event) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.topics[e.Topic] {
+		return false
+	}
+	if len(sub.addrs) == 0 || len(e.Addresses) == 0 {
+		return true
+	}
+	for _, addr := range e.Addresses {
+		if sub.addrs[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+// Update adds or removes topics and addresses, returns the current ones
+func (sub *Subscription) Update(add bool, topics, addrs []string) ([]string, []string) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for _, topic := range topics {
+		if add {
+			sub.topics[topic] = true
+		} else {
+			delete(sub.topics, topic)
+		}
+	}
+	for _, addr := range addrs {
+		if add {
+			sub.addrs[addr] = true
+		} else {
+			delete(sub.addrs, addr)
+		}
+	}
+	curTopics := make([]string, 0, len(sub.topics))
+	for _, topic := range eventTopics {
+		if sub.topics[topic] {
+			curTopics = append(curTopics, topic)
+		}
+	}
+	curAddrs := make([]string, 0, len(sub.addrs))
+	for addr := range sub.addrs {
+		curAddrs = append(curAddrs, addr)
+	}
+	return curTopics, curAddrs
+}
+
+// txAddresses returns addresses of outputs and inputs of tx, inputs are presented
+// as Base58Check addresses as their output type isn't known without the spent tx
+func txAddresses(tx *Transaction) []string {
+	seen := make(map[string]bool)
+	addrs := make([]string, 0)
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, output := range tx.TxOutputs {
+		if output.Type == OutputHTLC {
+			if c, err := ParseHTLC(output.ScriptPubKeyHash); err == nil {
+				add(EncodeAddress(OutputPubKeyHash, c.Recipient))
+				add(EncodeAddress(OutputPubKeyHash, c.Refund))
+			}
+		}
+		if output.Type == OutputNullData || output.Type == OutputHTLC {
+			continue
+		}
+		add(output.Address())
+		// filters hold the Base58Check form of keys, see handleWSCommand
+		add(EncodeAddress(OutputPubKeyHash, output.ScriptPubKeyHash))
+	}
+	if !tx.IsMiningTx() {
+		for _, input := range tx.TxInputs {
+			add(EncodeAddress(OutputPubKeyHash, GetPubKeyHashFromPubKey(input.PubKey)))
+		}
+	}
+	return addrs
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+// publishTx is called when tx enters mempool
+func (n *Node) publishTx(tx *Transaction) {
+	addrs := txAddresses(tx)
+	n.events.Publish(&Event{Topic: TopicTxAccepted, Height: -1, Tx: NewTxJSON(tx), Addresses: addrs})
+	if n.events.HasSubscribers(TopicWalletTx) && n.isWalletTx(tx) {
+		n.events.Publish(&Event{Topic: TopicWalletTx, Height: -1, Tx: NewTxJSON(tx), Addresses: addrs})
+	}
+}
+
+// publishBlock is called after block becomes the new tail
+func (n *Node) publishBlock(block *Block, bestHeight uint64) {
+	hash := hex.EncodeToString(block.Hash)
+	height := int64(block.Height)
+	var addrs []string
+	for _, tx := range block.Transactions {
+		addrs = append(addrs, txAddresses(tx)...)
+	}
+	n.events.Publish(&Event{Topic: TopicBlockConnected, Height: height, Hash: hash,
+		Block: NewBlockJSON(block, bestHeight-block.Height+1, false), Addresses: addrs})
+	n.events.Publish(&Event{Topic: TopicNewTip, Height: height, Hash: hash})
+	if n.events.HasSubscribers(TopicWalletTx) {
+		for _, tx := range block.Transactions {
+			if n.isWalletTx(tx) {
+				n.events.Publish(&Event{Topic: TopicWalletTx, Height: height, Hash: hash,
+					Tx: NewTxJSON(tx), Addresses: txAddresses(tx)})
+			}
+		}
+	}
+}
+
+// publishDisconnect is called after block is disconnected from the tail
+func (n *Node) publishDisconnect(block *Block) {
+	var addrs []string
+	for _, tx := range block.Transactions {
+		addrs = append(addrs, txAddresses(tx)...)
+	}
+	n.events.Publish(&Event{Topic: TopicBlockDisconnected, Height: int64(block.Height), Hash: hex.EncodeToString(block.Hash),
+		Block: NewBlockJSON(block, 0, false), Addresses: addrs})
+}
+
+// publishTip is called after the tail is moved back without connecting blocks
+func (n *Node) publishTip() {
+	n.events.Publish(&Event{Topic: TopicNewTip, Height: int64(n.bc.GetBestHeight()), Hash: hex.EncodeToString(n.bc.GetTail())})
+}
+
+// isWalletTx reads wallet file every time, addresses created by rpc are included at once
+func (n *Node) isWalletTx(tx *Transaction) bool {
+	wm := NewWalletManager()
+	for _, addr := range txAddresses(tx) {
+		if wm.GetWallet(addr) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,417 @@
+// block explorer web pages served by a node under /explorer/, templates are embedded
+// in the binary so the node can run anywhere
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed explorer/*.html
+var explorerFiles embed.FS
+
+const (
+	explorerPrefix   = "/explorer/"
+	blocksPerPage    = 20
+	explorerNotFound = "Nothing found"
+)
+
+type ExplorerServer struct {
+	node  *Node
+	pages map[string]*template.Template
+}
+
+// explorerPage is passed to the layout, Data to the page content
+type explorerPage struct {
+	Title   string
+	Network string
+	Query   string
+	Data    interface{}
+}
+
+type blockRowView struct {
+	Height  uint64
+	Hash    string
+	Time    int64
+	TxCount int
+}
+
+type indexView struct {
+	Blocks     []blockRowView
+	BestHeight int64
+	Mempool    int
+	Newer      int64 // height to start the newer page from, -1 if there is none
+	Older      int64
+}
+
+type inputView struct {
+	Coinbase string // data of the mining input
+	Txid     string
+	Index    int64
+	Address  string
+	Value    int64
+	Known    bool // value is known only if the spent transaction was looked up
+}
+
+type outputView struct {
+	Address string
+	Value   int64
+	SpentBy string
+	Script  string // description of outputs without an address
+}
+
+type txView struct {
+	Txid          string
+	BlockHash     string
+	Height        uint64
+	Confirmations uint64
+	Time          int64
+	Inputs        []inputView
+	Outputs       []outputView
+	TotalOutput   int64
+	Fee           int64
+	FeeKnown      bool
+}
+
+type blockView struct {
+	*BlockJSON
+	NextHash string
+	Txs      []*txView
+}
+
+type historyView struct {
+	Txid      string
+	BlockHash string
+	Height    uint64
+	Time      int64
+	Amount    int64 // net change of the address balance
+}
+
+type addressView struct {
+	Address   string
+	Balance   int64
+	UTXOCount int
+	Received  int64
+	Sent      int64
+	History   []historyView
+}
+
+// RegisterExplorer adds explorer pages to mux
+func RegisterExplorer(mux *http.ServeMux, node *Node) error {
+	funcs := template.FuncMap{
+		"formatTime": func(t interface{}) string {
+			var unix int64
+			switch v := t.(type) {
+			case int64:
+				unix = v
+			case uint64:
+				unix = int64(v)
+			}
+			return time.Unix(unix, 0).Format(time.DateTime)
+		},
+		"short": func(s string) string {
+			if len(s) > 16 {
+				return s[:16] + "…"
+			}
+			return s
+		},
+	}
+	s := &ExplorerServer{node: node, pages: make(map[string]*template.Template)}
+	for _, page := range []string{"index", "block", "tx", "address", "error"} {
+		tmpl, err := template.New(page).Funcs(funcs).ParseFS(explorerFiles,
+			"explorer/layout.html", "explorer/txbox.html", "explorer/"+page+".html")
+		if err != nil {
+			return err
+		}
+		s.pages[page] = tmpl
+	}
+	mux.HandleFunc(explorerPrefix, s.handle)
+	return nil
+}
+
+func (s *ExplorerServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "explorer is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	kind, arg, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, explorerPrefix), "/")
+	switch kind {
+	case "":
+		s.index(w, r)
+	case "block":
+		s.block(w, arg)
+	case "tx":
+		s.tx(w, arg)
+	case "address":
+		s.address(w, arg)
+	case "search":
+		s.search(w, r)
+	default:
+		s.render(w, http.StatusNotFound, "error", "Page not found", "", "Page not found")
+	}
+}
+
+func (s *ExplorerServer) render(w http.ResponseWriter, status int, page, title, query string, data interface{}) {
+	var buf bytes.Buffer
+	err := s.pages[page].ExecuteTemplate(&buf, "layout", &explorerPage{title, activeNetwork.Name, query, data})
+	if err != nil {
+		logError("render explorer page %s fail: %s", page, err)
+		http.Error(w, "render page fail", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func (s *ExplorerServer) notFound(w http.ResponseWriter, query string) {
+	s.render(w, http.StatusNotFound, "error", explorerNotFound, query, explorerNotFound+": "+query)
+}
+
+// index lists blocksPerPage blocks from height ?from, or from the tail
+func (s *ExplorerServer) index(w http.ResponseWriter, r *http.Request) {
+	view := &indexView{Newer: -1, Older: -1}
+	s.node.mu.Lock()
+	view.Mempool = len(s.node.mempool)
+	view.BestHeight = -1
+	if s.node.bc.GetTail() != nil {
+		view.BestHeight = int64(s.node.bc.GetBestHeight())
+	}
+	from := view.BestHeight
+	if n, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64); err == nil && n >= 0 && n < from {
+		from = n
+	}
+	iter := s.node.bc.NewIterator()
+	for block := iter.Next(); block != nil && len(view.Blocks) < blocksPerPage; block = iter.Next() {
+		if int64(block.Height) > from {
+			continue
+		}
+		view.Blocks = append(view.Blocks, blockRowView{block.Height, hex.EncodeToString(block.Hash),
+			int64(block.TimeStamp), len(block.Transactions)})
+	}
+	s.node.mu.Unlock()
+
+	if from < view.BestHeight {
+		view.Newer = from + blocksPerPage
+		if view.Newer > view.BestHeight {
+			view.Newer = view.BestHeight
+		}
+	}
+	if from-blocksPerPage >= 0 {
+		view.Older = from - blocksPerPage
+	}
+	s.render(w, http.StatusOK, "index", "Latest blocks", "", view)
+}
+
+func (s *ExplorerServer) block(w http.ResponseWriter, hashHex string) {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		s.notFound(w, hashHex)
+		return
+	}
+	s.node.mu.Lock()
+	block, err := s.node.bc.GetBlock(hash)
+	if errors.Is(err, ErrBlockPruned) {
+		s.node.mu.Unlock()
+		s.render(w, http.StatusNotFound, "error", "Block pruned", hashHex, err.Error())
+		return
+	}
+	if err != nil {
+		s.node.mu.Unlock()
+		s.notFound(w, hashHex)
+		return
+	}
+	bestHeight := s.node.bc.GetBestHeight()
+	view := &blockView{BlockJSON: NewBlockJSON(block, s.node.bc.GetConfirmations(block), false)}
+	if block.Height < bestHeight {
+		if next, err := s.node.bc.GetBlockByHeight(block.Height + 1); err == nil {
+			view.NextHash = hex.EncodeToString(next.Hash)
+		}
+	}
+	for _, tx := range block.Transactions {
+		view.Txs = append(view.Txs, newTxView(tx, nil, nil))
+	}
+	s.node.mu.Unlock()
+	s.render(w, http.StatusOK, "block", fmt.Sprintf("Block %d", block.Height), "", view)
+}
+
+// newTxView fills input values from spentTxs and spending txids from spentBy if given
+func newTxView(tx *Transaction, spentTxs map[string]*Transaction, spentBy map[int64]string) *txView {
+	view := &txView{Txid: hex.EncodeToString(tx.Id), Time: tx.TimeStamp}
+	view.FeeKnown = !tx.IsMiningTx() && spentTxs != nil
+	var inputTotal int64 = 0
+	for _, input := range tx.TxInputs {
+		if tx.IsMiningTx() {
+			view.Inputs = append(view.Inputs, inputView{Coinbase: string(input.ScriptSig)})
+			continue
+		}
+		in := inputView{
+			Txid:    hex.EncodeToString(input.TxId),
+			Index:   input.Index,
+			Address: EncodeAddress(OutputPubKeyHash, GetPubKeyHashFromPubKey(input.PubKey)),
+		}
+		if spent := spentTxs[string(input.TxId)]; spent != nil && input.Index < int64(len(spent.TxOutputs)) {
+			output := spent.TxOutputs[input.Index]
+			in.Address, in.Value, in.Known = output.Address(), output.Value, true
+			inputTotal += output.Value
+		} else {
+			view.FeeKnown = false
+		}
+		view.Inputs = append(view.Inputs, in)
+	}
+	for i, output := range tx.TxOutputs {
+		view.Outputs = append(view.Outputs, outputView{output.Address(), output.Value, spentBy[int64(i)], output.Script()})
+		view.TotalOutput += output.Value
+	}
+	if view.FeeKnown {
+		view.Fee = inputTotal - view.TotalOutput
+	}
+	return view
+}
+
+// tx looks up mempool and the chain, then scans the chain once for the transactions
+// spent by its inputs and spending its outputs
+func (s *ExplorerServer) tx(w http.ResponseWriter, txidHex string) {
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		s.notFound(w, txidHex)
+		return
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	var block *Block
+	tx := s.node.mempool[string(txid)]
+	if tx == nil {
+		tx, block = s.node.bc.FindTransactionBlock(txid)
+	}
+	if tx == nil {
+		s.notFound(w, txidHex)
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, input := range tx.TxInputs {
+		wanted[string(input.TxId)] = true
+	}
+	spentTxs := make(map[string]*Transaction)
+	spentBy := make(map[int64]string)
+	iter := s.node.bc.NewIterator()
+	for b := iter.Next(); b != nil; b = iter.Next() {
+		for _, other := range b.Transactions {
+			if wanted[string(other.Id)] {
+				spentTxs[string(other.Id)] = other
+			}
+			if other.IsMiningTx() {
+				continue
+			}
+			for _, input := range other.TxInputs {
+				if bytes.Equal(input.TxId, txid) {
+					spentBy[input.Index] = hex.EncodeToString(other.Id)
+				}
+			}
+		}
+	}
+
+	view := newTxView(tx, spentTxs, spentBy)
+	if block != nil {
+		view.BlockHash = hex.EncodeToString(block.Hash)
+		view.Height = block.Height
+		view.Confirmations = s.node.bc.GetBestHeight() - block.Height + 1
+	}
+	s.render(w, http.StatusOK, "tx", "Transaction "+txidHex, "", view)
+}
+
+// address computes balance and history in one pass over the chain
+func (s *ExplorerServer) address(w http.ResponseWriter, address string) {
+	pubKeyHash, err := GetPubKeyHashFromAddress(address)
+	if err != nil {
+		s.notFound(w, address)
+		return
+	}
+	s.node.mu.Lock()
+	utxos, balance := s.node.bc.FindUtxo(pubKeyHash)
+	outputValues := make(map[string]int64) // outputs paid to the address
+	type related struct {
+		tx    *Transaction
+		block *Block
+	}
+	var txs []related
+	iter := s.node.bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, tx := range block.Transactions {
+			if !txInvolves(tx, pubKeyHash) {
+				continue
+			}
+			txs = append(txs, related{tx, block})
+			for i, output := range tx.TxOutputs {
+				if output.PaysTo(pubKeyHash) {
+					outputValues[outPointKey(tx.Id, int64(i))] = output.Value
+				}
+			}
+		}
+	}
+	s.node.mu.Unlock()
+
+	view := &addressView{Address: address, Balance: balance, UTXOCount: len(utxos)}
+	for _, r := range txs {
+		var amount int64 = 0
+		for _, output := range r.tx.TxOutputs {
+			if output.PaysTo(pubKeyHash) {
+				amount += output.Value
+				view.Received += output.Value
+			}
+		}
+		if !r.tx.IsMiningTx() {
+			for _, input := range r.tx.TxInputs {
+				value := outputValues[outPointKey(input.TxId, input.Index)]
+				amount -= value
+				view.Sent += value
+			}
+		}
+		view.History = append(view.History, historyView{hex.EncodeToString(r.tx.Id),
+			hex.EncodeToString(r.block.Hash), r.block.Height, int64(r.block.TimeStamp), amount})
+	}
+	s.render(w, http.StatusOK, "address", "Address "+address, "", view)
+}
+
+// search accepts height, block hash, txid or address
+func (s *ExplorerServer) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if height, err := strconv.ParseUint(q, 10, 64); err == nil && len(q) < 20 {
+		s.node.mu.Lock()
+		block, err := s.node.bc.GetBlockByHeight(height)
+		s.node.mu.Unlock()
+		if err == nil {
+			http.Redirect(w, r, explorerPrefix+"block/"+hex.EncodeToString(block.Hash), http.StatusFound)
+			return
+		}
+	}
+	if hash, err := hex.DecodeString(q); err == nil && len(hash) == 32 {
+		s.node.mu.Lock()
+		isBlock := s.node.bc.HasBlock(hash)
+		_, inMempool := s.node.mempool[string(hash)]
+		isTx := inMempool || s.node.bc.FindTransaction(hash) != nil
+		s.node.mu.Unlock()
+		if isBlock {
+			http.Redirect(w, r, explorerPrefix+"block/"+q, http.StatusFound)
+			return
+		}
+		if isTx {
+			http.Redirect(w, r, explorerPrefix+"tx/"+q, http.StatusFound)
+			return
+		}
+	}
+	if ValidateAddress(q) == nil {
+		http.Redirect(w, r, explorerPrefix+"address/"+q, http.StatusFound)
+		return
+	}
+	s.notFound(w, q)
+}
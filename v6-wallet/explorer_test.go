@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExplorer(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, blocksPerPage+5)
+	mux := http.NewServeMux()
+	if err := RegisterExplorer(mux, NewNode(bc, "test", "")); err != nil {
+		t.Fatal(err)
+	}
+	blocks := chainBlocks(bc)
+	tip := hex.EncodeToString(blocks[len(blocks)-1].Hash)
+	genesis := hex.EncodeToString(blocks[0].Hash)
+	txid := hex.EncodeToString(blocks[1].Transactions[0].Id)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	pages := []struct {
+		path     string
+		status   int
+		contains []string
+	}{
+		{"/explorer/", http.StatusOK, []string{tip, "?from=4"}},
+		{"/explorer/?from=4", http.StatusOK, []string{genesis, "?from=24"}},
+		{"/explorer/block/" + tip, http.StatusOK, []string{tip}},
+		{"/explorer/block/" + strings.Repeat("ab", 32), http.StatusNotFound, []string{explorerNotFound}},
+		{"/explorer/tx/" + txid, http.StatusOK, []string{txid, address}},
+		{"/explorer/address/" + address, http.StatusOK, []string{address, txid}},
+		{"/explorer/other", http.StatusNotFound, nil},
+	}
+	for _, page := range pages {
+		w := get(page.path)
+		if w.Code != page.status {
+			t.Errorf("%s: got status %d, want %d", page.path, w.Code, page.status)
+		}
+		for _, s := range page.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: page doesn't contain %s", page.path, s)
+			}
+		}
+	}
+	if w := get("/explorer/?from=4"); strings.Contains(w.Body.String(), tip) {
+		t.Error("the older page lists the tip")
+	}
+
+	searches := []struct {
+		query, location string
+	}{
+		{"1", "/explorer/block/" + hex.EncodeToString(blocks[1].Hash)},
+		{tip, "/explorer/block/" + tip},
+		{txid, "/explorer/tx/" + txid},
+		{address, "/explorer/address/" + address},
+	}
+	for _, search := range searches {
+		w := get("/explorer/search?q=" + search.query)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != search.location {
+			t.Errorf("search %s: got status %d to %q, want %s", search.query, w.Code, w.Header().Get("Location"), search.location)
+		}
+	}
+	if w := get("/explorer/search?q=nothing"); w.Code != http.StatusNotFound {
+		t.Errorf("search of nothing got status %d", w.Code)
+	}
+}
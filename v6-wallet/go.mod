@@ -5,7 +5,13 @@ go 1.20
 require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/btcsuite/btcutil v1.0.2
+	github.com/gorilla/websocket v1.5.0
+	github.com/syndtr/goleveldb v1.0.1-0.20220721030215-126854af5e6d
 	golang.org/x/crypto v0.12.0
+	golang.org/x/term v0.14.0
 )
 
-require golang.org/x/sys v0.11.0 // indirect
+require (
+	github.com/golang/snappy v0.0.4 // indirect
+	golang.org/x/sys v0.14.0 // indirect
+)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const logFile = "output.log"
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// messages below it are dropped
+var logLevel = LogInfo
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %s: %s", s, strings.Join(logLevelNames, ", "))
+}
+
+// OpenLog appends the log to output.log in data dir of the active network,
+// so commands run beside a node don't wipe its log
+func OpenLog(level LogLevel) (*os.File, error) {
+	if err := EnsureDataDir(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(GetDataPath(logFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(f)
+	logLevel = level
+	return f, nil
+}
+
+func logf(level LogLevel, format string, a ...interface{}) {
+	if level < logLevel {
+		return
+	}
+	// skip logf and its caller to print the file of the call site
+	log.Output(3, strings.ToUpper(logLevelNames[level])+" "+fmt.Sprintf(format, a...))
+}
+
+func logDebug(format string, a ...interface{}) {
+	logf(LogDebug, format, a...)
+}
+
+func logInfo(format string, a ...interface{}) {
+	logf(LogInfo, format, a...)
+}
+
+func logWarn(format string, a ...interface{}) {
+	logf(LogWarn, format, a...)
+}
+
+func logError(format string, a ...interface{}) {
+	logf(LogError, format, a...)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// NetworkParams defines the rules that make a chain different from the others,
+// addresses, keys and blocks of one network are not accepted by another one.
+type NetworkParams struct {
+	Name  string
+	Magic [4]byte // start of every p2p message
+
+	AddressVersion byte   // version byte of Base58Check addresses
+	WIFPrefix      byte   // version byte of exported private keys
+	Bech32HRP      string // human readable part of bech32 addresses
+
+	GenesisInfo     string // default side message of the genesis block
+	PowLimit        string // hex encoded target, a block hash must be less than it
+	Reward          int64  // mining reward of the genesis block
+	HalvingInterval uint64 // the reward halves every HalvingInterval blocks, 0 means never
+
+	MineBlocksOnDemand bool // generate and mock time are allowed, for scripted tests
+
+	DefaultPort int
+	RPCPort     int
+	DataDir     string // sub directory of `-datadir` holding blockchain and wallet files
+}
+
+// testnet and regtest share the same version bytes, just like bitcoin
+var (
+	MainNetParams = NetworkParams{
+		Name:            "mainnet",
+		Magic:           [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+		AddressVersion:  0x00,
+		WIFPrefix:       0x80,
+		Bech32HRP:       "bc",
+		GenesisInfo:     "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+		PowLimit:        "0010000000000000000000000000000000000000000000000000000000000000",
+		Reward:          17,
+		HalvingInterval: 210000,
+		DefaultPort:     8333,
+		RPCPort:         8332,
+		DataDir:         "",
+	}
+	TestNetParams = NetworkParams{
+		Name:            "testnet",
+		Magic:           [4]byte{0x0b, 0x11, 0x09, 0x07},
+		AddressVersion:  0x6f,
+		WIFPrefix:       0xef,
+		Bech32HRP:       "tb",
+		GenesisInfo:     "testnet genesis block",
+		PowLimit:        "0040000000000000000000000000000000000000000000000000000000000000",
+		Reward:          17,
+		HalvingInterval: 210000,
+		DefaultPort:     18333,
+		RPCPort:         18332,
+		DataDir:         "testnet3",
+	}
+	RegTestParams = NetworkParams{
+		Name:            "regtest",
+		Magic:           [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+		AddressVersion:  0x6f,
+		WIFPrefix:       0xef,
+		Bech32HRP:       "bcrt",
+		GenesisInfo:     "regtest genesis block",
+		PowLimit:        "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		Reward:          17,
+		HalvingInterval: 150,
+
+		MineBlocksOnDemand: true,
+
+		DefaultPort: 18444,
+		RPCPort:     18443,
+		DataDir:     "regtest",
+	}
+)
+
+// network selected by `-network`
+var activeNetwork = &MainNetParams
+
+// base dir selected by `-datadir`, files of every network are under it
+var dataDir = "."
+
+func GetNetworkParams(name string) (*NetworkParams, error) {
+	switch name {
+	case MainNetParams.Name, "main":
+		return &MainNetParams, nil
+	case TestNetParams.Name, "test":
+		return &TestNetParams, nil
+	case RegTestParams.Name:
+		return &RegTestParams, nil
+	}
+	return nil, fmt.Errorf("unknown network: %s (mainnet, testnet or regtest)", name)
+}
+
+// BlockReward returns the mining reward of block at height
+func (p *NetworkParams) BlockReward(height uint64) int64 {
+	if p.HalvingInterval == 0 {
+		return p.Reward
+	}
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.Reward >> halvings
+}
+
+// GetDataPath returns the path of file name in data dir of the active network
+func GetDataPath(name string) string {
+	return filepath.Join(dataDir, activeNetwork.DataDir, name)
+}
+
+// create data dir of the active network if it doesn't exist
+func EnsureDataDir() error {
+	return os.MkdirAll(filepath.Join(dataDir, activeNetwork.DataDir), 0700)
+}
@@ -0,0 +1,912 @@
+// Node connects to peers over TCP, relays transactions and blocks, keeps blockchain synced
+// with the best peer, and mines transactions in mempool if a miner address is given
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxPeers        = 8
+	maxOutbound     = 4 // outbound connections kept, filled from known addresses
+	maxInvItems     = 500
+	maxAddrsPerMsg  = 1000
+	maxKnownAddrs   = 1000
+	maxMempoolTxs   = 5000
+	dialTimeout     = 5 * time.Second
+	connectInterval = 10 * time.Second // longer than dialTimeout, so dials don't overlap
+	pingInterval    = 30 * time.Second
+	peerTimeout     = 90 * time.Second
+)
+
+type Node struct {
+	listenAddr   string // address advertised to peers
+	minerAddress string // mine transactions in mempool if not empty
+	nonce        uint64
+
+	mu      sync.Mutex // guards bc and mempool
+	bc      *BlockChain
+	mempool map[string]*Transaction
+
+	peersMu    sync.Mutex // guards peers and knownAddrs
+	peers      map[*Peer]bool
+	knownAddrs map[string]bool
+
+	mineCh chan struct{}
+	sync   *SyncManager
+	events *EventBus
+}
+
+type Peer struct {
+	node    *Node
+	conn    net.Conn
+	inbound bool
+	addr    string // listening address of the peer, empty if it doesn't accept connections
+
+	sendMu      sync.Mutex
+	versionSent bool
+	version     *VersionMsg
+	ready       atomic.Bool // handshake finished
+	bestHeight  int64       // accessed atomically
+	lastRecv    int64       // unix nano, accessed atomically
+}
+
+func NewNode(bc *BlockChain, listenAddr, minerAddress string) *Node {
+	n := &Node{
+		listenAddr:   listenAddr,
+		minerAddress: minerAddress,
+		nonce:        randomNonce(),
+		bc:           bc,
+		mempool:      make(map[string]*Transaction),
+		peers:        make(map[*Peer]bool),
+		knownAddrs:   make(map[string]bool),
+		mineCh:       make(chan struct{}, 1),
+		events:       NewEventBus(),
+	}
+	n.sync = NewSyncManager(n)
+	return n
+}
+
+// Start listens on port, connects to seeds and serves peers until listening fails
+func (n *Node) Start(port int, seeds []string) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	logInfo("Node %s started on %s, best height %d", n.listenAddr, activeNetwork.Name, n.bestHeight())
+
+	if n.minerAddress != "" {
+		go n.mineLoop()
+	}
+	go n.pingLoop()
+	go n.connectLoop()
+	go n.sync.run()
+	for _, seed := range seeds {
+		go n.Connect(seed)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		if n.peerCount() >= maxPeers {
+			conn.Close()
+			continue
+		}
+		p := n.newPeer(conn, true, "")
+		go p.run()
+	}
+}
+
+// Connect dials addr and starts the version handshake
+func (n *Node) Connect(addr string) {
+	if addr == n.listenAddr || n.isConnected(addr) || n.peerCount() >= maxPeers {
+		return
+	}
+	n.addKnownAddr(addr)
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		logWarn("connect to %s fail: %s", addr, err)
+		n.removeKnownAddr(addr)
+		return
+	}
+	p := n.newPeer(conn, false, addr)
+	if err := p.sendVersion(); err != nil {
+		conn.Close()
+		return
+	}
+	p.run()
+}
+
+func (n *Node) newPeer(conn net.Conn, inbound bool, addr string) *Peer {
+	p := &Peer{node: n, conn: conn, inbound: inbound, addr: addr, bestHeight: -1}
+	atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+	n.peersMu.Lock()
+	n.peers[p] = true
+	n.peersMu.Unlock()
+	return p
+}
+
+func (n *Node) removePeer(p *Peer) {
+	n.peersMu.Lock()
+	delete(n.peers, p)
+	n.peersMu.Unlock()
+	n.sync.peerGone(p)
+}
+
+func (n *Node) peerCount() int {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	return len(n.peers)
+}
+
+func (n *Node) isConnected(addr string) bool {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	for p := range n.peers {
+		if p.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// addKnownAddr remembers addr unless maxKnownAddrs are known already
+func (n *Node) addKnownAddr(addr string) bool {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	if n.knownAddrs[addr] || len(n.knownAddrs) >= maxKnownAddrs {
+		return false
+	}
+	n.knownAddrs[addr] = true
+	return true
+}
+
+func (n *Node) removeKnownAddr(addr string) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	delete(n.knownAddrs, addr)
+}
+
+// getKnownAddrs returns our address and known ones, as many as an addr message holds
+func (n *Node) getKnownAddrs() []string {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	addrs := []string{n.listenAddr}
+	for addr := range n.knownAddrs {
+		if len(addrs) >= maxAddrsPerMsg {
+			break
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// outboundCandidates returns up to maxOutbound known addresses not connected, minus the
+// outbound connections there are, map order picks them at random
+func (n *Node) outboundCandidates() []string {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	connected := make(map[string]bool)
+	outbound := 0
+	for p := range n.peers {
+		connected[p.addr] = true
+		if !p.inbound {
+			outbound++
+		}
+	}
+	var addrs []string
+	for addr := range n.knownAddrs {
+		if outbound+len(addrs) >= maxOutbound || len(n.peers)+len(addrs) >= maxPeers {
+			break
+		}
+		if addr != n.listenAddr && !connected[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// readyPeers returns peers finished handshake
+func (n *Node) readyPeers() []*Peer {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	peers := make([]*Peer, 0, len(n.peers))
+	for p := range n.peers {
+		if p.ready.Load() {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// broadcast sends msg to all peers finished handshake except one
+func (n *Node) broadcast(msg *Message, except *Peer) {
+	for _, p := range n.readyPeers() {
+		if p != except {
+			p.send(msg)
+		}
+	}
+}
+
+func (n *Node) broadcastInv(invType string, hash []byte, except *Peer) {
+	msg, err := NewMessage(CmdInv, &InvMsg{invType, [][]byte{hash}})
+	if err != nil {
+		return
+	}
+	n.broadcast(msg, except)
+}
+
+// bestHeight returns -1 for an empty chain
+func (n *Node) bestHeight() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.bc.GetTail() == nil {
+		return -1
+	}
+	return int64(n.bc.GetBestHeight())
+}
+
+// acceptTx verifies tx against the chain and mempool, then adds it to mempool
+func (n *Node) acceptTx(tx *Transaction) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.mempool[string(tx.Id)]; ok {
+		return errors.New("transaction already in mempool")
+	}
+	if tx.IsMiningTx() {
+		return errors.New("mining transaction can't be relayed")
+	}
+	if !bytes.Equal(tx.CalcId(), tx.Id) {
+		return errors.New("transaction id doesn't match its content")
+	}
+	if err := tx.Check(); err != nil {
+		return err
+	}
+	for _, other := range n.mempool {
+		if tx.ConflictsWith(other) {
+			return fmt.Errorf("transaction double spends %x in mempool", other.Id)
+		}
+	}
+	if _, err := n.bc.TxFee(tx); err != nil {
+		return err
+	}
+	// only transactions that can be in the next block are kept
+	if err := n.bc.checkTxLocks(tx, n.bc.GetBestHeight()+1); err != nil {
+		return err
+	}
+	if len(n.mempool) >= maxMempoolTxs {
+		return errors.New("mempool is full")
+	}
+	n.mempool[string(tx.Id)] = tx
+	n.publishTx(tx)
+	return nil
+}
+
+// acceptBlock connects block and drops its transactions from mempool
+func (n *Node) acceptBlock(block *Block) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.bc.AcceptBlock(block); err != nil {
+		return err
+	}
+	n.removeFromMempool(block)
+	n.publishBlock(block, block.Height)
+	return nil
+}
+
+// switchBranch switches the chain to branch, the blocks from old to new building on
+// the block at height, and updates mempool and subscribers
+func (n *Node) switchBranch(height uint64, branch []*Block) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	disconnected, connected, err := n.bc.switchBranch(height, branch)
+	n.chainChanged(disconnected, connected)
+	if err != nil {
+		// blocks of the invalid branch stored before it failed are dropped, so they
+		// aren't taken for blocks we have when headers of another branch come
+		var stored []*Block
+		for _, block := range branch {
+			if n.bc.HasBlock(block.Hash) && !n.bc.isActive(block) {
+				stored = append(stored, block)
+			}
+		}
+		if err := n.bc.deleteBlocks(stored); err != nil {
+			logWarn("delete blocks of invalid branch fail: %v", err)
+		}
+	}
+	return err
+}
+
+// removeFromMempool drops transactions included in block or conflicting with them
+func (n *Node) removeFromMempool(block *Block) {
+	for _, tx := range block.Transactions {
+		delete(n.mempool, string(tx.Id))
+		for id, other := range n.mempool {
+			if tx.ConflictsWith(other) {
+				delete(n.mempool, id)
+			}
+		}
+	}
+}
+
+// chainChanged updates mempool and subscribers after blocks are disconnected from the
+// tail down and then connected from old to new, n.mu must be held
+func (n *Node) chainChanged(disconnected, connected []*Block) {
+	for _, block := range disconnected {
+		n.publishDisconnect(block)
+	}
+	for _, block := range connected {
+		n.removeFromMempool(block)
+		n.publishBlock(block, block.Height)
+	}
+	if len(connected) == 0 && len(disconnected) > 0 {
+		n.publishTip()
+	}
+	// transactions of disconnected blocks go back to mempool if they are still valid
+	for _, block := range disconnected {
+	NEXT_TX:
+		for _, tx := range block.Transactions {
+			if tx.IsMiningTx() || n.mempool[string(tx.Id)] != nil {
+				continue
+			}
+			for _, other := range n.mempool {
+				if tx.ConflictsWith(other) {
+					continue NEXT_TX
+				}
+			}
+			if _, err := n.bc.TxFee(tx); err == nil && n.bc.checkTxLocks(tx, n.bc.GetBestHeight()+1) == nil {
+				n.mempool[string(tx.Id)] = tx
+			}
+		}
+	}
+}
+
+func (n *Node) triggerMining() {
+	select {
+	case n.mineCh <- struct{}{}:
+	default:
+	}
+}
+
+// mineLoop packs mempool into a new block whenever new transactions arrive
+func (n *Node) mineLoop() {
+	for range n.mineCh {
+		// blocks mined before catching up would be orphans
+		if n.sync.IsSyncing() {
+			continue
+		}
+		n.mu.Lock()
+		if len(n.mempool) == 0 || n.bc.GetTail() == nil {
+			n.mu.Unlock()
+			continue
+		}
+		block, err := n.mineBlock(n.minerAddress)
+		n.mu.Unlock()
+
+		if err != nil {
+			logError("mine block fail: %v", err)
+			continue
+		}
+		logInfo("Mined block %x at height %d with %d transactions", block.Hash, block.Height, len(block.Transactions))
+		n.broadcastInv(InvTypeBlock, block.Hash, nil)
+	}
+}
+
+// mineBlock packs mempool into a block rewarding address on the tail, n.mu must be held
+func (n *Node) mineBlock(address string) (*Block, error) {
+	height := n.bc.GetBestHeight() + 1
+	txs := []*Transaction{NewMiningTx(address, "mined by "+n.listenAddr, height)}
+	for id, tx := range n.mempool {
+		if _, err := n.bc.TxFee(tx); err != nil {
+			delete(n.mempool, id)
+			continue
+		}
+		// kept in mempool, a rollback may lock it again for a while
+		if err := n.bc.checkTxLocks(tx, height); err != nil {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	block, err := n.bc.AddBlock(txs)
+	if err != nil {
+		return nil, err
+	}
+	n.removeFromMempool(block)
+	n.publishBlock(block, block.Height)
+	return block, nil
+}
+
+// Generate mines count blocks at once regardless of mempool, the first one includes mempool
+func (n *Node) Generate(count int, address string) ([]*Block, error) {
+	if !activeNetwork.MineBlocksOnDemand {
+		return nil, fmt.Errorf("blocks can't be generated on %s", activeNetwork.Name)
+	}
+	n.mu.Lock()
+	if n.bc.GetTail() == nil {
+		n.mu.Unlock()
+		return nil, errors.New("blockchain is empty")
+	}
+	blocks := make([]*Block, 0, count)
+	var err error
+	for i := 0; i < count; i++ {
+		var block *Block
+		if block, err = n.mineBlock(address); err != nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	n.mu.Unlock()
+
+	for _, block := range blocks {
+		n.broadcastInv(InvTypeBlock, block.Hash, nil)
+	}
+	return blocks, err
+}
+
+// connectLoop keeps maxOutbound outbound connections, dialing known addresses
+func (n *Node) connectLoop() {
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, addr := range n.outboundCandidates() {
+			go n.Connect(addr)
+		}
+	}
+}
+
+// pingLoop keeps connections alive and drops peers that stop responding
+func (n *Node) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n.peersMu.Lock()
+		peers := make([]*Peer, 0, len(n.peers))
+		for p := range n.peers {
+			peers = append(peers, p)
+		}
+		n.peersMu.Unlock()
+		for _, p := range peers {
+			if time.Since(time.Unix(0, atomic.LoadInt64(&p.lastRecv))) > peerTimeout {
+				logWarn("peer %s timeout", p)
+				p.conn.Close()
+				continue
+			}
+			if msg, err := NewMessage(CmdPing, &PingMsg{randomNonce()}); err == nil {
+				p.send(msg)
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+func (p *Peer) String() string {
+	if p.addr != "" {
+		return p.addr
+	}
+	return p.conn.RemoteAddr().String()
+}
+
+// BestHeight returns the height of the best block the peer is known to have
+func (p *Peer) BestHeight() int64 {
+	return atomic.LoadInt64(&p.bestHeight)
+}
+
+func (p *Peer) updateBestHeight(height int64) {
+	for {
+		old := atomic.LoadInt64(&p.bestHeight)
+		if height <= old || atomic.CompareAndSwapInt64(&p.bestHeight, old, height) {
+			return
+		}
+	}
+}
+
+func (p *Peer) send(msg *Message) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(peerTimeout))
+	err := WriteMessage(p.conn, msg)
+	if err != nil {
+		logWarn("send %s to %s fail: %s", msg.Command, p, err)
+	}
+	return err
+}
+
+func (p *Peer) sendPayload(command string, payload interface{}) error {
+	msg, err := NewMessage(command, payload)
+	if err != nil {
+		return err
+	}
+	return p.send(msg)
+}
+
+func (p *Peer) sendVersion() error {
+	p.versionSent = true
+	return p.sendPayload(CmdVersion, &VersionMsg{
+		Version:    protocolVersion,
+		BestHeight: p.node.bestHeight(),
+		AddrFrom:   p.node.listenAddr,
+		Nonce:      p.node.nonce,
+		UserAgent:  userAgent,
+	})
+}
+
+// run reads messages until the connection breaks or the peer misbehaves
+func (p *Peer) run() {
+	defer p.node.removePeer(p)
+	defer p.conn.Close()
+	logInfo("peer %s connected, inbound: %v", p, p.inbound)
+	for {
+		msg, err := ReadMessage(p.conn)
+		if err != nil {
+			logInfo("read from %s fail: %s", p, err)
+			return
+		}
+		atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+		if err := p.handle(msg); err != nil {
+			logWarn("disconnect %s: %s", p, err)
+			return
+		}
+	}
+}
+
+func (p *Peer) handle(msg *Message) error {
+	logDebug("received %s from %s", msg.Command, p)
+	if p.version == nil && msg.Command != CmdVersion {
+		return fmt.Errorf("%s before version", msg.Command)
+	}
+	switch msg.Command {
+	case CmdVersion:
+		return p.handleVersion(msg)
+	case CmdVerack:
+		return p.handleVerack()
+	case CmdAddr:
+		return p.handleAddr(msg)
+	case CmdGetHeaders:
+		return p.handleGetHeaders(msg)
+	case CmdHeaders:
+		var headers HeadersMsg
+		if err := msg.Decode(&headers); err != nil {
+			return err
+		}
+		return p.node.sync.handleHeaders(p, headers.Headers)
+	case CmdInv:
+		return p.handleInv(msg)
+	case CmdGetData:
+		return p.handleGetData(msg)
+	case CmdBlock:
+		return p.handleBlock(msg)
+	case CmdTx:
+		return p.handleTx(msg)
+	case CmdPing:
+		var ping PingMsg
+		if err := msg.Decode(&ping); err != nil {
+			return err
+		}
+		return p.sendPayload(CmdPong, &ping)
+	case CmdPong:
+		return nil
+	case CmdGetStatus:
+		return p.sendPayload(CmdStatus, p.node.sync.Status())
+	case CmdReject:
+		var reject RejectMsg
+		if err := msg.Decode(&reject); err == nil {
+			logWarn("%s rejected %s %x: %s", p, reject.Command, reject.Hash, reject.Reason)
+		}
+		return nil
+	}
+	logWarn("unknown command %s from %s", msg.Command, p)
+	return nil
+}
+
+func (p *Peer) handleVersion(msg *Message) error {
+	if p.version != nil {
+		return errors.New("duplicate version")
+	}
+	var version VersionMsg
+	if err := msg.Decode(&version); err != nil {
+		return err
+	}
+	if version.Nonce == p.node.nonce {
+		return errors.New("connected to self")
+	}
+	if version.Version < minProtocolVersion {
+		return fmt.Errorf("protocol version %d is obsolete", version.Version)
+	}
+	p.version = &version
+	atomic.StoreInt64(&p.bestHeight, version.BestHeight)
+	if p.inbound && version.AddrFrom != "" {
+		p.node.peersMu.Lock()
+		p.addr = version.AddrFrom
+		p.node.peersMu.Unlock()
+		p.node.addKnownAddr(version.AddrFrom)
+	}
+	if !p.versionSent {
+		if err := p.sendVersion(); err != nil {
+			return err
+		}
+	}
+	return p.sendPayload(CmdVerack, nil)
+}
+
+// handshake finished, share addresses and start syncing if the peer is ahead
+func (p *Peer) handleVerack() error {
+	p.ready.Store(true)
+	logInfo("Connected to %s (%s), best height %d", p, p.version.UserAgent, p.BestHeight())
+	if err := p.sendPayload(CmdAddr, &AddrMsg{p.node.getKnownAddrs()}); err != nil {
+		return err
+	}
+	p.node.sync.peerReady(p)
+	return nil
+}
+
+func (p *Peer) handleAddr(msg *Message) error {
+	var addrMsg AddrMsg
+	if err := msg.Decode(&addrMsg); err != nil {
+		return err
+	}
+	if len(addrMsg.Addrs) > maxAddrsPerMsg {
+		return fmt.Errorf("too many addresses: %d", len(addrMsg.Addrs))
+	}
+	// connectLoop dials them when outbound connections are missing
+	for _, addr := range addrMsg.Addrs {
+		if addr != "" && addr != p.node.listenAddr {
+			p.node.addKnownAddr(addr)
+		}
+	}
+	return nil
+}
+
+func (p *Peer) handleGetHeaders(msg *Message) error {
+	var getHeaders GetHeadersMsg
+	if err := msg.Decode(&getHeaders); err != nil {
+		return err
+	}
+	p.node.mu.Lock()
+	headers := p.node.bc.GetHeadersAfter(getHeaders.Locator, maxHeadersPerMsg)
+	p.node.mu.Unlock()
+	// empty reply tells the peer we have nothing more
+	return p.sendPayload(CmdHeaders, &HeadersMsg{headers})
+}
+
+func (p *Peer) handleInv(msg *Message) error {
+	var inv InvMsg
+	if err := msg.Decode(&inv); err != nil {
+		return err
+	}
+	if len(inv.Items) > maxInvItems {
+		return errors.New("too many inventory items")
+	}
+	unknown := make([][]byte, 0)
+	p.node.mu.Lock()
+	for _, hash := range inv.Items {
+		switch inv.Type {
+		case InvTypeBlock:
+			if !p.node.bc.HasBlock(hash) {
+				unknown = append(unknown, hash)
+			}
+		case InvTypeTx:
+			if _, ok := p.node.mempool[string(hash)]; !ok {
+				unknown = append(unknown, hash)
+			}
+		}
+	}
+	p.node.mu.Unlock()
+	if len(unknown) == 0 {
+		return nil
+	}
+	if inv.Type == InvTypeBlock {
+		// blocks are downloaded after their headers
+		p.node.sync.requestHeaders(p)
+		return nil
+	}
+	return p.sendPayload(CmdGetData, &GetDataMsg{inv.Type, unknown})
+}
+
+func (p *Peer) handleGetData(msg *Message) error {
+	var getData GetDataMsg
+	if err := msg.Decode(&getData); err != nil {
+		return err
+	}
+	for _, hash := range getData.Items {
+		switch getData.Type {
+		case InvTypeBlock:
+			p.node.mu.Lock()
+			block, err := p.node.bc.GetBlock(hash)
+			p.node.mu.Unlock()
+			if err != nil {
+				logWarn("%s requests block %x: %v", p, hash, err)
+				continue
+			}
+			if err := p.sendPayload(CmdBlock, block); err != nil {
+				return err
+			}
+		case InvTypeTx:
+			p.node.mu.Lock()
+			tx, ok := p.node.mempool[string(hash)]
+			p.node.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if err := p.sendPayload(CmdTx, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Peer) handleBlock(msg *Message) error {
+	var block Block
+	if err := msg.Decode(&block); err != nil {
+		return err
+	}
+	requested, err := p.node.sync.handleBlock(p, &block)
+	if requested {
+		return err
+	}
+
+	// unsolicited block, connect it if it extends the tail
+	err = p.node.acceptBlock(&block)
+	switch err {
+	case nil:
+		p.updateBestHeight(int64(block.Height))
+		logInfo("Accepted block %x at height %d from %s", block.Hash, block.Height, p)
+		p.node.broadcastInv(InvTypeBlock, block.Hash, p)
+	case ErrBlockExists:
+	case ErrOrphanBlock:
+		// we miss blocks between, ask for headers
+		p.node.sync.requestHeaders(p)
+	default:
+		return fmt.Errorf("invalid block %x: %s", block.Hash, err)
+	}
+	return nil
+}
+
+func (p *Peer) handleTx(msg *Message) error {
+	var tx Transaction
+	if err := msg.Decode(&tx); err != nil {
+		return err
+	}
+	if err := p.node.acceptTx(&tx); err != nil {
+		logInfo("reject transaction %x from %s: %s", tx.Id, p, err)
+		return p.sendPayload(CmdReject, &RejectMsg{CmdTx, err.Error(), tx.Id})
+	}
+	logInfo("Accepted transaction %x from %s", tx.Id, p)
+	p.node.broadcastInv(InvTypeTx, tx.Id, p)
+	p.node.triggerMining()
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+// nodeClient is a short connection to a node, used by commands talking to a running node
+type nodeClient struct {
+	conn net.Conn
+}
+
+// dialNode connects to the node at addr as a client and finishes the handshake
+func dialNode(addr string) (*nodeClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+	c := &nodeClient{conn}
+
+	version := &VersionMsg{protocolVersion, -1, "", randomNonce(), userAgent}
+	if err := c.send(CmdVersion, version); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg.Command == CmdVerack {
+			return c, nil
+		}
+		if msg.Command == CmdVersion {
+			if err := c.send(CmdVerack, nil); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+}
+
+func (c *nodeClient) send(command string, payload interface{}) error {
+	msg, err := NewMessage(command, payload)
+	if err != nil {
+		return err
+	}
+	return WriteMessage(c.conn, msg)
+}
+
+// waitFor reads messages until one with command arrives, a reject fails it
+func (c *nodeClient) waitFor(command string) (*Message, error) {
+	for {
+		msg, err := ReadMessage(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Command == command {
+			return msg, nil
+		}
+		var reject RejectMsg
+		if msg.Command == CmdReject && msg.Decode(&reject) == nil {
+			return nil, fmt.Errorf("rejected by node: %s", reject.Reason)
+		}
+	}
+}
+
+func (c *nodeClient) Close() error {
+	return c.conn.Close()
+}
+
+// RelayTransaction hands tx over to the node at addr
+func RelayTransaction(addr string, tx *Transaction) error {
+	c, err := dialNode(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.send(CmdTx, tx); err != nil {
+		return err
+	}
+	// messages are handled in order, pong means tx has been accepted
+	nonce := randomNonce()
+	if err := c.send(CmdPing, &PingMsg{nonce}); err != nil {
+		return err
+	}
+	for {
+		msg, err := c.waitFor(CmdPong)
+		if err != nil {
+			return err
+		}
+		var pong PingMsg
+		if msg.Decode(&pong) == nil && pong.Nonce == nonce {
+			return nil
+		}
+	}
+}
+
+// GetNodeStatus asks the node at addr for its sync progress
+func GetNodeStatus(addr string) (*StatusMsg, error) {
+	c, err := dialNode(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := c.send(CmdGetStatus, nil); err != nil {
+		return nil, err
+	}
+	msg, err := c.waitFor(CmdStatus)
+	if err != nil {
+		return nil, err
+	}
+	var status StatusMsg
+	if err := msg.Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func randomNonce() uint64 {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return binary.LittleEndian.Uint64(b)
+}
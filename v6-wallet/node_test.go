@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNodeLimits(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	from := wallet.GetAddress()
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: wallet}}
+	n := NewNode(newTestChain(t, from, 2), "test", "")
+	p := &Peer{node: n, addr: "peer"}
+	addrMsg := func(first, count int) *Message {
+		addrs := make([]string, count)
+		for i := range addrs {
+			addrs[i] = fmt.Sprintf("10.0.%d.%d:3000", (first+i)/256, (first+i)%256)
+		}
+		msg, err := NewMessage(CmdAddr, &AddrMsg{addrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	if err := p.handleAddr(addrMsg(0, maxAddrsPerMsg+1)); err == nil {
+		t.Error("addr message with too many addresses is accepted")
+	}
+	for _, first := range []int{0, maxAddrsPerMsg} {
+		if err := p.handleAddr(addrMsg(first, maxAddrsPerMsg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(n.knownAddrs) != maxKnownAddrs {
+		t.Errorf("got %d known addresses, want %d", len(n.knownAddrs), maxKnownAddrs)
+	}
+	if got := len(n.getKnownAddrs()); got > maxAddrsPerMsg {
+		t.Errorf("got %d addresses to share, more than an addr message holds", got)
+	}
+	// known addresses are dialed only to fill the outbound connections
+	if got := len(n.outboundCandidates()); got != maxOutbound {
+		t.Errorf("got %d addresses to dial, want %d", got, maxOutbound)
+	}
+	n.newPeer(nil, false, "10.0.0.1:3000")
+	n.newPeer(nil, true, "")
+	if got := len(n.outboundCandidates()); got != maxOutbound-1 {
+		t.Errorf("got %d addresses to dial with an outbound peer, want %d", got, maxOutbound-1)
+	}
+
+	tx, err := NewTransaction(from, "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB", 1, 0, 0, 0, n.bc, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxMempoolTxs; i++ {
+		n.mempool[fmt.Sprint(i)] = &Transaction{}
+	}
+	if err := n.acceptTx(tx); err == nil {
+		t.Error("transaction is accepted into a full mempool")
+	}
+	delete(n.mempool, "0")
+	if err := n.acceptTx(tx); err != nil {
+		t.Error(err)
+	}
+}
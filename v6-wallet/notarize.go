@@ -0,0 +1,61 @@
+// notarization commits the hash of a file, or any short data, in the null data output
+// of a transaction, the block it's mined in proves the data existed at its time
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NotarizationData returns what notarizes arg: sha256 of the file if arg is a file,
+// otherwise arg decoded from hex
+func NotarizationData(arg string) ([]byte, error) {
+	if IsFileExist(arg) {
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return nil, err
+		}
+		return hash.Sum(nil), nil
+	}
+	data, err := hex.DecodeString(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a file nor hex data", arg)
+	}
+	if len(data) == 0 || len(data) > MaxNullDataSize {
+		return nil, fmt.Errorf("data must be 1 to %d bytes", MaxNullDataSize)
+	}
+	return data, nil
+}
+
+// FindNotarization returns the first transaction with data in a null data output and
+// its block, transactions of pruned blocks can't be searched
+func (bc *BlockChain) FindNotarization(data []byte) (*Transaction, *Block, error) {
+	var foundTx *Transaction
+	var foundBlock *Block
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, tx := range block.Transactions {
+			for _, output := range tx.TxOutputs {
+				if output.Type == OutputNullData && bytes.Equal(output.ScriptPubKeyHash, data) {
+					foundTx, foundBlock = tx, block
+				}
+			}
+		}
+	}
+	if foundTx == nil {
+		if bc.PruneHeight() > 0 {
+			return nil, nil, fmt.Errorf("data %x is not notarized in blocks from height %d, blocks below are pruned", data, bc.PruneHeight())
+		}
+		return nil, nil, fmt.Errorf("data %x is not notarized", data)
+	}
+	return foundTx, foundBlock, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotarize(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	from := wallet.GetAddress()
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: wallet}, Meta: map[string]*AddressMeta{}}
+	bc := newTestChain(t, from, 2)
+
+	file := filepath.Join(t.TempDir(), "doc.txt")
+	if err := os.WriteFile(file, []byte("notarized"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := NotarizationData(file)
+	if hash := sha256.Sum256([]byte("notarized")); err != nil || !bytes.Equal(data, hash[:]) {
+		t.Fatalf("got data %x of file: %v", data, err)
+	}
+	if _, _, err := bc.FindNotarization(data); err == nil {
+		t.Error("data is found before it's notarized")
+	}
+
+	pubKeyHash, _ := GetPubKeyHashFromAddress(from)
+	_, balance := bc.FindUtxo(pubKeyHash)
+	tx, err := NewDataTransaction(from, data, 1, bc, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDataTransaction(from, data, -1, bc, wm); err == nil {
+		t.Error("transaction with a negative fee is created")
+	}
+	block, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "test", 2), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, foundBlock, err := bc.FindNotarization(data); err != nil || !bytes.Equal(found.Id, tx.Id) || foundBlock.Height != block.Height {
+		t.Fatalf("got transaction %v in block %v: %v", found, foundBlock, err)
+	}
+	// only the fee is spent, the null data output isn't indexed
+	if _, got := bc.FindUtxo(pubKeyHash); got != balance-1 {
+		t.Errorf("got balance %d, want %d", got, balance-1)
+	}
+	if entry, _ := bc.GetUtxo(tx.Id, 0); entry != nil {
+		t.Error("null data output is in the utxo index")
+	}
+
+	tests := []struct {
+		name    string
+		outputs []TxOutput
+	}{
+		{"value", []TxOutput{{data, 1, OutputNullData}}},
+		{"too large", []TxOutput{{make([]byte, MaxNullDataSize+1), 0, OutputNullData}}},
+		{"twice", []TxOutput{{data, 0, OutputNullData}, {data, 0, OutputNullData}}},
+	}
+	for _, test := range tests {
+		bad := &Transaction{TxInputs: tx.TxInputs, TxOutputs: test.outputs}
+		if err := bad.Check(); err == nil {
+			t.Errorf("%s: null data output is valid", test.name)
+		}
+	}
+	if err := (&Transaction{TxOutputs: tx.TxOutputs}).Check(); err == nil {
+		t.Error("transaction without inputs is valid")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"math/big"
+	"os"
 )
 
 type ProofOfWork struct {
@@ -14,7 +15,7 @@ type ProofOfWork struct {
 
 func NewProofOfWork(block *Block) *ProofOfWork {
 	targetInt := new(big.Int)
-	targetInt.SetString("0010000000000000000000000000000000000000000000000000000000000000", 16)
+	targetInt.SetString(activeNetwork.PowLimit, 16)
 
 	return &ProofOfWork{
 		block:  block,
@@ -23,16 +24,25 @@ func NewProofOfWork(block *Block) *ProofOfWork {
 }
 
 func (pow *ProofOfWork) Run() uint64 {
-	fmt.Printf("Finding nounce: \n")
+	// blocks are found at once on regtest, progress would only clutter the output of scripts,
+	// it goes to stderr so stdout only has results
+	verbose := !activeNetwork.MineBlocksOnDemand
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Finding nounce: \n")
+	}
 
 	var nounce uint64 = 0
 	for ; true; nounce++ {
 		pow.block.Nonce = nounce
 		hashBytes, isValid := pow.IsValid()
 
-		fmt.Printf("\r%x", hashBytes)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "\r%x", hashBytes)
+		}
 		if isValid {
-			fmt.Print("\n")
+			if verbose {
+				fmt.Fprint(os.Stderr, "\n")
+			}
 			pow.block.Hash = hashBytes
 			return nounce
 		}
@@ -0,0 +1,165 @@
+// p2p message format, same framing as bitcoin:
+//
+//	magic(4) | command(12, NUL padded) | payload length(4, little endian) | checksum(4) | payload
+//
+// payload is gob encoded, checksum is the first 4 bytes of double sha256 of payload
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	protocolVersion    = 2
+	minProtocolVersion = 2 // version 1 synced with getblocks
+	userAgent          = "/bitcoin-demo:0.7/"
+
+	commandLength  = 12
+	headerLength   = 4 + commandLength + 4 + 4
+	maxPayloadSize = 32 << 20
+)
+
+const (
+	CmdVersion    = "version"
+	CmdVerack     = "verack"
+	CmdInv        = "inv"
+	CmdGetHeaders = "getheaders"
+	CmdHeaders    = "headers"
+	CmdGetData    = "getdata"
+	CmdBlock      = "block"
+	CmdTx         = "tx"
+	CmdPing       = "ping"
+	CmdPong       = "pong"
+	CmdAddr       = "addr"
+	CmdReject     = "reject"
+	CmdGetStatus  = "getstatus"
+	CmdStatus     = "status"
+)
+
+const (
+	InvTypeBlock = "block"
+	InvTypeTx    = "tx"
+)
+
+type VersionMsg struct {
+	Version    int32
+	BestHeight int64  // -1 if the chain is empty
+	AddrFrom   string // listening address, empty for clients that don't accept connections
+	Nonce      uint64 // detects connecting to self
+	UserAgent  string
+}
+
+type InvMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+type GetHeadersMsg struct {
+	Locator [][]byte
+}
+
+// HeadersMsg carries blocks without transactions, from old to new
+type HeadersMsg struct {
+	Headers []*Block
+}
+
+type GetDataMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+type PingMsg struct {
+	Nonce uint64
+}
+
+type AddrMsg struct {
+	Addrs []string
+}
+
+// RejectMsg tells the sender why a message is refused
+type RejectMsg struct {
+	Command string
+	Reason  string
+	Hash    []byte
+}
+
+type PeerStatus struct {
+	Addr       string `json:"addr"`
+	Inbound    bool   `json:"inbound"`
+	UserAgent  string `json:"useragent"`
+	BestHeight int64  `json:"bestheight"`
+	InFlight   int    `json:"inflight"` // blocks requested from the peer
+}
+
+// StatusMsg reports sync progress of a node, it's also the result of rpc getsyncstatus
+type StatusMsg struct {
+	BlockHeight  int64        `json:"blocks"`     // -1 if the chain is empty
+	HeaderHeight int64        `json:"headers"`    // height of the last validated header
+	InFlight     int          `json:"inflight"`   // blocks requested and not received yet
+	Downloaded   int          `json:"downloaded"` // blocks received and waiting for their parents
+	Peers        []PeerStatus `json:"peers"`
+}
+
+// Message is a command with its gob encoded payload
+type Message struct {
+	Command string
+	Payload []byte
+}
+
+func NewMessage(command string, payload interface{}) (*Message, error) {
+	msg := &Message{Command: command}
+	if payload != nil {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(payload); err != nil {
+			return nil, err
+		}
+		msg.Payload = buf.Bytes()
+	}
+	return msg, nil
+}
+
+// Decode unserializes payload into v
+func (m *Message) Decode(v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(m.Payload)).Decode(v)
+}
+
+func WriteMessage(w io.Writer, msg *Message) error {
+	if len(msg.Command) > commandLength {
+		return fmt.Errorf("command %s is too long", msg.Command)
+	}
+	header := make([]byte, headerLength)
+	copy(header, activeNetwork.Magic[:])
+	copy(header[4:], msg.Command)
+	binary.LittleEndian.PutUint32(header[4+commandLength:], uint32(len(msg.Payload)))
+	copy(header[4+commandLength+4:], Checksum(msg.Payload))
+	_, err := w.Write(append(header, msg.Payload...))
+	return err
+}
+
+func ReadMessage(r io.Reader) (*Message, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], activeNetwork.Magic[:]) {
+		return nil, errors.New("message magic doesn't match, the peer is on another network")
+	}
+	command := string(bytes.TrimRight(header[4:4+commandLength], "\x00"))
+	length := binary.LittleEndian.Uint32(header[4+commandLength:])
+	if length > maxPayloadSize {
+		return nil, fmt.Errorf("payload of %s is too large: %d bytes", command, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(Checksum(payload), header[4+commandLength+4:]) {
+		return nil, fmt.Errorf("checksum of %s doesn't match", command)
+	}
+	return &Message{command, payload}, nil
+}
@@ -0,0 +1,169 @@
+// pruning drops transactions and undo data of old blocks to cap the size of the store,
+// headers are kept so the chain can still be walked and unspent outputs live in the
+// utxo index
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	pruneHeightKey = "pruneHeight"
+	blockSizeKey   = "blockSize"
+	// blocks at the tail that are never pruned, two days of blocks on mainnet
+	minBlocksToKeep = 288
+	// pruneTarget when old blocks are only pruned by pruneblockchain
+	pruneManual = math.MaxUint64
+)
+
+// set by -prune, 0 disables pruning, otherwise it's the size in bytes stored blocks
+// are kept under
+var pruneTarget uint64
+
+// SetPruneTarget applies `-prune=<MB>`, 1 allows pruneblockchain without a target
+func SetPruneTarget(mb uint64) {
+	switch mb {
+	case 0:
+		pruneTarget = 0
+	case 1:
+		pruneTarget = pruneManual
+	default:
+		pruneTarget = mb << 20
+	}
+}
+
+var ErrBlockPruned = errors.New("block data is pruned")
+
+// IsPruned tells if only the header of a stored block is kept, every full block has
+// a mining transaction
+func (b *Block) IsPruned() bool {
+	return len(b.Transactions) == 0
+}
+
+func (bc *BlockChain) prunedError(block *Block) error {
+	return fmt.Errorf("%w: block %x at height %d, only blocks from height %d are kept", ErrBlockPruned, block.Hash, block.Height, bc.pruneHeight)
+}
+
+// prunedHint is appended to errors of transactions not found, they may be in a pruned block
+func prunedHint(bc *BlockChain) string {
+	if bc.pruneHeight == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", blocks below height %d are pruned", bc.pruneHeight)
+}
+
+// PruneHeight returns the lowest height of full blocks, 0 if none is pruned
+func (bc *BlockChain) PruneHeight() uint64 {
+	return bc.pruneHeight
+}
+
+// BlockSize returns the bytes of stored blocks
+func (bc *BlockChain) BlockSize() uint64 {
+	return bc.blockSize
+}
+
+// prunableBlocks returns full blocks below height from old to new, the last
+// minBlocksToKeep blocks are never included
+func (bc *BlockChain) prunableBlocks(height uint64) []*Block {
+	best := bc.GetBestHeight()
+	if bc.tail == nil || best+1 < minBlocksToKeep {
+		return nil
+	}
+	if limit := best + 1 - minBlocksToKeep; height > limit {
+		height = limit
+	}
+	var blocks []*Block
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil && !block.IsPruned(); block = iter.Next() {
+		if block.Height < height {
+			blocks = append(blocks, block)
+		}
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks
+}
+
+// pruneSaving returns the bytes freed by keeping only the header of block
+func pruneSaving(block *Block) (uint64, []byte, error) {
+	blockBytes, err := block.Serialize()
+	if err != nil {
+		return 0, nil, err
+	}
+	headerBytes, err := block.Header().Serialize()
+	if err != nil {
+		return 0, nil, err
+	}
+	return uint64(len(blockBytes) - len(headerBytes)), headerBytes, nil
+}
+
+// pruneBlocks replaces blocks, old to new from the prune height, with their headers
+func (bc *BlockChain) pruneBlocks(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	pruneHeight := blocks[len(blocks)-1].Height + 1
+	blockSize := bc.blockSize
+	err := bc.store.Update(func(batch StoreBatch) error {
+		for _, block := range blocks {
+			saving, headerBytes, err := pruneSaving(block)
+			if err != nil {
+				return err
+			}
+			if err := batch.PutBlock(block.Hash, headerBytes); err != nil {
+				return err
+			}
+			if err := batch.DeleteUndo(block.Hash); err != nil {
+				return err
+			}
+			blockSize -= saving
+		}
+		if err := batch.PutMeta(pruneHeightKey, UintToByte(pruneHeight)); err != nil {
+			return err
+		}
+		return batch.PutMeta(blockSizeKey, UintToByte(blockSize))
+	})
+	if err != nil {
+		return err
+	}
+	bc.pruneHeight, bc.blockSize = pruneHeight, blockSize
+	logInfo("Pruned %d blocks, blocks from height %d are kept", len(blocks), pruneHeight)
+	return nil
+}
+
+// PruneBlocks prunes blocks below height and returns the new prune height, the last
+// minBlocksToKeep blocks are kept whatever height is
+func (bc *BlockChain) PruneBlocks(height uint64) (uint64, error) {
+	if pruneTarget == 0 {
+		return 0, errors.New("prune mode is disabled, start with -prune")
+	}
+	if err := bc.pruneBlocks(bc.prunableBlocks(height)); err != nil {
+		return 0, err
+	}
+	return bc.pruneHeight, nil
+}
+
+// autoPrune prunes the oldest blocks once stored blocks outgrow pruneTarget, until
+// they are under 90% of it so that not every new block triggers a prune
+func (bc *BlockChain) autoPrune() {
+	if pruneTarget == pruneManual || pruneTarget == 0 || bc.blockSize <= pruneTarget {
+		return
+	}
+	goal := pruneTarget / 10 * 9
+	blocks := bc.prunableBlocks(math.MaxUint64)
+	size, n := bc.blockSize, 0
+	for ; n < len(blocks) && size > goal; n++ {
+		saving, _, err := pruneSaving(blocks[n])
+		if err != nil {
+			logWarn("prune blocks fail: %v", err)
+			return
+		}
+		size -= saving
+	}
+	if err := bc.pruneBlocks(blocks[:n]); err != nil {
+		logWarn("prune blocks fail: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestChain mines count blocks to address on a memory store of regtest
+func newTestChain(t *testing.T, address string, count int) *BlockChain {
+	oldNetwork, oldTarget := activeNetwork, pruneTarget
+	t.Cleanup(func() { activeNetwork, pruneTarget = oldNetwork, oldTarget })
+	activeNetwork, pruneTarget = &RegTestParams, 0
+
+	bc, err := NewBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AcceptBlock(NewBlock([]*Transaction{NewMiningTx(address, "genesis", 0)}, []byte{}, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for height := uint64(1); height < uint64(count); height++ {
+		if _, err := bc.AddBlock([]*Transaction{NewMiningTx(address, "test", height)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+func TestPruneBlocks(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, 400)
+	pubKeyHash, _ := GetPubKeyHashFromAddress(address)
+	_, balance := bc.FindUtxo(pubKeyHash)
+
+	if _, err := bc.PruneBlocks(100); err == nil {
+		t.Fatal("blocks are pruned with prune mode disabled")
+	}
+	pruneTarget = pruneManual
+	size := bc.BlockSize()
+	if height, err := bc.PruneBlocks(100); err != nil || height != 100 {
+		t.Fatalf("got prune height %d, want 100: %v", height, err)
+	}
+	if bc.BlockSize() >= size {
+		t.Errorf("size %d isn't less than %d after pruning", bc.BlockSize(), size)
+	}
+	// the last minBlocksToKeep blocks are kept
+	if height, _ := bc.PruneBlocks(1000); height != 400-minBlocksToKeep {
+		t.Errorf("got prune height %d, want %d", height, 400-minBlocksToKeep)
+	}
+
+	block, err := bc.GetBlockByHeight(50)
+	if err != nil || !block.IsPruned() {
+		t.Fatalf("got block %v at height 50: %v", block, err)
+	}
+	if _, err := bc.GetBlock(block.Hash); !errors.Is(err, ErrBlockPruned) {
+		t.Errorf("got %v, want ErrBlockPruned", err)
+	}
+	if block, _ := bc.GetBlockByHeight(400 - minBlocksToKeep); block.IsPruned() {
+		t.Error("block at prune height is pruned")
+	}
+	if _, got := bc.FindUtxo(pubKeyHash); got != balance {
+		t.Errorf("got balance %d after pruning, want %d", got, balance)
+	}
+}
+
+func TestAutoPrune(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, 400)
+	size := bc.BlockSize()
+	pruneTarget = size
+	// blocks of a coinbase only are mostly header, so all prunable blocks are pruned
+	if _, err := bc.AddBlock([]*Transaction{NewMiningTx(address, "test", 400)}); err != nil {
+		t.Fatal(err)
+	}
+	if bc.PruneHeight() != 401-minBlocksToKeep || bc.BlockSize() >= size {
+		t.Errorf("got prune height %d and size %d, want %d and less than %d", bc.PruneHeight(), bc.BlockSize(), 401-minBlocksToKeep, size)
+	}
+}
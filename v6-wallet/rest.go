@@ -0,0 +1,321 @@
+// read-only REST interface of a node, no authentication as nothing can be changed:
+//
+//	GET /block/<hash>[.bin]
+//	GET /block-height/<height>[.bin]
+//	GET /tx/<txid>[.bin]
+//	GET /address/<address>/utxos
+//	GET /address/<address>/txs
+//	GET /chaininfo
+//
+// results are JSON, or gob encoded block and transaction with the .bin suffix
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type RESTServer struct {
+	node *Node
+}
+
+// RegisterREST adds REST handlers to mux
+func RegisterREST(mux *http.ServeMux, node *Node) {
+	s := &RESTServer{node}
+	mux.HandleFunc("/block/", s.restGet(s.handleBlock))
+	mux.HandleFunc("/block-height/", s.restGet(s.handleBlockHeight))
+	mux.HandleFunc("/tx/", s.restGet(s.handleTx))
+	mux.HandleFunc("/address/", s.restGet(s.handleAddress))
+	mux.HandleFunc("/chaininfo", s.restGet(s.handleChainInfo))
+}
+
+// restError carries the http status of an error
+type restError struct {
+	status int
+	msg    string
+}
+
+func (e *restError) Error() string {
+	return e.msg
+}
+
+func notFound(msg string) error {
+	return &restError{http.StatusNotFound, msg}
+}
+
+func badRequest(msg string) error {
+	return &restError{http.StatusBadRequest, msg}
+}
+
+// restHandler returns either JSON value or raw bytes for the path without prefix
+type restHandler func(path string, binary bool) (interface{}, []byte, error)
+
+// restGet strips the handler's prefix and the .bin suffix from path, writes result or error
+func (s *RESTServer) restGet(handler restHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeRESTError(w, &restError{http.StatusMethodNotAllowed, "REST interface is read-only"})
+			return
+		}
+		path := r.URL.Path
+		if i := strings.IndexByte(path[1:], '/'); i >= 0 {
+			path = path[i+2:]
+		} else {
+			path = ""
+		}
+		binary := strings.HasSuffix(path, ".bin")
+		path = strings.TrimSuffix(path, ".bin")
+
+		value, raw, err := handler(path, binary)
+		if err != nil {
+			writeRESTError(w, err)
+			return
+		}
+		if binary {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(raw)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(value)
+	}
+}
+
+func writeRESTError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var restErr *restError
+	if errors.As(err, &restErr) {
+		status = restErr.status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func parseHash(s string) ([]byte, error) {
+	hash, err := hex.DecodeString(s)
+	if err != nil || len(hash) != 32 {
+		return nil, badRequest("invalid hash: " + s)
+	}
+	return hash, nil
+}
+
+// blockResult returns verbose JSON of block, or the serialized block
+func (s *RESTServer) blockResult(block *Block, binary bool) (interface{}, []byte, error) {
+	if binary {
+		data, err := block.Serialize()
+		return nil, data, err
+	}
+	return NewBlockJSON(block, s.node.bc.GetConfirmations(block), true), nil, nil
+}
+
+func (s *RESTServer) handleBlock(path string, binary bool) (interface{}, []byte, error) {
+	hash, err := parseHash(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	block, err := s.node.bc.GetBlock(hash)
+	if err != nil {
+		return nil, nil, notFound(err.Error())
+	}
+	return s.blockResult(block, binary)
+}
+
+func (s *RESTServer) handleBlockHeight(path string, binary bool) (interface{}, []byte, error) {
+	height, err := strconv.ParseUint(path, 10, 64)
+	if err != nil {
+		return nil, nil, badRequest("invalid height: " + path)
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	if s.node.bc.GetTail() == nil || height > s.node.bc.GetBestHeight() {
+		return nil, nil, notFound("block height out of range")
+	}
+	block, err := s.node.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, nil, notFound(err.Error())
+	}
+	if block.IsPruned() {
+		return nil, nil, notFound(s.node.bc.prunedError(block).Error())
+	}
+	return s.blockResult(block, binary)
+}
+
+// handleTx searches mempool and then the chain
+func (s *RESTServer) handleTx(path string, binary bool) (interface{}, []byte, error) {
+	txid, err := parseHash(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	var block *Block
+	tx := s.node.mempool[string(txid)]
+	if tx == nil {
+		tx, block = s.node.bc.FindTransactionBlock(txid)
+	}
+	if tx == nil {
+		return nil, nil, notFound("transaction " + path + " not found" + prunedHint(s.node.bc))
+	}
+	if binary {
+		data, err := tx.Serialize()
+		return nil, data, err
+	}
+	txJSON := NewTxJSON(tx)
+	if block != nil {
+		txJSON.BlockHash = hex.EncodeToString(block.Hash)
+		txJSON.Confirmations = s.node.bc.GetBestHeight() - block.Height + 1
+	}
+	return txJSON, nil, nil
+}
+
+type UTXOJSON struct {
+	Txid          string `json:"txid"`
+	Vout          int64  `json:"vout"`
+	Value         int64  `json:"value"`
+	Height        uint64 `json:"height"`
+	Confirmations uint64 `json:"confirmations"`
+}
+
+type AddressUTXOsJSON struct {
+	Address string     `json:"address"`
+	Balance int64      `json:"balance"`
+	UTXOs   []UTXOJSON `json:"utxos"`
+}
+
+// handleAddress serves <address>/utxos and <address>/txs
+func (s *RESTServer) handleAddress(path string, binary bool) (interface{}, []byte, error) {
+	address, what, _ := strings.Cut(path, "/")
+	if binary {
+		return nil, nil, badRequest("binary format is only available for blocks and transactions")
+	}
+	pubKeyHash, err := GetPubKeyHashFromAddress(address)
+	if err != nil {
+		return nil, nil, badRequest("invalid address " + address + ": " + err.Error())
+	}
+	switch what {
+	case "utxos":
+		return s.addressUTXOs(address, pubKeyHash), nil, nil
+	case "txs":
+		return s.addressTxs(pubKeyHash), nil, nil
+	}
+	return nil, nil, notFound("unknown address resource: " + what)
+}
+
+func (s *RESTServer) addressUTXOs(address string, pubKeyHash []byte) *AddressUTXOsJSON {
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	utxos, total := s.node.bc.FindUtxo(pubKeyHash)
+
+	bestHeight := s.node.bc.GetBestHeight()
+	result := &AddressUTXOsJSON{Address: address, Balance: total, UTXOs: make([]UTXOJSON, 0, len(utxos))}
+	for _, utxo := range utxos {
+		result.UTXOs = append(result.UTXOs, UTXOJSON{
+			Txid:          hex.EncodeToString(utxo.TxId),
+			Vout:          utxo.Index,
+			Value:         utxo.Output.Value,
+			Height:        utxo.Height,
+			Confirmations: bestHeight - utxo.Height + 1,
+		})
+	}
+	return result
+}
+
+// addressTxs returns transactions paying to or spending from the address, newest first
+func (s *RESTServer) addressTxs(pubKeyHash []byte) []*TxJSON {
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	bestHeight := s.node.bc.GetBestHeight()
+	txs := make([]*TxJSON, 0)
+	iter := s.node.bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, tx := range block.Transactions {
+			if !txInvolves(tx, pubKeyHash) {
+				continue
+			}
+			txJSON := NewTxJSON(tx)
+			txJSON.BlockHash = hex.EncodeToString(block.Hash)
+			txJSON.Confirmations = bestHeight - block.Height + 1
+			txs = append(txs, txJSON)
+		}
+	}
+	return txs
+}
+
+func txInvolves(tx *Transaction, pubKeyHash []byte) bool {
+	for _, output := range tx.TxOutputs {
+		if output.PaysTo(pubKeyHash) {
+			return true
+		}
+	}
+	if tx.IsMiningTx() {
+		return false
+	}
+	for _, input := range tx.TxInputs {
+		if bytes.Equal(GetPubKeyHashFromPubKey(input.PubKey), pubKeyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+type ChainInfoJSON struct {
+	Network       string `json:"network"`
+	Blocks        int64  `json:"blocks"`
+	Headers       int64  `json:"headers"`
+	BestBlockHash string `json:"bestblockhash"`
+	PowLimit      string `json:"powlimit"`
+	Reward        int64  `json:"reward"` // reward of the next block
+	MempoolSize   int    `json:"mempool"`
+	Peers         int    `json:"peers"`
+	MedianTime    uint64 `json:"mediantime"` // median time past of the best block
+	Pruned        bool   `json:"pruned"`
+	PruneHeight   uint64 `json:"pruneheight,omitempty"` // lowest height of full blocks
+	SizeOnDisk    uint64 `json:"sizeondisk"`            // bytes of stored blocks
+}
+
+// NewChainInfoJSON returns info of bc, headers are the blocks without a node
+func NewChainInfoJSON(bc *BlockChain) *ChainInfoJSON {
+	info := &ChainInfoJSON{
+		Network:       activeNetwork.Name,
+		Blocks:        -1,
+		BestBlockHash: hex.EncodeToString(bc.GetTail()),
+		PowLimit:      activeNetwork.PowLimit,
+		MedianTime:    bc.MedianTimePast(),
+		Pruned:        pruneTarget != 0 || bc.PruneHeight() > 0,
+		PruneHeight:   bc.PruneHeight(),
+		SizeOnDisk:    bc.BlockSize(),
+	}
+	if bc.GetTail() != nil {
+		info.Blocks = int64(bc.GetBestHeight())
+	}
+	info.Headers = info.Blocks
+	info.Reward = activeNetwork.BlockReward(uint64(info.Blocks + 1))
+	return info
+}
+
+// chainInfo adds sync status and mempool of the node to the info of its chain
+func (n *Node) chainInfo() *ChainInfoJSON {
+	status := n.sync.Status()
+	n.mu.Lock()
+	info := NewChainInfoJSON(n.bc)
+	info.MempoolSize = len(n.mempool)
+	n.mu.Unlock()
+	info.Headers = status.HeaderHeight
+	info.Peers = len(status.Peers)
+	return info
+}
+
+func (s *RESTServer) handleChainInfo(path string, binary bool) (interface{}, []byte, error) {
+	if path != "" || binary {
+		return nil, nil, notFound("chaininfo has no sub resource")
+	}
+	return s.node.chainInfo(), nil, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestREST(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, 3)
+	mux := http.NewServeMux()
+	RegisterREST(mux, NewNode(bc, "test", ""))
+	tail := bc.NewIterator().Next()
+	tip := hex.EncodeToString(tail.Hash)
+	unknown := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/block/" + tip, http.StatusOK},
+		{"GET", "/block/" + tip + ".bin", http.StatusOK},
+		{"GET", "/block/" + unknown, http.StatusNotFound},
+		{"GET", "/block/xyz", http.StatusBadRequest},
+		{"GET", "/block/abcd", http.StatusBadRequest},
+		{"GET", "/block-height/2", http.StatusOK},
+		{"GET", "/block-height/3", http.StatusNotFound},
+		{"GET", "/block-height/-1", http.StatusBadRequest},
+		{"GET", "/tx/" + hex.EncodeToString(tail.Transactions[0].Id), http.StatusOK},
+		{"GET", "/tx/" + unknown, http.StatusNotFound},
+		{"GET", "/tx/", http.StatusBadRequest},
+		{"GET", "/address/" + address + "/utxos", http.StatusOK},
+		{"GET", "/address/" + address + "/txs", http.StatusOK},
+		{"GET", "/address/" + address + "/keys", http.StatusNotFound},
+		{"GET", "/address/" + address + "/utxos.bin", http.StatusBadRequest},
+		{"GET", "/address/1nvalid/utxos", http.StatusBadRequest},
+		{"GET", "/chaininfo", http.StatusOK},
+		{"POST", "/chaininfo", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.path, w.Code, test.status, w.Body)
+		}
+		if w.Code != http.StatusOK && !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s %s: error isn't in JSON: %s", test.method, test.path, w.Body)
+		}
+	}
+
+	// heights come from the utxo index, each block pays the address its mining reward
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/address/"+address+"/utxos", nil))
+	var utxos AddressUTXOsJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &utxos); err != nil || len(utxos.UTXOs) != 3 {
+		t.Fatalf("got utxos %s: %v", w.Body, err)
+	}
+	blocks := chainBlocks(bc)
+	for _, utxo := range utxos.UTXOs {
+		if coinbase := hex.EncodeToString(blocks[utxo.Height].Transactions[0].Id); utxo.Txid != coinbase || utxo.Confirmations != 3-utxo.Height {
+			t.Errorf("got utxo %s at height %d with %d confirmations", utxo.Txid, utxo.Height, utxo.Confirmations)
+		}
+	}
+}
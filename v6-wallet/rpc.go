@@ -0,0 +1,358 @@
+// JSON-RPC 2.0 over HTTP, served by a running node so other programs don't have to parse
+// the cli output. Requests are authenticated by HTTP basic auth, with -rpcuser and
+// -rpcpassword or with a random password written to the cookie file in the data dir
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cookieFile     = ".cookie"
+	cookieUser     = "__cookie__"
+	maxRequestSize = 1 << 20
+)
+
+// error codes defined by JSON-RPC 2.0 and bitcoin
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+
+	RPCMiscError            = -1
+	RPCInvalidAddress       = -5 // also used for unknown blocks and transactions
+	RPCWalletError          = -4
+	RPCVerifyRejected       = -26
+	RPCDeserializationError = -22
+	RPCInsufficientFunds    = -6
+)
+
+type RPCRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	Id      json.RawMessage   `json:"id,omitempty"` // absent for notifications
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"` // kept even if it's 0, false or null
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func rpcErrorf(code int, format string, a ...interface{}) *RPCError {
+	return &RPCError{code, fmt.Sprintf(format, a...)}
+}
+
+type rpcHandler func(s *RPCServer, params []json.RawMessage) (interface{}, error)
+
+type RPCServer struct {
+	node     *Node
+	user     string
+	password string
+	walletMu sync.Mutex // wallet file is read and written by requests in parallel
+}
+
+// NewRPCServer uses the cookie file if user or password is empty
+func NewRPCServer(node *Node, user, password string) (*RPCServer, error) {
+	s := &RPCServer{node: node, user: user, password: password}
+	if user == "" || password == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s.user, s.password = cookieUser, hex.EncodeToString(b)
+		err := writeFileAtomic(GetDataPath(cookieFile), []byte(s.user+":"+s.password), 0600)
+		if err != nil {
+			return nil, fmt.Errorf("write cookie file fail: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// ListenHTTP serves the JSON-RPC and REST handlers on addr until it fails
+func ListenHTTP(addr string, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// Close removes the cookie file, clients can't connect with it anymore
+func (s *RPCServer) Close() {
+	if s.user == cookieUser {
+		os.Remove(GetDataPath(cookieFile))
+	}
+}
+
+func (s *RPCServer) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reply interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			reply = &RPCResponse{JSONRPC: "2.0", Error: rpcErrorf(RPCInvalidRequest, "invalid batch"), Id: json.RawMessage("null")}
+		} else {
+			responses := make([]*RPCResponse, 0, len(batch))
+			for _, raw := range batch {
+				if resp := s.handleRequest(raw); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) > 0 {
+				reply = responses
+			}
+		}
+	} else if resp := s.handleRequest(body); resp != nil {
+		reply = resp
+	}
+
+	if reply == nil {
+		// notifications only
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// handleRequest returns nil for notifications
+func (s *RPCServer) handleRequest(raw []byte) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return &RPCResponse{JSONRPC: "2.0", Error: rpcErrorf(RPCParseError, "parse error: %s", err), Id: json.RawMessage("null")}
+	}
+	resp := &RPCResponse{JSONRPC: "2.0", Id: req.Id}
+	if req.Id == nil {
+		resp.Id = json.RawMessage("null")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = rpcErrorf(RPCInvalidRequest, "invalid request")
+		return resp
+	}
+	handler, ok := rpcHandlers[req.Method]
+	if !ok {
+		resp.Error = rpcErrorf(RPCMethodNotFound, "method %s not found", req.Method)
+	} else {
+		logDebug("rpc call %s", req.Method)
+		result, err := handler(s, req.Params)
+		if err != nil {
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				rpcErr = rpcErrorf(RPCMiscError, "%s", err)
+			}
+			resp.Error = rpcErr
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = rpcErrorf(RPCInternalError, "encode result fail: %s", err)
+		}
+	}
+	if req.Id == nil {
+		return nil
+	}
+	return resp
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+// params helpers, i is the position in params
+
+func paramString(params []json.RawMessage, i int) (string, error) {
+	if i >= len(params) {
+		return "", rpcErrorf(RPCInvalidParams, "missing param %d", i+1)
+	}
+	var s string
+	if err := json.Unmarshal(params[i], &s); err != nil {
+		return "", rpcErrorf(RPCInvalidParams, "param %d must be a string", i+1)
+	}
+	return s, nil
+}
+
+func paramInt(params []json.RawMessage, i int) (int64, error) {
+	if i >= len(params) {
+		return 0, rpcErrorf(RPCInvalidParams, "missing param %d", i+1)
+	}
+	var n int64
+	if err := json.Unmarshal(params[i], &n); err != nil {
+		return 0, rpcErrorf(RPCInvalidParams, "param %d must be an integer", i+1)
+	}
+	return n, nil
+}
+
+// paramFee is a fee which isn't negative and doesn't overflow when added to amount
+func paramFee(params []json.RawMessage, i int, amount int64) (int64, error) {
+	fee, err := paramInt(params, i)
+	if err != nil {
+		return 0, err
+	}
+	if fee < 0 {
+		return 0, rpcErrorf(RPCInvalidParams, "fee must not be negative")
+	}
+	if fee > math.MaxInt64-amount {
+		return 0, rpcErrorf(RPCInvalidParams, "fee is too large")
+	}
+	return fee, nil
+}
+
+func paramBool(params []json.RawMessage, i int) (bool, error) {
+	if i >= len(params) {
+		return false, rpcErrorf(RPCInvalidParams, "missing param %d", i+1)
+	}
+	var b bool
+	if err := json.Unmarshal(params[i], &b); err != nil {
+		return false, rpcErrorf(RPCInvalidParams, "param %d must be a boolean", i+1)
+	}
+	return b, nil
+}
+
+func paramHash(params []json.RawMessage, i int) ([]byte, error) {
+	s, err := paramString(params, i)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hex.DecodeString(s)
+	if err != nil || len(hash) != 32 {
+		return nil, rpcErrorf(RPCInvalidParams, "param %d must be a hex encoded hash", i+1)
+	}
+	return hash, nil
+}
+
+func paramHex(params []json.RawMessage, i int) ([]byte, error) {
+	s, err := paramString(params, i)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "param %d must be hex encoded data", i+1)
+	}
+	return data, nil
+}
+
+func checkParamCount(params []json.RawMessage, min, max int) error {
+	if len(params) < min || len(params) > max {
+		return rpcErrorf(RPCInvalidParams, "%d to %d params expected, got %d", min, max, len(params))
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+// RPCClient calls methods of a node's RPC server
+type RPCClient struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// NewRPCClient reads user and password from the cookie file if they are empty
+func NewRPCClient(addr, user, password string) (*RPCClient, error) {
+	if user == "" || password == "" {
+		cookie, err := os.ReadFile(GetDataPath(cookieFile))
+		if err != nil {
+			return nil, fmt.Errorf("no -rpcuser and -rpcpassword, and can't read cookie file: %v", err)
+		}
+		var ok bool
+		user, password, ok = strings.Cut(strings.TrimSpace(string(cookie)), ":")
+		if !ok {
+			return nil, errors.New("invalid cookie file")
+		}
+	}
+	return &RPCClient{
+		url:      "http://" + addr + "/",
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Call returns the raw JSON result
+func (c *RPCClient) Call(method string, params ...interface{}) (json.RawMessage, error) {
+	rawParams := make([]json.RawMessage, 0, len(params))
+	for _, param := range params {
+		data, err := json.Marshal(param)
+		if err != nil {
+			return nil, err
+		}
+		rawParams = append(rawParams, data)
+	}
+	body, err := json.Marshal(&RPCRequest{JSONRPC: "2.0", Method: method, Params: rawParams, Id: json.RawMessage("1")})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("incorrect rpc user or password")
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid response, status %s: %v", httpResp.Status, err)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
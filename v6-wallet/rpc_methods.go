@@ -0,0 +1,908 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rpcHandlers maps method names to handlers, it's filled in init as handlers refer to it
+var rpcHandlers map[string]rpcHandler
+
+func init() {
+	rpcHandlers = map[string]rpcHandler{
+		"help":                 rpcHelp,
+		"getblockcount":        rpcGetBlockCount,
+		"getbestblockhash":     rpcGetBestBlockHash,
+		"getblockhash":         rpcGetBlockHash,
+		"getblock":             rpcGetBlock,
+		"getrawtransaction":    rpcGetRawTransaction,
+		"getrawmempool":        rpcGetRawMempool,
+		"getsyncstatus":        rpcGetSyncStatus,
+		"getblockchaininfo":    rpcGetBlockChainInfo,
+		"pruneblockchain":      rpcPruneBlockChain,
+		"rollback":             rpcRollback,
+		"invalidateblock":      rpcInvalidateBlock,
+		"reconsiderblock":      rpcReconsiderBlock,
+		"getbalance":           rpcGetBalance,
+		"send":                 rpcSend,
+		"notarize":             rpcNotarize,
+		"verifynotarization":   rpcVerifyNotarization,
+		"initiateswap":         rpcInitiateSwap,
+		"participateswap":      rpcParticipateSwap,
+		"redeemswap":           rpcRedeemSwap,
+		"refundswap":           rpcRefundSwap,
+		"auditswap":            rpcAuditSwap,
+		"createwallet":         rpcCreateWallet,
+		"listaddresses":        rpcListAddresses,
+		"decoderawtransaction": rpcDecodeRawTransaction,
+		"decodeblock":          rpcDecodeBlock,
+		"generate":             rpcGenerate,
+		"setmocktime":          rpcSetMockTime,
+	}
+}
+
+type TxInputJSON struct {
+	Txid      string `json:"txid,omitempty"`
+	Vout      int64  `json:"vout"`               // height for the mining input
+	Coinbase  string `json:"coinbase,omitempty"` // data of the mining input
+	ScriptSig string `json:"scriptsig,omitempty"`
+	PubKey    string `json:"pubkey,omitempty"`
+	Sequence  uint32 `json:"sequence,omitempty"`
+	Secret    string `json:"secret,omitempty"` // preimage redeeming an HTLC
+}
+
+type TxOutputJSON struct {
+	N       int    `json:"n"`
+	Value   int64  `json:"value"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+	Data    string `json:"data,omitempty"` // hex of null data or contract
+}
+
+type TxJSON struct {
+	Txid          string         `json:"txid"`
+	Time          int64          `json:"time"`
+	LockTime      uint32         `json:"locktime"`
+	Size          int            `json:"size"`
+	Vin           []TxInputJSON  `json:"vin"`
+	Vout          []TxOutputJSON `json:"vout"`
+	BlockHash     string         `json:"blockhash,omitempty"`
+	Confirmations uint64         `json:"confirmations,omitempty"`
+}
+
+type BlockJSON struct {
+	Hash          string      `json:"hash"`
+	Confirmations uint64      `json:"confirmations"`
+	Height        uint64      `json:"height"`
+	Version       uint64      `json:"version"`
+	PrevHash      string      `json:"previousblockhash"`
+	MerkleRoot    string      `json:"merkleroot"`
+	Time          uint64      `json:"time"`
+	Bits          uint64      `json:"bits"`
+	Nonce         uint64      `json:"nonce"`
+	TxCount       int         `json:"ntx"`
+	Tx            interface{} `json:"tx"` // txids, or TxJSON if verbose
+}
+
+// ChainChangeJSON is the result of rollback, invalidateblock and reconsiderblock
+type ChainChangeJSON struct {
+	Disconnected  int    `json:"disconnected"`
+	Connected     int    `json:"connected"`
+	Height        uint64 `json:"height"`
+	BestBlockHash string `json:"bestblockhash"`
+}
+
+// NotarizationJSON is the result of verifynotarization
+type NotarizationJSON struct {
+	Data          string `json:"data"`
+	Txid          string `json:"txid"`
+	BlockHash     string `json:"blockhash"`
+	Height        uint64 `json:"height"`
+	Time          uint64 `json:"time"`
+	Confirmations uint64 `json:"confirmations"`
+}
+
+func NewNotarizationJSON(data []byte, tx *Transaction, block *Block, bestHeight uint64) *NotarizationJSON {
+	return &NotarizationJSON{
+		Data:          hex.EncodeToString(data),
+		Txid:          hex.EncodeToString(tx.Id),
+		BlockHash:     hex.EncodeToString(block.Hash),
+		Height:        block.Height,
+		Time:          block.TimeStamp,
+		Confirmations: bestHeight - block.Height + 1,
+	}
+}
+
+func NewChainChangeJSON(bc *BlockChain, disconnected, connected []*Block) *ChainChangeJSON {
+	return &ChainChangeJSON{
+		Disconnected:  len(disconnected),
+		Connected:     len(connected),
+		Height:        bc.GetBestHeight(),
+		BestBlockHash: hex.EncodeToString(bc.GetTail()),
+	}
+}
+
+func NewTxJSON(tx *Transaction) *TxJSON {
+	data, _ := tx.Serialize()
+	txJSON := &TxJSON{
+		Txid:     hex.EncodeToString(tx.Id),
+		Time:     tx.TimeStamp,
+		LockTime: tx.LockTime,
+		Size:     len(data),
+		Vin:      make([]TxInputJSON, 0, len(tx.TxInputs)),
+		Vout:     make([]TxOutputJSON, 0, len(tx.TxOutputs)),
+	}
+	for _, input := range tx.TxInputs {
+		if tx.IsMiningTx() {
+			txJSON.Vin = append(txJSON.Vin, TxInputJSON{Vout: input.Index, Coinbase: string(input.ScriptSig)})
+			continue
+		}
+		txJSON.Vin = append(txJSON.Vin, TxInputJSON{
+			Txid:      hex.EncodeToString(input.TxId),
+			Vout:      input.Index,
+			ScriptSig: hex.EncodeToString(input.ScriptSig),
+			PubKey:    hex.EncodeToString(input.PubKey),
+			Sequence:  input.Sequence,
+			Secret:    hex.EncodeToString(input.Secret),
+		})
+	}
+	for i, output := range tx.TxOutputs {
+		outputJSON := TxOutputJSON{i, output.Value, output.Type.String(), output.Address(), ""}
+		if output.Type == OutputNullData || output.Type == OutputHTLC {
+			outputJSON.Data = hex.EncodeToString(output.ScriptPubKeyHash)
+		}
+		txJSON.Vout = append(txJSON.Vout, outputJSON)
+	}
+	return txJSON
+}
+
+// NewBlockJSON returns the JSON of block with confirmations, which is 0 for a block not
+// in the main chain
+func NewBlockJSON(block *Block, confirmations uint64, verbose bool) *BlockJSON {
+	blockJSON := &BlockJSON{
+		Hash:          hex.EncodeToString(block.Hash),
+		Confirmations: confirmations,
+		Height:        block.Height,
+		Version:       block.Version,
+		PrevHash:      hex.EncodeToString(block.PrevHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Time:          block.TimeStamp,
+		Bits:          block.Bits,
+		Nonce:         block.Nonce,
+		TxCount:       len(block.Transactions),
+	}
+	if verbose {
+		txs := make([]*TxJSON, 0, len(block.Transactions))
+		for _, tx := range block.Transactions {
+			txs = append(txs, NewTxJSON(tx))
+		}
+		blockJSON.Tx = txs
+	} else {
+		txids := make([]string, 0, len(block.Transactions))
+		for _, tx := range block.Transactions {
+			txids = append(txids, hex.EncodeToString(tx.Id))
+		}
+		blockJSON.Tx = txids
+	}
+	return blockJSON
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+func rpcHelp(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	methods := make([]string, 0, len(rpcHandlers))
+	for method := range rpcHandlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods, nil
+}
+
+// getblockcount returns the height of the tail, -1 if the chain is empty
+func rpcGetBlockCount(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return s.node.bestHeight(), nil
+}
+
+func rpcGetBestBlockHash(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	if s.node.bc.GetTail() == nil {
+		return nil, rpcErrorf(RPCMiscError, "blockchain is empty")
+	}
+	return hex.EncodeToString(s.node.bc.GetTail()), nil
+}
+
+// getblockhash <height>
+func rpcGetBlockHash(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	height, err := paramInt(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	if height < 0 || s.node.bc.GetTail() == nil || uint64(height) > s.node.bc.GetBestHeight() {
+		return nil, rpcErrorf(RPCInvalidParams, "block height out of range")
+	}
+	block, err := s.node.bc.GetBlockByHeight(uint64(height))
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(block.Hash), nil
+}
+
+// getblock <hash> [verbose=false]
+func rpcGetBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	hash, err := paramHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	verbose := false
+	if len(params) > 1 {
+		if verbose, err = paramBool(params, 1); err != nil {
+			return nil, err
+		}
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	block, err := s.node.bc.GetBlock(hash)
+	if errors.Is(err, ErrBlockPruned) {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	if err != nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "block %x not found", hash)
+	}
+	return NewBlockJSON(block, s.node.bc.GetConfirmations(block), verbose), nil
+}
+
+// getrawtransaction <txid> [verbose=false], searches mempool and then the chain,
+// returns hex of the serialized transaction, or its fields if verbose
+func rpcGetRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	txid, err := paramHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	verbose := false
+	if len(params) > 1 {
+		if verbose, err = paramBool(params, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	s.node.mu.Lock()
+	var found *Transaction
+	var foundIn *Block
+	if tx, ok := s.node.mempool[string(txid)]; ok {
+		found = tx
+	} else {
+		found, foundIn = s.node.bc.FindTransactionBlock(txid)
+	}
+	var bestHeight uint64
+	if found != nil && foundIn != nil {
+		bestHeight = s.node.bc.GetBestHeight()
+	}
+	hint := prunedHint(s.node.bc)
+	s.node.mu.Unlock()
+
+	if found == nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "transaction %x not found%s", txid, hint)
+	}
+	if !verbose {
+		data, err := found.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(data), nil
+	}
+	txJSON := NewTxJSON(found)
+	if foundIn != nil {
+		txJSON.BlockHash = hex.EncodeToString(foundIn.Hash)
+		txJSON.Confirmations = bestHeight - foundIn.Height + 1
+	}
+	return txJSON, nil
+}
+
+func rpcGetRawMempool(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	txids := make([]string, 0, len(s.node.mempool))
+	for _, tx := range s.node.mempool {
+		txids = append(txids, hex.EncodeToString(tx.Id))
+	}
+	sort.Strings(txids)
+	return txids, nil
+}
+
+func rpcGetSyncStatus(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return s.node.sync.Status(), nil
+}
+
+func rpcGetBlockChainInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return s.node.chainInfo(), nil
+}
+
+// pruneblockchain <height>, returns the lowest height of full blocks
+func rpcPruneBlockChain(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	height, err := paramInt(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "negative block height")
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	pruneHeight, err := s.node.bc.PruneBlocks(uint64(height))
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	return pruneHeight, nil
+}
+
+// changeChain runs f on the chain of the node, then updates its mempool, subscribers,
+// peers and header sync with blocks f disconnected and connected
+func (s *RPCServer) changeChain(f func(bc *BlockChain) ([]*Block, []*Block, error)) (interface{}, error) {
+	s.node.mu.Lock()
+	disconnected, connected, err := f(s.node.bc)
+	s.node.chainChanged(disconnected, connected)
+	result := NewChainChangeJSON(s.node.bc, disconnected, connected)
+	s.node.mu.Unlock()
+
+	if len(disconnected) > 0 {
+		s.node.sync.tailMovedBack()
+	}
+	if len(connected) > 0 {
+		s.node.broadcastInv(InvTypeBlock, connected[len(connected)-1].Hash, nil)
+	}
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	return result, nil
+}
+
+// rollback <height>, blocks above height are deleted and downloaded again from peers
+func rpcRollback(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	height, err := paramInt(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "negative block height")
+	}
+	return s.changeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+		blocks, err := bc.Rollback(uint64(height))
+		return blocks, nil, err
+	})
+}
+
+// invalidateblock <hash>
+func rpcInvalidateBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	hash, err := paramHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.changeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+		blocks, err := bc.InvalidateBlock(hash)
+		return blocks, nil, err
+	})
+}
+
+// reconsiderblock <hash>
+func rpcReconsiderBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	hash, err := paramHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.changeChain(func(bc *BlockChain) ([]*Block, []*Block, error) {
+		return bc.ReconsiderBlock(hash)
+	})
+}
+
+// getbalance [address], total of all wallet addresses if address is omitted
+func rpcGetBalance(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 1); err != nil {
+		return nil, err
+	}
+	var addresses []string
+	if len(params) == 1 {
+		address, err := paramString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	} else {
+		s.walletMu.Lock()
+		for address := range NewWalletManager().Wallets {
+			addresses = append(addresses, address)
+		}
+		s.walletMu.Unlock()
+	}
+
+	var balance int64 = 0
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	for _, address := range addresses {
+		pubKeyHash, err := GetPubKeyHashFromAddress(address)
+		if err != nil {
+			return nil, rpcErrorf(RPCInvalidAddress, "invalid address %s: %s", address, err)
+		}
+		_, total := s.node.bc.FindUtxo(pubKeyHash)
+		balance += total
+	}
+	return balance, nil
+}
+
+// send <from-address> <to-address> <amount> [fee] [locktime] [sequence], the transaction
+// is relayed to peers and mined by the node if it's a miner
+func rpcSend(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 3, 6); err != nil {
+		return nil, err
+	}
+	from, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := paramString(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := paramInt(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "amount must be positive")
+	}
+	var fee int64
+	if len(params) >= 4 {
+		if fee, err = paramFee(params, 3, amount); err != nil {
+			return nil, err
+		}
+	}
+	// locktime and sequence
+	var locks [2]uint32
+	for i := range locks {
+		if len(params) <= 4+i {
+			break
+		}
+		n, err := paramInt(params, 4+i)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > math.MaxUint32 {
+			return nil, rpcErrorf(RPCInvalidParams, "param %d must fit in 32 bits", 5+i)
+		}
+		locks[i] = uint32(n)
+	}
+	for _, address := range []string{from, to} {
+		if err := ValidateAddress(address); err != nil {
+			return nil, rpcErrorf(RPCInvalidAddress, "invalid address %s: %s", address, err)
+		}
+	}
+
+	tx, err := s.submitWalletTx(func(bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+		return NewTransaction(from, to, amount, fee, locks[0], locks[1], bc, wm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(tx.Id), nil
+}
+
+// notarize <from-address> <hex-data> [fee], data is committed in a null data output of a
+// transaction paid by from, which is relayed like send
+func rpcNotarize(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 3); err != nil {
+		return nil, err
+	}
+	from, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateAddress(from); err != nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "invalid address %s: %s", from, err)
+	}
+	data, err := paramHex(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	var fee int64
+	if len(params) == 3 {
+		if fee, err = paramFee(params, 2, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.submitWalletTx(func(bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+		return NewDataTransaction(from, data, fee, bc, wm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(tx.Id), nil
+}
+
+// submitWalletTx makes a transaction with the wallet of the node by build, adds it to
+// the mempool and relays it
+func (s *RPCServer) submitWalletTx(build func(bc *BlockChain, wm *WalletManager) (*Transaction, error)) (*Transaction, error) {
+	s.walletMu.Lock()
+	s.node.mu.Lock()
+	tx, err := build(s.node.bc, NewWalletManager())
+	s.node.mu.Unlock()
+	s.walletMu.Unlock()
+	if err != nil {
+		if strings.Contains(err.Error(), "not enough money") {
+			return nil, rpcErrorf(RPCInsufficientFunds, "%s", err)
+		}
+		return nil, rpcErrorf(RPCWalletError, "%s", err)
+	}
+	if err := s.node.acceptTx(tx); err != nil {
+		return nil, rpcErrorf(RPCVerifyRejected, "%s", err)
+	}
+	s.node.broadcastInv(InvTypeTx, tx.Id, nil)
+	s.node.triggerMining()
+	return tx, nil
+}
+
+// initiateswap <from> <to> <amount> [fee] [timeout], locks amount in a contract with a
+// new secret, refunded after timeout seconds
+func rpcInitiateSwap(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return createSwap(s, params, false)
+}
+
+// participateswap <from> <to> <amount> <secrethash> [fee] [timeout], locks amount in a
+// contract with the secret hash of the initiator's contract
+func rpcParticipateSwap(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	return createSwap(s, params, true)
+}
+
+func createSwap(s *RPCServer, params []json.RawMessage, participate bool) (interface{}, error) {
+	n, timeout := 3, initiatorTimeout
+	if participate {
+		n, timeout = 4, participantTimeout
+	}
+	if err := checkParamCount(params, n, n+2); err != nil {
+		return nil, err
+	}
+	var addresses [2]string
+	for i := range addresses {
+		address, err := paramString(params, i)
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateAddress(address); err != nil {
+			return nil, rpcErrorf(RPCInvalidAddress, "invalid address %s: %s", address, err)
+		}
+		addresses[i] = address
+	}
+	amount, err := paramInt(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "amount must be positive")
+	}
+	var secret, secretHash []byte
+	if participate {
+		if secretHash, err = paramHex(params, 3); err != nil {
+			return nil, err
+		}
+	} else if secret, secretHash, err = NewSwapSecret(); err != nil {
+		return nil, err
+	}
+	var fee int64
+	if len(params) > n {
+		if fee, err = paramFee(params, n, amount); err != nil {
+			return nil, err
+		}
+	}
+	if len(params) > n+1 {
+		seconds, err := paramInt(params, n+1)
+		if err != nil {
+			return nil, err
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	lockTime, err := SwapLockTime(timeout)
+	if err != nil {
+		return nil, rpcErrorf(RPCInvalidParams, "%s", err)
+	}
+
+	tx, err := s.submitWalletTx(func(bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+		return NewSwapTransaction(addresses[0], addresses[1], amount, fee, secretHash, lockTime, bc, wm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	swap, err := NewSwapJSON(tx)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		swap.Secret = hex.EncodeToString(secret)
+	}
+	return swap, nil
+}
+
+// redeemswap <txid> <secret> [fee]
+func rpcRedeemSwap(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 3); err != nil {
+		return nil, err
+	}
+	secret, err := paramHex(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	return spendSwap(s, params, secret, 2)
+}
+
+// refundswap <txid> [fee]
+func rpcRefundSwap(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	return spendSwap(s, params, nil, 1)
+}
+
+// spendSwap redeems the contract of txid in params[0] with secret, or refunds it if
+// secret is nil, with the fee in params[feeParam] if given
+func spendSwap(s *RPCServer, params []json.RawMessage, secret []byte, feeParam int) (interface{}, error) {
+	txid, err := paramHex(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	var fee int64
+	if len(params) > feeParam {
+		// the contract value bounds the fee once the contract is found
+		if fee, err = paramFee(params, feeParam, 0); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := s.submitWalletTx(func(bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+		contractTx := bc.FindTransaction(txid)
+		if contractTx == nil {
+			return nil, fmt.Errorf("transaction %x not found", txid)
+		}
+		return NewSwapSpendTransaction(contractTx, secret, fee, bc, wm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(tx.Id), nil
+}
+
+// auditswap <txid>, describes the contract and the secret if it's redeemed
+func rpcAuditSwap(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	txid, err := paramHex(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	swap, err := s.node.bc.AuditSwap(txid)
+	if err != nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "%s", err)
+	}
+	return swap, nil
+}
+
+// verifynotarization <hex-data>, finds the first block committing data
+func rpcVerifyNotarization(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	data, err := paramHex(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	tx, block, err := s.node.bc.FindNotarization(data)
+	if err != nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "%s", err)
+	}
+	return NewNotarizationJSON(data, tx, block, s.node.bc.GetBestHeight()), nil
+}
+
+// createwallet [label]
+func rpcCreateWallet(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 1); err != nil {
+		return nil, err
+	}
+	label := ""
+	if len(params) == 1 {
+		var err error
+		if label, err = paramString(params, 0); err != nil {
+			return nil, err
+		}
+	}
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+	wm := NewWalletManager()
+	address := wm.CreateWallet(label)
+	return map[string]string{
+		"address": address,
+		"bech32":  wm.GetWallet(address).GetBech32Address(),
+	}, nil
+}
+
+type AddressJSON struct {
+	Address string `json:"address"`
+	Bech32  string `json:"bech32"`
+	Label   string `json:"label"`
+	Created string `json:"created,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+func rpcListAddresses(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+	return NewAddressesJSON(NewWalletManager()), nil
+}
+
+// NewAddressesJSON lists addresses in wm ordered by address
+func NewAddressesJSON(wm *WalletManager) []AddressJSON {
+	addresses := make([]AddressJSON, 0, len(wm.Wallets))
+	for address, wallet := range wm.Wallets {
+		meta := wm.GetMeta(address)
+		item := AddressJSON{Address: address, Bech32: wallet.GetBech32Address(), Label: meta.Label, Note: meta.Note}
+		if meta.CreatedAt != 0 {
+			item.Created = time.Unix(meta.CreatedAt, 0).Format(time.RFC3339)
+		}
+		addresses = append(addresses, item)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Address < addresses[j].Address })
+	return addresses
+}
+
+// DecodeHexTransaction decodes a transaction serialized like getrawtransaction returns
+func DecodeHexTransaction(s string) (*Transaction, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %v", err)
+	}
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	return tx, nil
+}
+
+// DecodeHexBlock decodes a hex encoded block serialized like REST /block/<hash>.bin returns
+func DecodeHexBlock(s string) (*Block, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %v", err)
+	}
+	block, err := Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block: %v", err)
+	}
+	return block, nil
+}
+
+// NewDecodedBlockJSON is the verbose JSON of a block which may not be in the chain
+func NewDecodedBlockJSON(block *Block) *BlockJSON {
+	return NewBlockJSON(block, 0, true)
+}
+
+// decoderawtransaction <hex>
+func rpcDecodeRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	str, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DecodeHexTransaction(str)
+	if err != nil {
+		return nil, rpcErrorf(RPCDeserializationError, "%s", err)
+	}
+	return NewTxJSON(tx), nil
+}
+
+// decodeblock <hex>
+func rpcDecodeBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	str, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	block, err := DecodeHexBlock(str)
+	if err != nil {
+		return nil, rpcErrorf(RPCDeserializationError, "%s", err)
+	}
+	return NewDecodedBlockJSON(block), nil
+}
+
+///////////////////////////////////////////////////////////////////////////
+
+// methods for scripted tests on regtest
+
+const maxGenerateBlocks = 1000
+
+// generate <count> [address], mines to the --miner address of the node if address is omitted, returns block hashes
+func rpcGenerate(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	count, err := paramInt(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 || count > maxGenerateBlocks {
+		return nil, rpcErrorf(RPCInvalidParams, "count must be 1 to %d", maxGenerateBlocks)
+	}
+	address := s.node.minerAddress
+	if len(params) == 2 {
+		if address, err = paramString(params, 1); err != nil {
+			return nil, err
+		}
+	}
+	if address == "" {
+		return nil, rpcErrorf(RPCInvalidParams, "no address to reward, start node with --miner or pass an address")
+	}
+	if err := ValidateAddress(address); err != nil {
+		return nil, rpcErrorf(RPCInvalidAddress, "invalid address %s: %s", address, err)
+	}
+	blocks, err := s.node.Generate(int(count), address)
+	hashes := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		hashes = append(hashes, hex.EncodeToString(block.Hash))
+	}
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "generated %d blocks: %s", len(blocks), err)
+	}
+	return hashes, nil
+}
+
+// setmocktime <timestamp>, new blocks and transactions use timestamp until it's set to 0
+func rpcSetMockTime(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	if !activeNetwork.MineBlocksOnDemand {
+		return nil, rpcErrorf(RPCMiscError, "mock time can't be set on %s", activeNetwork.Name)
+	}
+	t, err := paramInt(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if t < 0 {
+		return nil, rpcErrorf(RPCInvalidParams, "timestamp must not be negative")
+	}
+	SetMockTime(t)
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRPCAuth(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer func(dir string) { dataDir = dir }(dataDir)
+	dataDir = t.TempDir()
+	node := NewNode(newTestChain(t, address, 3), "test", "")
+	if err := EnsureDataDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(s *RPCServer, user, password, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	const request = `{"jsonrpc":"2.0","method":"getblockcount","id":1}`
+
+	s, err := NewRPCServer(node, "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, auth := range [][2]string{{"", ""}, {"user", "wrong"}, {"other", "secret"}} {
+		if w := call(s, auth[0], auth[1], request); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s:%s got status %d, want %d", auth[0], auth[1], w.Code, http.StatusUnauthorized)
+		}
+	}
+	w := call(s, "user", "secret", request)
+	var resp RPCResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error != nil || string(resp.Result) != "2" {
+		t.Errorf("got status %d, result %s, error %v: %v", w.Code, resp.Result, resp.Error, err)
+	}
+
+	// without user or password, the cookie file holds a random password until Close
+	s, err = NewRPCServer(node, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := os.ReadFile(GetDataPath(cookieFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, password, _ := strings.Cut(string(cookie), ":")
+	if w := call(s, "user", "secret", request); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d with the old password", w.Code)
+	}
+	if w := call(s, user, password, request); w.Code != http.StatusOK {
+		t.Errorf("got status %d with the cookie", w.Code)
+	}
+	s.Close()
+	if _, err := os.Stat(GetDataPath(cookieFile)); !os.IsNotExist(err) {
+		t.Errorf("cookie file is kept after Close: %v", err)
+	}
+}
+
+func TestParamFee(t *testing.T) {
+	tests := []struct {
+		param  string
+		amount int64
+		ok     bool
+	}{
+		{"0", 0, true},
+		{"5", 10, true},
+		{"-1", 0, false},
+		{"1", math.MaxInt64, false},
+		{"9223372036854775807", 1, false},
+		{"1.5", 0, false},
+	}
+	for _, test := range tests {
+		fee, err := paramFee([]json.RawMessage{json.RawMessage(test.param)}, 0, test.amount)
+		if (err == nil) != test.ok {
+			t.Errorf("fee %s with amount %d: got %d, %v", test.param, test.amount, fee, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// prefix the message so a signed message can never be a valid transaction signature
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// MessageHash returns double sha256 of the magic prefix and message, each with its length
+func MessageHash(message string) []byte {
+	var buf bytes.Buffer
+	buf.Write(UintToByte(uint64(len(messageMagic))))
+	buf.WriteString(messageMagic)
+	buf.Write(UintToByte(uint64(len(message))))
+	buf.WriteString(message)
+	first := sha256.Sum256(buf.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// SignMessage signs message with the key of wallet, P256 doesn't support public key
+// recovery like secp256k1 does, so the signature embeds the public key:
+//
+//	base64(len(pubKey)(1) | pubKey | r(32) | s(32))
+func SignMessage(wallet *Wallet, message string) (string, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, wallet.PrivateKey(), MessageHash(message))
+	if err != nil {
+		return "", err
+	}
+	sig := []byte{byte(len(wallet.PubKey))}
+	sig = append(sig, wallet.PubKey...)
+	sig = append(sig, r.FillBytes(make([]byte, 32))...)
+	sig = append(sig, s.FillBytes(make([]byte, 32))...)
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// VerifyMessage checks signature is signed for message by the key of address
+func VerifyMessage(address, signature, message string) error {
+	pubKeyHash, err := GetPubKeyHashFromAddress(address)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed base64 encoding of signature")
+	}
+	if len(sig) < 1 {
+		return errors.New("malformed signature")
+	}
+	// length of the public key, as int so bounds below can't wrap around like byte
+	n := int(sig[0])
+	if len(sig) != 1+n+64 {
+		return errors.New("malformed signature")
+	}
+	pubKey := sig[1 : 1+n]
+	if !bytes.Equal(GetPubKeyHashFromPubKey(pubKey), pubKeyHash) {
+		return errors.New("signature is not signed by the key of address")
+	}
+
+	var r, s big.Int
+	r.SetBytes(sig[1+n : 1+n+32])
+	s.SetBytes(sig[1+n+32:])
+	x, y := new(big.Int), new(big.Int)
+	x.SetBytes(pubKey[:len(pubKey)/2])
+	y.SetBytes(pubKey[len(pubKey)/2:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return errors.New("invalid public key in signature")
+	}
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, MessageHash(message), &r, &s) {
+		return errors.New("signature doesn't match the message")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	address := wallet.GetAddress()
+	signature, err := SignMessage(wallet, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(address, signature, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(address, signature, "hello!"); err == nil {
+		t.Error("signature is valid for another message")
+	}
+	if err := VerifyMessage(NewWalletKeyPair().GetAddress(), signature, "hello"); err == nil {
+		t.Error("signature is valid for another address")
+	}
+
+	// 0xff + 1 wraps to 0 in byte arithmetic
+	wrapped := append([]byte{0xff}, make([]byte, 0xff+64)...)
+	tests := map[string]string{
+		"base64":      "not base64!",
+		"empty":       "",
+		"truncated":   signature[:len(signature)-8],
+		"wrapped":     base64.StdEncoding.EncodeToString(wrapped),
+		"no key":      base64.StdEncoding.EncodeToString(make([]byte, 1+64)),
+		"short key":   base64.StdEncoding.EncodeToString(append([]byte{1}, make([]byte, 1+64)...)),
+		"length only": base64.StdEncoding.EncodeToString([]byte{64}),
+	}
+	for name, sig := range tests {
+		if err := VerifyMessage(address, sig, "hello"); err == nil {
+			t.Errorf("%s: malformed signature is valid", name)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BlockStore keeps serialized blocks and their undo data by hash, the hash of the tail
+// block, the unspent outputs by outpoint and metadata of the chain, BlockChain works on
+// any implementation
+type BlockStore interface {
+	// GetBlock returns nil if the block is not in store
+	GetBlock(hash []byte) ([]byte, error)
+	// GetTip returns nil if the store has no block
+	GetTip() ([]byte, error)
+	// Update runs f in a batch, its writes are applied together if f returns nil
+	Update(f func(batch StoreBatch) error) error
+	// ForEachBlock calls f for every block in no particular order until f returns an error,
+	// hash and data are only valid during the call
+	ForEachBlock(f func(hash, data []byte) error) error
+	// GetUtxo returns nil if the output is spent or doesn't exist
+	GetUtxo(outpoint []byte) ([]byte, error)
+	// ForEachUtxo is like ForEachBlock for unspent outputs
+	ForEachUtxo(f func(outpoint, data []byte) error) error
+	// GetMeta returns nil if key is not set
+	GetMeta(key string) ([]byte, error)
+	// GetUndo returns nil if the block has no undo data
+	GetUndo(hash []byte) ([]byte, error)
+	Close() error
+}
+
+// StoreBatch holds writes of BlockStore.Update, they are applied in order
+type StoreBatch interface {
+	PutBlock(hash, data []byte) error
+	DeleteBlock(hash []byte) error
+	SetTip(hash []byte) error
+	PutUtxo(outpoint, data []byte) error
+	DeleteUtxo(outpoint []byte) error
+	PutMeta(key string, value []byte) error
+	DeleteMeta(key string) error
+	PutUndo(hash, data []byte) error
+	DeleteUndo(hash []byte) error
+}
+
+// StoreBackend opens the block store of the active network
+type StoreBackend struct {
+	Exists func() bool
+	// Open creates the store if it doesn't exist
+	Open func() (BlockStore, error)
+}
+
+var storeBackends = map[string]*StoreBackend{
+	"bolt":   {Exists: boltStoreExists, Open: openBoltStore},
+	"memory": {Exists: memoryStoreExists, Open: openMemoryStore},
+}
+
+// backend selected by `-dbbackend`
+var storeBackend = storeBackends["bolt"]
+
+// StoreBackendNames returns sorted names of backends built in the binary
+func StoreBackendNames() []string {
+	names := make([]string, 0, len(storeBackends))
+	for name := range storeBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func SetStoreBackend(name string) error {
+	backend, ok := storeBackends[name]
+	if !ok {
+		return fmt.Errorf("unknown db backend %s: %s", name, strings.Join(StoreBackendNames(), ", "))
+	}
+	storeBackend = backend
+	return nil
+}
+
+// memoryStore loses all blocks when the process exits, it's used by tests and throwaway
+// regtest nodes
+type memoryStore struct {
+	mu     sync.RWMutex
+	blocks map[string][]byte
+	tip    []byte
+	utxos  map[string][]byte
+	meta   map[string][]byte
+	undos  map[string][]byte
+}
+
+func NewMemoryStore() BlockStore {
+	return &memoryStore{
+		blocks: make(map[string][]byte),
+		utxos:  make(map[string][]byte),
+		meta:   make(map[string][]byte),
+		undos:  make(map[string][]byte),
+	}
+}
+
+// memory stores of networks live until the process exits, so a chain created by one
+// command is opened by the next one in the console
+var (
+	memoryStoresMu sync.Mutex
+	memoryStores   = make(map[string]BlockStore)
+)
+
+func memoryStoreExists() bool {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	return memoryStores[activeNetwork.Name] != nil
+}
+
+func openMemoryStore() (BlockStore, error) {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	if memoryStores[activeNetwork.Name] == nil {
+		memoryStores[activeNetwork.Name] = NewMemoryStore()
+	}
+	return memoryStores[activeNetwork.Name], nil
+}
+
+func (s *memoryStore) GetBlock(hash []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.blocks[string(hash)], nil
+}
+
+func (s *memoryStore) GetTip() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip, nil
+}
+
+// memoryBatch keeps the last write of each key, nil for a deleted one
+type memoryBatch struct {
+	blocks map[string][]byte
+	tip    []byte
+	utxos  map[string][]byte
+	meta   map[string][]byte
+	undos  map[string][]byte
+}
+
+func (b *memoryBatch) PutBlock(hash, data []byte) error {
+	if len(hash) == 0 {
+		return errors.New("empty block hash")
+	}
+	b.blocks[string(hash)] = append([]byte{}, data...)
+	return nil
+}
+
+func (b *memoryBatch) DeleteBlock(hash []byte) error {
+	b.blocks[string(hash)] = nil
+	return nil
+}
+
+func (b *memoryBatch) SetTip(hash []byte) error {
+	b.tip = append([]byte{}, hash...)
+	return nil
+}
+
+func (b *memoryBatch) PutUtxo(outpoint, data []byte) error {
+	b.utxos[string(outpoint)] = append([]byte{}, data...)
+	return nil
+}
+
+func (b *memoryBatch) DeleteUtxo(outpoint []byte) error {
+	b.utxos[string(outpoint)] = nil
+	return nil
+}
+
+func (b *memoryBatch) PutMeta(key string, value []byte) error {
+	b.meta[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBatch) DeleteMeta(key string) error {
+	b.meta[key] = nil
+	return nil
+}
+
+func (b *memoryBatch) PutUndo(hash, data []byte) error {
+	b.undos[string(hash)] = append([]byte{}, data...)
+	return nil
+}
+
+func (b *memoryBatch) DeleteUndo(hash []byte) error {
+	b.undos[string(hash)] = nil
+	return nil
+}
+
+// applyWrites copies writes to m, deleting keys written as nil
+func applyWrites(m, writes map[string][]byte) {
+	for key, value := range writes {
+		if value == nil {
+			delete(m, key)
+		} else {
+			m[key] = value
+		}
+	}
+}
+
+func (s *memoryStore) Update(f func(batch StoreBatch) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := &memoryBatch{
+		blocks: make(map[string][]byte),
+		utxos:  make(map[string][]byte),
+		meta:   make(map[string][]byte),
+		undos:  make(map[string][]byte),
+	}
+	if err := f(batch); err != nil {
+		return err
+	}
+	applyWrites(s.blocks, batch.blocks)
+	if batch.tip != nil {
+		s.tip = batch.tip
+	}
+	applyWrites(s.utxos, batch.utxos)
+	applyWrites(s.meta, batch.meta)
+	applyWrites(s.undos, batch.undos)
+	return nil
+}
+
+func (s *memoryStore) ForEachBlock(f func(hash, data []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for hash, data := range s.blocks {
+		if err := f([]byte(hash), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetUtxo(outpoint []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.utxos[string(outpoint)], nil
+}
+
+func (s *memoryStore) ForEachUtxo(f func(outpoint, data []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for outpoint, data := range s.utxos {
+		if err := f([]byte(outpoint), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetMeta(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.meta[key], nil
+}
+
+func (s *memoryStore) GetUndo(hash []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.undos[string(hash)], nil
+}
+
+// Close keeps the blocks, the store is dropped with the process
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,157 @@
+// blocks are stored in bolt database using KV pair `[]byte(Hash): []byte(data)` format,
+// the last block's hash correspond to `lastBlockHashKey`, undo data, unspent outputs and
+// metadata are kept in their own buckets
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	dbName           = "blockchain.db"
+	bucketName       = "bitcoin"
+	lastBlockHashKey = "lastBlockHash"
+	utxoBucketName   = "utxo"
+	metaBucketName   = "meta"
+	undoBucketName   = "undo"
+)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func boltStoreExists() bool {
+	return IsFileExist(GetDataPath(dbName))
+}
+
+// openBoltStore waits a while instead of forever when a node has locked the file
+func openBoltStore() (BlockStore, error) {
+	if err := EnsureDataDir(); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(GetDataPath(dbName), 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, errors.New("blockchain store file is locked, is a node running on it?")
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucketName, utxoBucketName, metaBucketName, undoBucketName} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+// get copies the value, which is only valid in the transaction
+func (s *boltStore) get(bucket string, key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket([]byte(bucket)).Get(key); data != nil {
+			value = append([]byte{}, data...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) GetBlock(hash []byte) ([]byte, error) {
+	if len(hash) == 0 {
+		return nil, nil
+	}
+	return s.get(bucketName, hash)
+}
+
+func (s *boltStore) GetTip() ([]byte, error) {
+	return s.get(bucketName, []byte(lastBlockHashKey))
+}
+
+func (s *boltStore) GetUtxo(outpoint []byte) ([]byte, error) {
+	return s.get(utxoBucketName, outpoint)
+}
+
+func (s *boltStore) GetMeta(key string) ([]byte, error) {
+	return s.get(metaBucketName, []byte(key))
+}
+
+func (s *boltStore) GetUndo(hash []byte) ([]byte, error) {
+	return s.get(undoBucketName, hash)
+}
+
+type boltBatch struct {
+	tx *bolt.Tx
+}
+
+func (b *boltBatch) PutBlock(hash, data []byte) error {
+	return b.tx.Bucket([]byte(bucketName)).Put(hash, data)
+}
+
+func (b *boltBatch) DeleteBlock(hash []byte) error {
+	return b.tx.Bucket([]byte(bucketName)).Delete(hash)
+}
+
+func (b *boltBatch) SetTip(hash []byte) error {
+	return b.tx.Bucket([]byte(bucketName)).Put([]byte(lastBlockHashKey), hash)
+}
+
+func (b *boltBatch) PutUtxo(outpoint, data []byte) error {
+	return b.tx.Bucket([]byte(utxoBucketName)).Put(outpoint, data)
+}
+
+func (b *boltBatch) DeleteUtxo(outpoint []byte) error {
+	return b.tx.Bucket([]byte(utxoBucketName)).Delete(outpoint)
+}
+
+func (b *boltBatch) PutMeta(key string, value []byte) error {
+	return b.tx.Bucket([]byte(metaBucketName)).Put([]byte(key), value)
+}
+
+func (b *boltBatch) DeleteMeta(key string) error {
+	return b.tx.Bucket([]byte(metaBucketName)).Delete([]byte(key))
+}
+
+func (b *boltBatch) PutUndo(hash, data []byte) error {
+	return b.tx.Bucket([]byte(undoBucketName)).Put(hash, data)
+}
+
+func (b *boltBatch) DeleteUndo(hash []byte) error {
+	return b.tx.Bucket([]byte(undoBucketName)).Delete(hash)
+}
+
+func (s *boltStore) Update(f func(batch StoreBatch) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return f(&boltBatch{tx})
+	})
+}
+
+func (s *boltStore) ForEachBlock(f func(hash, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+			if string(k) == lastBlockHashKey {
+				return nil
+			}
+			return f(k, v)
+		})
+	})
+}
+
+func (s *boltStore) ForEachUtxo(f func(outpoint, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucketName)).ForEach(f)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,165 @@
+//go:build leveldb
+
+// LevelDB backend, built with `go build -tags leveldb`, blocks are stored under
+// 'b' + hash, their undo data under 'r' + hash, the hash of the last block under
+// lastBlockHashKey, unspent outputs under 'u' + outpoint and metadata under 'm' + key
+package main
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const levelDBName = "blockchain.ldb"
+
+func init() {
+	storeBackends["leveldb"] = &StoreBackend{Exists: levelDBStoreExists, Open: openLevelDBStore}
+}
+
+type levelDBStore struct {
+	db *leveldb.DB
+}
+
+func levelDBStoreExists() bool {
+	return IsFileExist(GetDataPath(levelDBName))
+}
+
+func openLevelDBStore() (BlockStore, error) {
+	if err := EnsureDataDir(); err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(GetDataPath(levelDBName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("open blockchain store fail, is a node running on it? %v", err)
+	}
+	return &levelDBStore{db}, nil
+}
+
+func levelDBBlockKey(hash []byte) []byte {
+	return append([]byte{'b'}, hash...)
+}
+
+func levelDBUtxoKey(outpoint []byte) []byte {
+	return append([]byte{'u'}, outpoint...)
+}
+
+func levelDBMetaKey(key string) []byte {
+	return append([]byte{'m'}, key...)
+}
+
+func levelDBUndoKey(hash []byte) []byte {
+	return append([]byte{'r'}, hash...)
+}
+
+func (s *levelDBStore) get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (s *levelDBStore) GetBlock(hash []byte) ([]byte, error) {
+	if len(hash) == 0 {
+		return nil, nil
+	}
+	return s.get(levelDBBlockKey(hash))
+}
+
+func (s *levelDBStore) GetTip() ([]byte, error) {
+	return s.get([]byte(lastBlockHashKey))
+}
+
+func (s *levelDBStore) GetUtxo(outpoint []byte) ([]byte, error) {
+	return s.get(levelDBUtxoKey(outpoint))
+}
+
+func (s *levelDBStore) GetMeta(key string) ([]byte, error) {
+	return s.get(levelDBMetaKey(key))
+}
+
+func (s *levelDBStore) GetUndo(hash []byte) ([]byte, error) {
+	return s.get(levelDBUndoKey(hash))
+}
+
+type levelDBBatch struct {
+	batch *leveldb.Batch
+}
+
+func (b *levelDBBatch) PutBlock(hash, data []byte) error {
+	b.batch.Put(levelDBBlockKey(hash), data)
+	return nil
+}
+
+func (b *levelDBBatch) DeleteBlock(hash []byte) error {
+	b.batch.Delete(levelDBBlockKey(hash))
+	return nil
+}
+
+func (b *levelDBBatch) SetTip(hash []byte) error {
+	b.batch.Put([]byte(lastBlockHashKey), hash)
+	return nil
+}
+
+func (b *levelDBBatch) PutUtxo(outpoint, data []byte) error {
+	b.batch.Put(levelDBUtxoKey(outpoint), data)
+	return nil
+}
+
+func (b *levelDBBatch) DeleteUtxo(outpoint []byte) error {
+	b.batch.Delete(levelDBUtxoKey(outpoint))
+	return nil
+}
+
+func (b *levelDBBatch) PutMeta(key string, value []byte) error {
+	b.batch.Put(levelDBMetaKey(key), value)
+	return nil
+}
+
+func (b *levelDBBatch) DeleteMeta(key string) error {
+	b.batch.Delete(levelDBMetaKey(key))
+	return nil
+}
+
+func (b *levelDBBatch) PutUndo(hash, data []byte) error {
+	b.batch.Put(levelDBUndoKey(hash), data)
+	return nil
+}
+
+func (b *levelDBBatch) DeleteUndo(hash []byte) error {
+	b.batch.Delete(levelDBUndoKey(hash))
+	return nil
+}
+
+func (s *levelDBStore) Update(f func(batch StoreBatch) error) error {
+	batch := &levelDBBatch{new(leveldb.Batch)}
+	if err := f(batch); err != nil {
+		return err
+	}
+	return s.db.Write(batch.batch, nil)
+}
+
+func (s *levelDBStore) forEach(prefix byte, f func(key, value []byte) error) error {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte{prefix}), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := f(iter.Key()[1:], iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (s *levelDBStore) ForEachBlock(f func(hash, data []byte) error) error {
+	return s.forEach('b', f)
+}
+
+func (s *levelDBStore) ForEachUtxo(f func(outpoint, data []byte) error) error {
+	return s.forEach('u', f)
+}
+
+func (s *levelDBStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// openTestStore opens a new store of the backend in a temp data dir on regtest
+func openTestStore(tb testing.TB, name string) BlockStore {
+	oldNetwork, oldDataDir := activeNetwork, dataDir
+	tb.Cleanup(func() {
+		activeNetwork, dataDir = oldNetwork, oldDataDir
+		memoryStoresMu.Lock()
+		delete(memoryStores, RegTestParams.Name)
+		memoryStoresMu.Unlock()
+	})
+	activeNetwork, dataDir = &RegTestParams, tb.TempDir()
+
+	backend := storeBackends[name]
+	if backend.Exists() {
+		tb.Fatalf("%s: store exists before open", name)
+	}
+	store, err := backend.Open()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	if !backend.Exists() {
+		tb.Fatalf("%s: store doesn't exist after open", name)
+	}
+	return store
+}
+
+func testHash(i int) []byte {
+	hash := sha256.Sum256(binary.LittleEndian.AppendUint64(nil, uint64(i)))
+	return hash[:]
+}
+
+func TestBlockStore(t *testing.T) {
+	for _, name := range StoreBackendNames() {
+		t.Run(name, func(t *testing.T) {
+			store := openTestStore(t, name)
+			if tip, err := store.GetTip(); err != nil || tip != nil {
+				t.Fatalf("tip of empty store: %x %v", tip, err)
+			}
+			if data, err := store.GetBlock(testHash(0)); err != nil || data != nil {
+				t.Fatalf("block of empty store: %x %v", data, err)
+			}
+
+			err := store.Update(func(batch StoreBatch) error {
+				for i := 0; i < 3; i++ {
+					if err := batch.PutBlock(testHash(i), []byte{byte(i)}); err != nil {
+						return err
+					}
+				}
+				return batch.SetTip(testHash(2))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a failed batch writes nothing
+			errFail := errors.New("fail")
+			err = store.Update(func(batch StoreBatch) error {
+				batch.PutBlock(testHash(3), []byte{3})
+				batch.SetTip(testHash(3))
+				return errFail
+			})
+			if err != errFail {
+				t.Fatalf("got %v, want %v", err, errFail)
+			}
+
+			if tip, _ := store.GetTip(); !bytes.Equal(tip, testHash(2)) {
+				t.Errorf("got tip %x, want %x", tip, testHash(2))
+			}
+			if data, _ := store.GetBlock(testHash(1)); !bytes.Equal(data, []byte{1}) {
+				t.Errorf("got block %x, want 01", data)
+			}
+			if data, _ := store.GetBlock(testHash(3)); data != nil {
+				t.Errorf("block of failed batch is stored: %x", data)
+			}
+			found := make(map[string]bool)
+			err = store.ForEachBlock(func(hash, data []byte) error {
+				found[string(hash)] = true
+				return nil
+			})
+			if err != nil || len(found) != 3 || !found[string(testHash(0))] {
+				t.Errorf("got %d blocks, want 3: %v", len(found), err)
+			}
+
+			// writes of a batch apply in order, so the utxo of 1 is deleted
+			err = store.Update(func(batch StoreBatch) error {
+				for i := 0; i < 3; i++ {
+					if err := batch.PutUtxo(testHash(i), []byte{byte(i)}); err != nil {
+						return err
+					}
+				}
+				if err := batch.DeleteUtxo(testHash(1)); err != nil {
+					return err
+				}
+				return batch.PutMeta("key", []byte("value"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := store.GetUtxo(testHash(2)); !bytes.Equal(data, []byte{2}) {
+				t.Errorf("got utxo %x, want 02", data)
+			}
+			if data, _ := store.GetUtxo(testHash(1)); data != nil {
+				t.Errorf("deleted utxo is stored: %x", data)
+			}
+			count := 0
+			err = store.ForEachUtxo(func(outpoint, data []byte) error {
+				count++
+				return nil
+			})
+			if err != nil || count != 2 {
+				t.Errorf("got %d utxos, want 2: %v", count, err)
+			}
+			if value, _ := store.GetMeta("key"); string(value) != "value" {
+				t.Errorf("got meta %q, want value", value)
+			}
+			if value, _ := store.GetMeta("none"); value != nil {
+				t.Errorf("got meta %q of unset key", value)
+			}
+
+			err = store.Update(func(batch StoreBatch) error {
+				if err := batch.PutUndo(testHash(0), []byte{0}); err != nil {
+					return err
+				}
+				if err := batch.DeleteBlock(testHash(1)); err != nil {
+					return err
+				}
+				return batch.DeleteMeta("key")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := store.GetUndo(testHash(0)); !bytes.Equal(data, []byte{0}) {
+				t.Errorf("got undo %x, want 00", data)
+			}
+			if data, _ := store.GetBlock(testHash(1)); data != nil {
+				t.Errorf("deleted block is stored: %x", data)
+			}
+			if value, _ := store.GetMeta("key"); value != nil {
+				t.Errorf("deleted meta is stored: %q", value)
+			}
+		})
+	}
+}
+
+func TestBlockChainMemoryStore(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	bc, err := NewBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	genesis := NewBlock([]*Transaction{NewMiningTx(address, "genesis", 0)}, []byte{}, 0)
+	if err := bc.AcceptBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	for height := uint64(1); height <= 3; height++ {
+		if _, err := bc.AddBlock([]*Transaction{NewMiningTx(address, "test", height)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bc.GetBestHeight() != 3 {
+		t.Errorf("got best height %d, want 3", bc.GetBestHeight())
+	}
+	block, err := bc.GetBlockByHeight(1)
+	if err != nil || block.Height != 1 {
+		t.Fatalf("got block %v at height 1: %v", block, err)
+	}
+	if !bc.HasBlock(genesis.Hash) || bc.HasBlock(nil) {
+		t.Error("HasBlock is wrong")
+	}
+	pubKeyHash, _ := GetPubKeyHashFromAddress(address)
+	if _, balance := bc.FindUtxo(pubKeyHash); balance != 4*activeNetwork.Reward {
+		t.Errorf("got balance %d, want %d", balance, 4*activeNetwork.Reward)
+	}
+}
+
+func BenchmarkBlockStore(b *testing.B) {
+	data := make([]byte, 1024)
+	for _, name := range StoreBackendNames() {
+		b.Run(name+"/put", func(b *testing.B) {
+			store := openTestStore(b, name)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := store.Update(func(batch StoreBatch) error {
+					if err := batch.PutBlock(testHash(i), data); err != nil {
+						return err
+					}
+					return batch.SetTip(testHash(i))
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/get", func(b *testing.B) {
+			store := openTestStore(b, name)
+			const n = 1000
+			store.Update(func(batch StoreBatch) error {
+				for i := 0; i < n; i++ {
+					batch.PutBlock(testHash(i), data)
+				}
+				return nil
+			})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if block, err := store.GetBlock(testHash(i % n)); err != nil || block == nil {
+					b.Fatal("block not found", err)
+				}
+			}
+		})
+	}
+}
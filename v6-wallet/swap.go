@@ -0,0 +1,227 @@
+// hash time-locked contracts (HTLC): an HTLC output is redeemed by its recipient with the
+// secret whose sha256 is in the contract, or refunded to its creator after the lock time.
+// An atomic swap locks coins of two chains in contracts with the same secret hash: the
+// initiator redeems the participant's contract, which reveals the secret for the
+// participant to redeem the initiator's one, or both are refunded. The participant's
+// contract times out first, so the initiator can't take both coins by waiting.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	SecretSize = 32
+	htlcSize   = sha256.Size + 20 + 20 + 4 // secret hash, recipient, refund and lock time
+
+	initiatorTimeout   = 48 * time.Hour
+	participantTimeout = 24 * time.Hour
+)
+
+// HTLC pays its output to Recipient with the preimage of SecretHash, or to Refund after LockTime
+type HTLC struct {
+	SecretHash []byte
+	Recipient  []byte // public key hash redeeming with the secret
+	Refund     []byte // public key hash refunded after LockTime
+	LockTime   uint32 // height, or unix time from LockTimeThreshold, see Transaction.LockTime
+}
+
+// Bytes encodes the contract into ScriptPubKeyHash of an OutputHTLC output
+func (c *HTLC) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(c.SecretHash)
+	buf.Write(c.Recipient)
+	buf.Write(c.Refund)
+	binary.Write(&buf, binary.LittleEndian, c.LockTime)
+	return buf.Bytes()
+}
+
+func ParseHTLC(data []byte) (*HTLC, error) {
+	if len(data) != htlcSize {
+		return nil, fmt.Errorf("contract is %d bytes, want %d", len(data), htlcSize)
+	}
+	c := &HTLC{
+		SecretHash: data[:32],
+		Recipient:  data[32:52],
+		Refund:     data[52:72],
+		LockTime:   binary.LittleEndian.Uint32(data[72:]),
+	}
+	if c.LockTime == 0 {
+		return nil, errors.New("contract has no lock time")
+	}
+	return c, nil
+}
+
+// htlcKeyHash returns the recipient if input has the secret, otherwise the refund key
+// if tx is locked until the lock time of the contract like OP_CHECKLOCKTIMEVERIFY, so
+// IsFinal holds it until then
+func (tx *Transaction) htlcKeyHash(input TxInput, output TxOutput) ([]byte, error) {
+	c, err := ParseHTLC(output.ScriptPubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	if input.Secret != nil {
+		if hash := sha256.Sum256(input.Secret); !bytes.Equal(hash[:], c.SecretHash) {
+			return nil, errors.New("secret doesn't match the hash of the contract")
+		}
+		return c.Recipient, nil
+	}
+	if (tx.LockTime < LockTimeThreshold) != (c.LockTime < LockTimeThreshold) || tx.LockTime < c.LockTime {
+		return nil, fmt.Errorf("refund must be locked until %s", lockTimeString(c.LockTime))
+	}
+	return c.Refund, nil
+}
+
+// FindContract returns the HTLC of tx and the index of its output
+func FindContract(tx *Transaction) (*HTLC, int, error) {
+	for i, output := range tx.TxOutputs {
+		if output.Type == OutputHTLC {
+			c, err := ParseHTLC(output.ScriptPubKeyHash)
+			return c, i, err
+		}
+	}
+	return nil, 0, fmt.Errorf("transaction %x has no contract", tx.Id)
+}
+
+// NewSwapSecret returns a random secret and its hash for the initiator's contract
+func NewSwapSecret() ([]byte, []byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, nil, err
+	}
+	hash := sha256.Sum256(secret)
+	return secret, hash[:], nil
+}
+
+// SwapLockTime returns the unix time timeout from now as a lock time
+func SwapLockTime(timeout time.Duration) (uint32, error) {
+	lockTime := GetTime() + int64(timeout/time.Second)
+	if timeout <= 0 || lockTime > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid timeout %s", timeout)
+	}
+	return uint32(lockTime), nil
+}
+
+// NewSwapTransaction locks amount of from in a contract redeemed by to with the secret
+// of secretHash, or refunded to from after lockTime
+func NewSwapTransaction(from, to string, amount, fee int64, secretHash []byte, lockTime uint32, bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+	_, refund, err := DecodeAddress(from)
+	if err != nil {
+		return nil, errors.New("invalid address")
+	}
+	_, recipient, err := DecodeAddress(to)
+	if err != nil {
+		return nil, errors.New("invalid address")
+	}
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if len(secretHash) != sha256.Size {
+		return nil, fmt.Errorf("secret hash must be %d bytes", sha256.Size)
+	}
+	c := &HTLC{secretHash, recipient, refund, lockTime}
+	return newWalletTx(from, TxOutput{c.Bytes(), amount, OutputHTLC}, fee, 0, 0, bc, wm)
+}
+
+// NewSwapSpendTransaction redeems the contract of contractTx with secret, or refunds it
+// if secret is nil, to the address of the key in wallet
+func NewSwapSpendTransaction(contractTx *Transaction, secret []byte, fee int64, bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+	c, vout, err := FindContract(contractTx)
+	if err != nil {
+		return nil, err
+	}
+	keyHash, lockTime := c.Recipient, uint32(0)
+	if secret == nil {
+		keyHash, lockTime = c.Refund, c.LockTime
+	} else if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], c.SecretHash) {
+		return nil, errors.New("secret doesn't match the hash of the contract")
+	}
+	address := EncodeAddress(OutputPubKeyHash, keyHash)
+	wallet := wm.GetWallet(address)
+	if wallet == nil {
+		return nil, fmt.Errorf("key of %s is not in wallet", address)
+	}
+	value := contractTx.TxOutputs[vout].Value
+	if fee < 0 || fee >= value {
+		return nil, fmt.Errorf("fee must be 0 to %d", value-1)
+	}
+
+	tx := &Transaction{
+		TxInputs:  []TxInput{{TxId: contractTx.Id, Index: int64(vout), PubKey: wallet.PubKey, Secret: secret}},
+		TxOutputs: []TxOutput{{keyHash, value - fee, OutputPubKeyHash}},
+		TimeStamp: GetTime(),
+		LockTime:  lockTime,
+	}
+	tx.SetHash()
+	if !bc.SignTransaction(tx, wallet.PrivateKey()) {
+		return nil, errors.New("sign transaction failed")
+	}
+	return tx, nil
+}
+
+// SwapJSON describes a contract, the result of initiateswap, participateswap and auditswap
+type SwapJSON struct {
+	Txid          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Amount        int64  `json:"amount"`
+	Recipient     string `json:"recipient"`
+	Refund        string `json:"refund"`
+	SecretHash    string `json:"secrethash"`
+	LockTime      uint32 `json:"locktime"`
+	Secret        string `json:"secret,omitempty"` // made by initiateswap, or revealed by the redeem transaction
+	Confirmations uint64 `json:"confirmations"`
+	SpentBy       string `json:"spentby,omitempty"` // redeem or refund transaction
+}
+
+func NewSwapJSON(tx *Transaction) (*SwapJSON, error) {
+	c, vout, err := FindContract(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &SwapJSON{
+		Txid:       hex.EncodeToString(tx.Id),
+		Vout:       vout,
+		Amount:     tx.TxOutputs[vout].Value,
+		Recipient:  EncodeAddress(OutputPubKeyHash, c.Recipient),
+		Refund:     EncodeAddress(OutputPubKeyHash, c.Refund),
+		SecretHash: hex.EncodeToString(c.SecretHash),
+		LockTime:   c.LockTime,
+	}, nil
+}
+
+// AuditSwap describes the contract of the transaction with txid in the chain, with the
+// secret if it's redeemed
+func (bc *BlockChain) AuditSwap(txid []byte) (*SwapJSON, error) {
+	tx, block := bc.FindTransactionBlock(txid)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found%s", txid, prunedHint(bc))
+	}
+	swap, err := NewSwapJSON(tx)
+	if err != nil {
+		return nil, err
+	}
+	swap.Confirmations = bc.GetBestHeight() - block.Height + 1
+	if entry, err := bc.GetUtxo(txid, int64(swap.Vout)); err != nil || entry != nil {
+		return swap, err
+	}
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil && swap.SpentBy == ""; block = iter.Next() {
+		for _, spender := range block.Transactions {
+			for _, input := range spender.TxInputs {
+				if bytes.Equal(input.TxId, txid) && input.Index == int64(swap.Vout) {
+					swap.SpentBy = hex.EncodeToString(spender.Id)
+					swap.Secret = hex.EncodeToString(input.Secret)
+				}
+			}
+		}
+	}
+	return swap, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwap(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer SetMockTime(0)
+	SetMockTime(1700000000)
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	initiator, participant := NewWalletKeyPair(), NewWalletKeyPair()
+	from, to := initiator.GetAddress(), participant.GetAddress()
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: initiator, to: participant}, Meta: map[string]*AddressMeta{}}
+	bc := newTestChain(t, from, 1)
+	mine := func(txs ...*Transaction) error {
+		height := bc.GetBestHeight() + 1
+		_, err := bc.AddBlock(append([]*Transaction{NewMiningTx(miner, "test", height)}, txs...))
+		return err
+	}
+	newContract := func(secretHash []byte) *Transaction {
+		lockTime, err := SwapLockTime(time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := NewSwapTransaction(from, to, 5, 0, secretHash, lockTime, bc, wm)
+		if err == nil {
+			err = mine(tx)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	secret, secretHash, err := NewSwapSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := newContract(secretHash)
+	if _, err := NewSwapSpendTransaction(contract, make([]byte, SecretSize), 0, bc, wm); err == nil {
+		t.Error("contract is redeemed with a wrong secret")
+	}
+	// a signed redeem whose secret is replaced is rejected by the chain too
+	redeem, err := NewSwapSpendTransaction(contract, secret, 1, bc, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := redeem.TrimmedCopy()
+	forged.TxInputs[0].Secret = make([]byte, SecretSize)
+	forged.SetHash()
+	bc.SignTransaction(forged, participant.PrivateKey())
+	if err := mine(forged); err == nil {
+		t.Error("redeem with a wrong secret is mined")
+	}
+	// the initiator's key doesn't match the recipient
+	stolen := redeem.TrimmedCopy()
+	stolen.TxInputs[0].PubKey = initiator.PubKey
+	stolen.SetHash()
+	bc.SignTransaction(stolen, initiator.PrivateKey())
+	if err := mine(stolen); err == nil {
+		t.Error("redeem signed by another key is mined")
+	}
+	if err := mine(redeem); err != nil {
+		t.Fatal(err)
+	}
+	swap, err := bc.AuditSwap(contract.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swap.Secret != hex.EncodeToString(secret) || swap.SpentBy != hex.EncodeToString(redeem.Id) {
+		t.Errorf("got secret %s spent by %s, want %x spent by %x", swap.Secret, swap.SpentBy, secret, redeem.Id)
+	}
+	pubKeyHash, _ := GetPubKeyHashFromAddress(to)
+	if _, balance := bc.FindUtxo(pubKeyHash); balance != 4 {
+		t.Errorf("got balance %d of recipient, want 4", balance)
+	}
+
+	contract = newContract(secretHash)
+	refund, err := NewSwapSpendTransaction(contract, nil, 0, bc, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mine(refund); err == nil {
+		t.Fatal("refund is mined before the lock time")
+	}
+	// median time past passes the lock time once blocks after it are the majority
+	SetMockTime(1700000000 + 2*3600)
+	for i := 0; i < medianTimeBlocks; i++ {
+		if err := mine(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mine(refund); err != nil {
+		t.Fatal(err)
+	}
+	if swap, err := bc.AuditSwap(contract.Id); err != nil || swap.Secret != "" || swap.SpentBy != hex.EncodeToString(refund.Id) {
+		t.Errorf("got refunded contract %+v: %v", swap, err)
+	}
+}
+
+func TestSwapRPCFee(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	s := &RPCServer{node: NewNode(newTestChain(t, address, 1), "test", "")}
+	txid := `"` + strings.Repeat("ab", 32) + `"`
+	tests := []struct {
+		name    string
+		handler rpcHandler
+		params  []string
+	}{
+		{"initiateswap", rpcInitiateSwap, []string{`"` + address + `"`, `"` + address + `"`, "5", "-1"}},
+		{"initiateswap", rpcInitiateSwap, []string{`"` + address + `"`, `"` + address + `"`, "5", "9223372036854775807"}},
+		{"refundswap", rpcRefundSwap, []string{txid, "-1"}},
+		{"redeemswap", rpcRedeemSwap, []string{txid, `"00"`, "-1"}},
+	}
+	for _, test := range tests {
+		params := make([]json.RawMessage, len(test.params))
+		for i, param := range test.params {
+			params[i] = json.RawMessage(param)
+		}
+		_, err := test.handler(s, params)
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParams {
+			t.Errorf("%s %v: got error %v, want invalid params", test.name, test.params, err)
+		}
+	}
+}
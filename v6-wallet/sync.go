@@ -0,0 +1,537 @@
+// SyncManager downloads the chain headers first: headers are fetched from one peer and
+// validated by proof of work and linkage, then blocks of the first downloadWindow headers
+// are requested from all peers in parallel and connected in order as they arrive. Headers
+// of a higher branch forking below the tail are downloaded the same way, the chain is
+// switched to the branch once its blocks make it higher.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	maxHeadersPerMsg  = 2000
+	downloadWindow    = 1024 // only blocks this far after the tip are requested
+	maxBlocksPerPeer  = 16
+	blockStallTimeout = 15 * time.Second
+	headersTimeout    = 30 * time.Second
+	syncTickInterval  = time.Second
+)
+
+type blockRequest struct {
+	peer *Peer
+	time time.Time
+}
+
+type downloadedBlock struct {
+	block *Block
+	peer  *Peer // sender, disconnected if the block is invalid
+}
+
+type SyncManager struct {
+	node *Node
+
+	connectMu        sync.Mutex // held while connecting downloaded blocks, before mu
+	mu               sync.Mutex
+	headers          []*Block          // validated headers after the tip or forkHeight, from old to new
+	forkHeight       int64             // height of the block the headers fork at below the tip, -1 if none
+	headerSet        map[string]*Block // hash -> header
+	headersPeer      *Peer             // peer headers are being downloaded from
+	headersRequested time.Time
+	inFlight         map[string]*blockRequest    // hash -> request
+	downloaded       map[string]*downloadedBlock // received blocks waiting for their parents
+}
+
+func NewSyncManager(n *Node) *SyncManager {
+	return &SyncManager{
+		node:       n,
+		forkHeight: -1,
+		headerSet:  make(map[string]*Block),
+		inFlight:   make(map[string]*blockRequest),
+		downloaded: make(map[string]*downloadedBlock),
+	}
+}
+
+// run schedules downloads and drops stalled peers periodically
+func (sm *SyncManager) run() {
+	ticker := time.NewTicker(syncTickInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sm.checkStalls()
+		sm.scheduleDownloads()
+	}
+}
+
+// IsSyncing tells if there are headers whose blocks are not connected yet
+func (sm *SyncManager) IsSyncing() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return len(sm.headers) > 0 || sm.headersPeer != nil
+}
+
+// headerTip returns hash and height of the last header, or of the tail if no header is waiting
+func (sm *SyncManager) headerTip() ([]byte, int64) {
+	if len(sm.headers) > 0 {
+		last := sm.headers[len(sm.headers)-1]
+		return last.Hash, int64(last.Height)
+	}
+	sm.node.mu.Lock()
+	defer sm.node.mu.Unlock()
+	if sm.node.bc.GetTail() == nil {
+		return nil, -1
+	}
+	return sm.node.bc.GetTail(), int64(sm.node.bc.GetBestHeight())
+}
+
+// peerReady starts headers sync if the peer is ahead of us
+func (sm *SyncManager) peerReady(p *Peer) {
+	sm.mu.Lock()
+	_, height := sm.headerTip()
+	start := sm.headersPeer == nil && p.BestHeight() > height
+	if start {
+		sm.headersPeer = p
+		sm.headersRequested = time.Now()
+	}
+	sm.mu.Unlock()
+	if start {
+		sm.requestHeaders(p)
+	}
+}
+
+// peerGone releases blocks requested from the peer and finds another one for headers
+func (sm *SyncManager) peerGone(p *Peer) {
+	sm.mu.Lock()
+	for hash, req := range sm.inFlight {
+		if req.peer == p {
+			delete(sm.inFlight, hash)
+		}
+	}
+	var next *Peer
+	if sm.headersPeer == p {
+		next = sm.nextHeadersPeer()
+	}
+	sm.mu.Unlock()
+	if next != nil {
+		sm.requestHeaders(next)
+	}
+	sm.scheduleDownloads()
+}
+
+// nextHeadersPeer picks the peer with the highest chain if it's ahead of the header tip
+func (sm *SyncManager) nextHeadersPeer() *Peer {
+	sm.headersPeer = nil
+	_, height := sm.headerTip()
+	var best *Peer
+	for _, p := range sm.node.readyPeers() {
+		if p.BestHeight() > height && (best == nil || p.BestHeight() > best.BestHeight()) {
+			best = p
+		}
+	}
+	if best != nil {
+		sm.headersPeer = best
+		sm.headersRequested = time.Now()
+	}
+	return best
+}
+
+// requestHeaders asks for headers after the header tip, the last header is put before
+// the locator of the chain so the peer continues from it if it's on the same chain
+func (sm *SyncManager) requestHeaders(p *Peer) {
+	sm.mu.Lock()
+	var locator [][]byte
+	if len(sm.headers) > 0 {
+		locator = append(locator, sm.headers[len(sm.headers)-1].Hash)
+	}
+	sm.mu.Unlock()
+	sm.node.mu.Lock()
+	locator = append(locator, sm.node.bc.GetBlockLocator()...)
+	sm.node.mu.Unlock()
+	p.sendPayload(CmdGetHeaders, &GetHeadersMsg{locator})
+}
+
+// handleHeaders validates headers and appends them to the header chain. Headers of a
+// branch forking below the tail start the header chain at the fork if the peer says the
+// branch is higher, other forking headers are ignored.
+func (sm *SyncManager) handleHeaders(p *Peer, headers []*Block) error {
+	if len(headers) > maxHeadersPerMsg {
+		return fmt.Errorf("too many headers: %d", len(headers))
+	}
+	sm.mu.Lock()
+	tipHash, tipHeight := sm.headerTip()
+	chainHeight := tipHeight
+	if len(sm.headers) == 0 && len(headers) > 0 && !bytes.Equal(headers[0].PrevHash, tipHash) && !sm.hasBlock(headers[0].Hash) {
+		// the peer replies to the locator from the last block we share, its headers
+		// are of another branch
+		peerHeight := p.BestHeight()
+		if last := int64(headers[len(headers)-1].Height); last > peerHeight {
+			peerHeight = last
+		}
+		if fork := sm.forkPoint(headers[0]); fork != nil && peerHeight > tipHeight {
+			logInfo("Peer %s is on a higher branch forking at height %d", p, fork.Height)
+			sm.forkHeight = int64(fork.Height)
+			tipHash, tipHeight = fork.Hash, int64(fork.Height)
+		}
+	}
+	added := 0
+	for _, header := range headers {
+		if sm.headerSet[string(header.Hash)] != nil || sm.hasBlock(header.Hash) {
+			continue
+		}
+		if !bytes.Equal(header.PrevHash, tipHash) {
+			logWarn("headers from %s don't connect to header tip %x", p, tipHash)
+			break
+		}
+		if int64(header.Height) != tipHeight+1 {
+			sm.mu.Unlock()
+			return fmt.Errorf("header height is %d, want %d", header.Height, tipHeight+1)
+		}
+		if err := header.CheckHeader(); err != nil {
+			sm.mu.Unlock()
+			return fmt.Errorf("invalid header %x: %s", header.Hash, err)
+		}
+		sm.headers = append(sm.headers, header)
+		sm.headerSet[string(header.Hash)] = header
+		tipHash, tipHeight = header.Hash, int64(header.Height)
+		added++
+	}
+	if len(headers) > 0 {
+		p.updateBestHeight(int64(headers[len(headers)-1].Height))
+	}
+	if sm.forkHeight >= 0 && (len(sm.headers) == 0 || len(headers) < maxHeadersPerMsg && tipHeight <= chainHeight) {
+		// no headers of the branch are taken or the peer has no more, its branch isn't higher after all
+		sm.resetHeaders()
+		added = 0
+	}
+
+	var next *Peer
+	if sm.headersPeer == p {
+		if len(headers) == maxHeadersPerMsg {
+			// peer has more
+			sm.headersRequested = time.Now()
+			next = p
+		} else if added > 0 || len(headers) == 0 {
+			next = sm.nextHeadersPeer()
+		} else {
+			// headers of a fork, e.g. invalidated blocks, asking again gets the same
+			sm.headersPeer = nil
+		}
+	}
+	sm.mu.Unlock()
+
+	if added > 0 {
+		logInfo("Received %d headers from %s, header height %d", added, p, tipHeight)
+	}
+	if next != nil {
+		sm.requestHeaders(next)
+	}
+	sm.scheduleDownloads()
+	return nil
+}
+
+func (sm *SyncManager) hasBlock(hash []byte) bool {
+	sm.node.mu.Lock()
+	defer sm.node.mu.Unlock()
+	return sm.node.bc.HasBlock(hash)
+}
+
+// forkPoint returns the block in the chain header builds on, nil if there is none,
+// sm.mu must be held
+func (sm *SyncManager) forkPoint(header *Block) *Block {
+	sm.node.mu.Lock()
+	defer sm.node.mu.Unlock()
+	fork, err := sm.node.bc.GetBlock(header.PrevHash)
+	if err != nil || !sm.node.bc.isActive(fork) {
+		return nil
+	}
+	return fork
+}
+
+// scheduleDownloads requests blocks in the window from peers having them,
+// at most maxBlocksPerPeer blocks are in flight for each peer
+func (sm *SyncManager) scheduleDownloads() {
+	requests := make(map[*Peer][][]byte)
+	sm.mu.Lock()
+	count := make(map[*Peer]int)
+	for _, req := range sm.inFlight {
+		count[req.peer]++
+	}
+	peers := sm.node.readyPeers()
+	window := sm.headers
+	size := downloadWindow
+	if sm.forkHeight >= 0 {
+		// the branch is connected at once, all its blocks up to the chain height are needed
+		if needed := int(sm.node.bestHeight()-sm.forkHeight) + 1; needed > size {
+			size = needed
+		}
+	}
+	if len(window) > size {
+		window = window[:size]
+	}
+	for _, header := range window {
+		hash := string(header.Hash)
+		if sm.inFlight[hash] != nil || sm.downloaded[hash] != nil {
+			continue
+		}
+		// the least busy peer having the block
+		var target *Peer
+		for _, p := range peers {
+			if count[p] < maxBlocksPerPeer && p.BestHeight() >= int64(header.Height) &&
+				(target == nil || count[p] < count[target]) {
+				target = p
+			}
+		}
+		if target == nil {
+			continue
+		}
+		count[target]++
+		sm.inFlight[hash] = &blockRequest{target, time.Now()}
+		requests[target] = append(requests[target], header.Hash)
+	}
+	sm.mu.Unlock()
+
+	for p, hashes := range requests {
+		logDebug("request %d blocks from %s", len(hashes), p)
+		p.sendPayload(CmdGetData, &GetDataMsg{InvTypeBlock, hashes})
+	}
+}
+
+// checkStalls disconnects peers that don't deliver requested blocks or headers in time,
+// their requests are released and given to other peers
+func (sm *SyncManager) checkStalls() {
+	stalled := make(map[*Peer]string)
+	sm.mu.Lock()
+	for hash, req := range sm.inFlight {
+		if time.Since(req.time) > blockStallTimeout {
+			stalled[req.peer] = fmt.Sprintf("block %x", hash)
+		}
+	}
+	if sm.headersPeer != nil && time.Since(sm.headersRequested) > headersTimeout {
+		stalled[sm.headersPeer] = "headers"
+	}
+	sm.mu.Unlock()
+
+	for p, what := range stalled {
+		logWarn("Peer %s stalled on downloading %s, disconnecting", p, what)
+		p.conn.Close()
+	}
+}
+
+// handleBlock stores a requested block and connects blocks in order, it returns
+// false if the block wasn't requested. A block not matching its header is dropped,
+// the error disconnects the peer and peerGone requests the block from another one.
+func (sm *SyncManager) handleBlock(p *Peer, block *Block) (bool, error) {
+	sm.mu.Lock()
+	hash := string(block.Hash)
+	header := sm.headerSet[hash]
+	if header == nil {
+		sm.mu.Unlock()
+		return false, nil
+	}
+	delete(sm.inFlight, hash)
+	if err := matchHeader(block, header); err != nil {
+		sm.mu.Unlock()
+		return true, fmt.Errorf("bogus block %x: %v", block.Hash, err)
+	}
+	sm.downloaded[hash] = &downloadedBlock{block, p}
+	sm.mu.Unlock()
+
+	for _, block := range sm.connectDownloaded() {
+		logInfo("Accepted block %x at height %d", block.Hash, block.Height)
+		sm.node.broadcastInv(InvTypeBlock, block.Hash, nil)
+	}
+	sm.scheduleDownloads()
+	return true, nil
+}
+
+// connectDownloaded connects downloaded blocks following the tip in order, sm.mu is
+// released while the node validates each block and connectMu keeps the order
+func (sm *SyncManager) connectDownloaded() []*Block {
+	sm.connectMu.Lock()
+	defer sm.connectMu.Unlock()
+	var accepted []*Block
+	for {
+		sm.mu.Lock()
+		if len(sm.headers) == 0 {
+			sm.headers = nil // release the underlying array
+			sm.mu.Unlock()
+			return accepted
+		}
+		if sm.forkHeight >= 0 {
+			connected := sm.switchBranch()
+			if connected == nil {
+				return accepted
+			}
+			accepted = append(accepted, connected...)
+			continue
+		}
+		header := sm.headers[0]
+		downloaded := sm.downloaded[string(header.Hash)]
+		if downloaded == nil {
+			sm.mu.Unlock()
+			return accepted
+		}
+		delete(sm.downloaded, string(header.Hash))
+		sm.mu.Unlock()
+
+		next := downloaded.block
+		switch err := sm.node.acceptBlock(next); err {
+		case nil:
+			accepted = append(accepted, next)
+		case ErrBlockExists:
+		case ErrOrphanBlock:
+			// the tail moved meanwhile, the headers don't follow it anymore
+			sm.restartHeaders()
+			return accepted
+		default:
+			// the block is the one its header commits to, so the header chain is invalid
+			// from it, drop the rest and start over with headers from another peer
+			logWarn("Invalid block %x from %s: %s, disconnecting", next.Hash, downloaded.peer, err)
+			downloaded.peer.conn.Close()
+			sm.restartHeaders()
+			return accepted
+		}
+		sm.mu.Lock()
+		// headers may be reset by tailMovedBack meanwhile
+		if len(sm.headers) > 0 && bytes.Equal(sm.headers[0].Hash, header.Hash) {
+			sm.headers = sm.headers[1:]
+			delete(sm.headerSet, string(header.Hash))
+		}
+		sm.mu.Unlock()
+	}
+}
+
+// switchBranch switches the chain to the branch of the headers once blocks making it
+// higher are downloaded, it's called with sm.mu held and releases it. Returns the blocks
+// connected, nil if the blocks aren't there yet or the branch is invalid.
+func (sm *SyncManager) switchBranch() []*Block {
+	bestHeight := sm.node.bestHeight()
+	var branch []*downloadedBlock
+	for _, header := range sm.headers {
+		downloaded := sm.downloaded[string(header.Hash)]
+		if downloaded == nil {
+			sm.mu.Unlock()
+			return nil
+		}
+		branch = append(branch, downloaded)
+		if int64(header.Height) > bestHeight {
+			break
+		}
+	}
+	if len(branch) == 0 || int64(branch[len(branch)-1].block.Height) <= bestHeight {
+		sm.mu.Unlock()
+		return nil
+	}
+	blocks := make([]*Block, len(branch))
+	for i, downloaded := range branch {
+		blocks[i] = downloaded.block
+		delete(sm.downloaded, string(downloaded.block.Hash))
+	}
+	forkHeight := uint64(sm.forkHeight)
+	sm.mu.Unlock()
+
+	if err := sm.node.switchBranch(forkHeight, blocks); err != nil {
+		// the blocks match their headers, so the branch is invalid, drop its senders
+		logWarn("Switch to branch forking at height %d fail: %s", forkHeight, err)
+		for _, downloaded := range branch {
+			downloaded.peer.conn.Close()
+		}
+		sm.restartHeaders()
+		return nil
+	}
+	logInfo("Switched to branch forking at height %d", forkHeight)
+	sm.mu.Lock()
+	// headers may be reset by tailMovedBack meanwhile
+	if len(sm.headers) >= len(blocks) && bytes.Equal(sm.headers[0].Hash, blocks[0].Hash) {
+		for _, block := range blocks {
+			delete(sm.headerSet, string(block.Hash))
+		}
+		sm.headers = sm.headers[len(blocks):]
+		sm.forkHeight = -1
+	}
+	sm.mu.Unlock()
+	return blocks
+}
+
+// tailMovedBack drops pending headers, which may build on disconnected blocks, and
+// asks the highest peer for headers after the new tail, node.mu must not be held
+func (sm *SyncManager) tailMovedBack() {
+	sm.restartHeaders()
+}
+
+// restartHeaders drops pending headers and asks the highest peer for headers after the tail
+func (sm *SyncManager) restartHeaders() {
+	sm.mu.Lock()
+	sm.resetHeaders()
+	next := sm.nextHeadersPeer()
+	sm.mu.Unlock()
+	if next != nil {
+		sm.requestHeaders(next)
+	}
+}
+
+// matchHeader tells why block isn't the one header commits to, its hash, header fields,
+// transaction ids and merkle root are computed again
+func matchHeader(block, header *Block) error {
+	if err := block.CheckHeader(); err != nil {
+		return err
+	}
+	blockHeader, err := block.Header().Serialize()
+	if err != nil {
+		return err
+	}
+	wantHeader, err := header.Header().Serialize()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(blockHeader, wantHeader) {
+		return errors.New("block doesn't match its header")
+	}
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.CalcId(), tx.Id) {
+			return fmt.Errorf("transaction id %x doesn't match its content", tx.Id)
+		}
+	}
+	merkle := *block
+	merkle.HashTransactionsMerkleRoot()
+	if !bytes.Equal(merkle.MerkleRoot, block.MerkleRoot) {
+		return errors.New("merkle root doesn't match transactions")
+	}
+	return nil
+}
+
+func (sm *SyncManager) resetHeaders() {
+	sm.headers = nil
+	sm.forkHeight = -1
+	sm.headerSet = make(map[string]*Block)
+	sm.inFlight = make(map[string]*blockRequest)
+	sm.downloaded = make(map[string]*downloadedBlock)
+}
+
+// Status reports sync progress and peers
+func (sm *SyncManager) Status() *StatusMsg {
+	status := &StatusMsg{BlockHeight: sm.node.bestHeight(), Peers: make([]PeerStatus, 0)}
+	sm.mu.Lock()
+	_, status.HeaderHeight = sm.headerTip()
+	status.InFlight = len(sm.inFlight)
+	status.Downloaded = len(sm.downloaded)
+	count := make(map[*Peer]int)
+	for _, req := range sm.inFlight {
+		count[req.peer]++
+	}
+	sm.mu.Unlock()
+
+	for _, p := range sm.node.readyPeers() {
+		status.Peers = append(status.Peers, PeerStatus{
+			Addr:       p.String(),
+			Inbound:    p.inbound,
+			UserAgent:  p.version.UserAgent,
+			BestHeight: p.BestHeight(),
+			InFlight:   count[p],
+		})
+	}
+	return status
+}
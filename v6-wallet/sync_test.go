@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// chainBlocks returns blocks of bc from genesis to the tail
+func chainBlocks(bc *BlockChain) []*Block {
+	var blocks []*Block
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		blocks = append([]*Block{block}, blocks...)
+	}
+	return blocks
+}
+
+func TestSyncManager(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	blocks := chainBlocks(newTestChain(t, address, 6))
+	bc, err := NewBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AcceptBlock(blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(bc, "test", "")
+	sm, p := n.sync, &Peer{node: n, addr: "peer"}
+
+	wrongHeight := *blocks[1]
+	wrongHeight.Height = 7
+	if err := sm.handleHeaders(p, []*Block{&wrongHeight}); err == nil {
+		t.Error("header at a wrong height is accepted")
+	}
+	// headers not connecting to the tip are ignored
+	if err := sm.handleHeaders(p, blocks[2:4]); err != nil || len(sm.headers) != 0 {
+		t.Errorf("got %d headers: %v", len(sm.headers), err)
+	}
+	if err := sm.handleHeaders(p, blocks[1:]); err != nil || len(sm.headers) != 5 {
+		t.Fatalf("got %d headers: %v", len(sm.headers), err)
+	}
+	if p.BestHeight() != 5 {
+		t.Errorf("got peer height %d, want 5", p.BestHeight())
+	}
+
+	// blocks arriving before their parents wait for them
+	for _, i := range []int{3, 2, 1, 5, 4} {
+		if ok, err := sm.handleBlock(p, blocks[i]); !ok || err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		want := map[int]uint64{3: 0, 2: 0, 1: 3, 5: 3, 4: 5}[i]
+		if got := bc.GetBestHeight(); got != want {
+			t.Errorf("got height %d after block %d, want %d", got, i, want)
+		}
+	}
+	if sm.IsSyncing() {
+		t.Error("sync manager is syncing after all blocks are connected")
+	}
+	if ok, _ := sm.handleBlock(p, blocks[5]); ok {
+		t.Error("block not requested is handled")
+	}
+}
+
+func TestSyncBadBlocks(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	blocks := chainBlocks(newTestChain(t, address, 2))
+	bc, err := NewBlockChain(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AcceptBlock(blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(bc, "test", "")
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	sm, p := n.sync, &Peer{node: n, conn: conn, addr: "peer"}
+
+	// a block under the hash of block 1 with other transactions, and a block whose
+	// header is valid but which claims more than the reward
+	bogus := *blocks[1]
+	bogus.Transactions = []*Transaction{NewMiningTx(address, "bogus", 1)}
+	coinbase := NewMiningTx(address, "greedy", 2)
+	coinbase.TxOutputs[0].Value++
+	coinbase.SetHash()
+	greedy := newBlockAt([]*Transaction{coinbase}, blocks[1].Hash, 2, blocks[1].TimeStamp+1)
+	if err := sm.handleHeaders(p, []*Block{blocks[1], greedy}); err != nil || len(sm.headers) != 2 {
+		t.Fatalf("got %d headers: %v", len(sm.headers), err)
+	}
+
+	if ok, err := sm.handleBlock(p, &bogus); !ok || err == nil {
+		t.Error("block not matching its header is accepted")
+	}
+	if len(sm.headers) != 2 || len(sm.downloaded) != 0 || sm.inFlight[string(bogus.Hash)] != nil {
+		t.Errorf("got %d headers and %d blocks downloaded after a bogus block, want 2 and 0", len(sm.headers), len(sm.downloaded))
+	}
+	if ok, err := sm.handleBlock(p, blocks[1]); !ok || err != nil || bc.GetBestHeight() != 1 {
+		t.Fatalf("got height %d after block 1: %v", bc.GetBestHeight(), err)
+	}
+	// the invalid block drops the header chain and its sender
+	if ok, err := sm.handleBlock(p, greedy); !ok || err != nil {
+		t.Fatalf("invalid block: %v", err)
+	}
+	if bc.GetBestHeight() != 1 || sm.IsSyncing() {
+		t.Errorf("got height %d, syncing: %v", bc.GetBestHeight(), sm.IsSyncing())
+	}
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := remote.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("peer sending the invalid block is connected: %v", err)
+	}
+}
+
+func TestSyncFork(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, 4)
+	blocks := chainBlocks(bc)
+	n := NewNode(bc, "test", "")
+	sm := n.sync
+	// branch builds a branch of count blocks on prev
+	branch := func(name string, prev *Block, count int) []*Block {
+		var branch []*Block
+		for i := 0; i < count; i++ {
+			prev = newBlockAt([]*Transaction{NewMiningTx(address, name, prev.Height+1)}, prev.Hash, prev.Height+1, blocks[3].TimeStamp+prev.Height)
+			branch = append(branch, prev)
+		}
+		return branch
+	}
+	fork := branch("fork", blocks[1], 3)
+
+	// a branch not higher than the chain is ignored
+	if err := sm.handleHeaders(&Peer{node: n, addr: "lower"}, fork[:2]); err != nil || len(sm.headers) != 0 {
+		t.Errorf("got %d headers of a lower branch: %v", len(sm.headers), err)
+	}
+
+	// the chain is restored if the higher branch is invalid, and its sender is dropped,
+	// the branch shares blocks with fork and the last one claims more than the reward
+	coinbase := NewMiningTx(address, "greedy", 4)
+	coinbase.TxOutputs[0].Value++
+	coinbase.SetHash()
+	greedy := append(fork[:2:2], newBlockAt([]*Transaction{coinbase}, fork[1].Hash, 4, fork[1].TimeStamp+1))
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	p := &Peer{node: n, conn: conn, addr: "greedy"}
+	if err := sm.handleHeaders(p, greedy); err != nil || len(sm.headers) != 3 {
+		t.Fatalf("got %d headers: %v", len(sm.headers), err)
+	}
+	for _, block := range greedy {
+		if ok, err := sm.handleBlock(p, block); !ok || err != nil {
+			t.Fatalf("block at height %d: %v", block.Height, err)
+		}
+	}
+	if tail := bc.GetTail(); string(tail) != string(blocks[3].Hash) || sm.IsSyncing() {
+		t.Errorf("got tail %x, want %x, syncing: %v", tail, blocks[3].Hash, sm.IsSyncing())
+	}
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := remote.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("peer sending the invalid branch is connected: %v", err)
+	}
+
+	// the chain switches to the higher branch once its blocks make it higher
+	p = &Peer{node: n, addr: "fork"}
+	if err := sm.handleHeaders(p, fork); err != nil || len(sm.headers) != 3 {
+		t.Fatalf("got %d headers: %v", len(sm.headers), err)
+	}
+	for _, block := range fork[:2] {
+		if ok, err := sm.handleBlock(p, block); !ok || err != nil {
+			t.Fatalf("block at height %d: %v", block.Height, err)
+		}
+	}
+	if tail := bc.GetTail(); string(tail) != string(blocks[3].Hash) {
+		t.Errorf("chain switched to a branch which isn't higher yet, tail %x", tail)
+	}
+	if ok, err := sm.handleBlock(p, fork[2]); !ok || err != nil {
+		t.Fatalf("block at height 4: %v", err)
+	}
+	if tail := bc.GetTail(); string(tail) != string(fork[2].Hash) || sm.IsSyncing() {
+		t.Errorf("got tail %x, want %x, syncing: %v", tail, fork[2].Hash, sm.IsSyncing())
+	}
+	if bc.HasBlock(blocks[2].Hash) || bc.HasBlock(blocks[3].Hash) {
+		t.Error("blocks out of the chain are kept after the switch")
+	}
+}
@@ -0,0 +1,132 @@
+// time rules of blocks and transactions: a block must be later than the median time
+// past (MTP) of the blocks before it and not too far in the future, a transaction can
+// be locked until a height or time by LockTime, and an input until the output it spends
+// is old enough by Sequence (BIP68), both are compared with MTP instead of block time
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// BlockVersion of new blocks, blocks of it must be later than median time past,
+	// version 0 blocks were mined without the rule and can't follow a version 1 block
+	BlockVersion = 1
+
+	medianTimeBlocks   = 11          // number of blocks median time past is taken from
+	maxFutureBlockTime = 2 * 60 * 60 // seconds a block may be ahead of the clock
+
+	// LockTime below it is a height, otherwise a unix time
+	LockTimeThreshold = 500000000
+
+	// an input with SequenceDisableFlag or a zero value in SequenceLockMask is not
+	// locked, otherwise the value is blocks, or units of 512 seconds with SequenceTypeFlag
+	SequenceDisableFlag     uint32 = 1 << 31
+	SequenceTypeFlag        uint32 = 1 << 22
+	SequenceLockMask        uint32 = 0xffff
+	sequenceTimeGranularity        = 9
+)
+
+// medianTimePast returns the median timestamp of the block with hash and up to 10
+// blocks before it, 0 if there is no such block
+func (bc *BlockChain) medianTimePast(hash []byte) uint64 {
+	times := make([]uint64, 0, medianTimeBlocks)
+	iter := &Iterator{store: bc.store, currentHash: hash}
+	for len(times) < medianTimeBlocks {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		times = append(times, block.TimeStamp)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// MedianTimePast returns median time past of the tail, a new block must be later than it
+func (bc *BlockChain) MedianTimePast() uint64 {
+	return bc.medianTimePast(bc.tail)
+}
+
+// checkBlockTime validates version and timestamp of block to be connected to the tail
+func (bc *BlockChain) checkBlockTime(block *Block) error {
+	if tail := bc.NewIterator().Next(); tail != nil && block.Version < tail.Version {
+		return fmt.Errorf("block version %d is lower than %d of previous block", block.Version, tail.Version)
+	}
+	if block.TimeStamp > uint64(GetTime()+maxFutureBlockTime) {
+		return fmt.Errorf("block time %d is too far in the future", block.TimeStamp)
+	}
+	if medianTime := bc.MedianTimePast(); block.Version >= BlockVersion && block.TimeStamp <= medianTime {
+		return fmt.Errorf("block time %d is not later than median time past %d", block.TimeStamp, medianTime)
+	}
+	return nil
+}
+
+// IsFinal tells if tx can be in a block at height after blocks of median time past
+// medianTime, LockTime is the last height or time tx is locked at, 0 means unlocked
+func (tx *Transaction) IsFinal(height, medianTime uint64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return uint64(tx.LockTime) < height
+	}
+	return uint64(tx.LockTime) < medianTime
+}
+
+// checkTxLocks checks that LockTime of tx and Sequence of its inputs allow it in a
+// block at height on the tail, outputs it spends must be in the utxo index
+func (bc *BlockChain) checkTxLocks(tx *Transaction, height uint64) error {
+	if tx.IsMiningTx() {
+		return nil
+	}
+	medianTime := bc.MedianTimePast()
+	if !tx.IsFinal(height, medianTime) {
+		return fmt.Errorf("transaction %x is locked until after %s", tx.Id, lockTimeString(tx.LockTime))
+	}
+	for _, input := range tx.TxInputs {
+		lock := uint64(input.Sequence & SequenceLockMask)
+		if input.Sequence&SequenceDisableFlag != 0 || lock == 0 {
+			continue
+		}
+		entry, err := bc.GetUtxo(input.TxId, input.Index)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return fmt.Errorf("output %x:%d is spent or doesn't exist", input.TxId, input.Index)
+		}
+		if input.Sequence&SequenceTypeFlag == 0 {
+			if height < entry.Height+lock {
+				return fmt.Errorf("input %x:%d is locked until height %d", input.TxId, input.Index, entry.Height+lock)
+			}
+			continue
+		}
+		// time is counted from median time past of the block before the output
+		prevHeight := entry.Height
+		if prevHeight > 0 {
+			prevHeight--
+		}
+		prev, err := bc.GetBlockByHeight(prevHeight)
+		if err != nil {
+			return err
+		}
+		unlockTime := bc.medianTimePast(prev.Hash) + lock<<sequenceTimeGranularity
+		if medianTime < unlockTime {
+			return fmt.Errorf("input %x:%d is locked until median time past %d", input.TxId, input.Index, unlockTime)
+		}
+	}
+	return nil
+}
+
+// lockTimeString tells if lockTime is a height or a unix time
+func lockTimeString(lockTime uint32) string {
+	if lockTime < LockTimeThreshold {
+		return fmt.Sprintf("height %d", lockTime)
+	}
+	return fmt.Sprintf("median time past %d", lockTime)
+}
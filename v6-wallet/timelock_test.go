@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestBlockTime(t *testing.T) {
+	const address = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, address, 20)
+	// blocks mined in the same second move ahead of the clock to stay after median time past
+	medianTime := bc.MedianTimePast()
+	if tail := bc.NewIterator().Next(); tail.TimeStamp <= bc.medianTimePast(tail.PrevHash) {
+		t.Errorf("tail time %d is not later than median time past %d", tail.TimeStamp, medianTime)
+	}
+
+	tests := []struct {
+		name      string
+		version   uint64
+		timeStamp uint64
+	}{
+		{"median time past", BlockVersion, medianTime},
+		{"future", BlockVersion, uint64(GetTime()) + maxFutureBlockTime + 60},
+		{"lower version", 0, medianTime + 1},
+	}
+	for _, test := range tests {
+		height := bc.GetBestHeight() + 1
+		block := &Block{Version: test.version, PrevHash: bc.GetTail(), TimeStamp: test.timeStamp, Height: height,
+			Transactions: []*Transaction{NewMiningTx(address, test.name, height)}}
+		block.HashTransactionsMerkleRoot()
+		NewProofOfWork(block).Run()
+		if err := bc.AcceptBlock(block); err == nil {
+			t.Errorf("%s: block is accepted", test.name)
+		}
+	}
+}
+
+func TestTxLocks(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer SetMockTime(0)
+	SetMockTime(1700000000)
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	from := wallet.GetAddress()
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: wallet}, Meta: map[string]*AddressMeta{}}
+	// the only output of from is the genesis reward at height 0, then the change of the
+	// transaction of the previous test
+	bc := newTestChain(t, from, 1)
+	mine := func(txs ...*Transaction) error {
+		height := bc.GetBestHeight() + 1
+		_, err := bc.AddBlock(append([]*Transaction{NewMiningTx(miner, "test", height)}, txs...))
+		return err
+	}
+	mineAt := func(timeStamp int64, count int) func() {
+		return func() {
+			SetMockTime(timeStamp)
+			for i := 0; i < count; i++ {
+				mine()
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		unlock   func() // makes tx final after the first try fails
+	}{
+		{"height lock time", 3, 0, mineAt(1700000000, 3)},                       // mined at 4
+		{"sequence blocks", 0, 6, mineAt(1700000000, 4)},                        // mined at 10
+		{"disabled sequence", 0, SequenceDisableFlag | 100, nil},                // mined at 12
+		{"time lock time", 1700001000, 0, mineAt(1700002000, medianTimeBlocks)}, // mined at 26
+		{"sequence time", 0, SequenceTypeFlag | 4, mineAt(1700005000, medianTimeBlocks)},
+	}
+	for _, test := range tests {
+		tx, err := NewTransaction(from, miner, 1, 0, test.lockTime, test.sequence, bc, wm)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.unlock != nil {
+			if err := mine(tx); err == nil {
+				t.Fatalf("%s: locked transaction is mined at height %d", test.name, bc.GetBestHeight())
+			}
+			test.unlock()
+		}
+		if err := mine(tx); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		// spend the change of tx in the next test
+		if err := mine(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
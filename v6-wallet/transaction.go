@@ -7,15 +7,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"math"
 	"math/big"
-	"time"
-)
-
-const (
-	Reward int64 = 17
 )
 
 // 1. 交易id
@@ -29,11 +25,13 @@ const (
 //  2. 转账金额
 //
 // 4. 时间戳
+// 5. 锁定时间，在此高度或时间之前交易不能上链
 type Transaction struct {
 	Id        []byte
 	TxInputs  []TxInput
 	TxOutputs []TxOutput
 	TimeStamp int64
+	LockTime  uint32 // last height, or unix time from LockTimeThreshold, tx is locked at
 }
 
 type TxInput struct {
@@ -42,50 +40,230 @@ type TxInput struct {
 
 	ScriptSig []byte
 	PubKey    []byte
+	Sequence  uint32 // relative lock from the block of the spent output, see SequenceLockMask
+	Secret    []byte // preimage of the hash lock when redeeming an HTLC output
+}
+
+// OutputType tells how the receiver's public key hash is presented as an address
+type OutputType byte
+
+const (
+	OutputPubKeyHash        OutputType = iota // P2PKH, Base58Check address
+	OutputWitnessPubKeyHash                   // P2WPKH, bech32 address with witness version 0
+	OutputNullData                            // OP_RETURN, ScriptPubKeyHash holds data, can't be spent
+	OutputHTLC                                // hash time-locked contract, ScriptPubKeyHash holds HTLC.Bytes()
+)
+
+// MaxNullDataSize limits data of a null data output
+const MaxNullDataSize = 80
+
+func (t OutputType) String() string {
+	switch t {
+	case OutputPubKeyHash:
+		return "pubkeyhash"
+	case OutputWitnessPubKeyHash:
+		return "witness_v0_keyhash"
+	case OutputNullData:
+		return "nulldata"
+	case OutputHTLC:
+		return "htlc"
+	}
+	return "unknown"
 }
 
 type TxOutput struct {
 	ScriptPubKeyHash []byte // receiver's public key hash
 	Value            int64
+	Type             OutputType
+}
+
+// Address returns the receiver's address in the active network, empty for null data
+// and contracts
+func (o *TxOutput) Address() string {
+	if o.Type == OutputNullData || o.Type == OutputHTLC {
+		return ""
+	}
+	return EncodeAddress(o.Type, o.ScriptPubKeyHash)
+}
+
+// Script describes outputs without an address, empty for the others
+func (o *TxOutput) Script() string {
+	switch o.Type {
+	case OutputNullData:
+		return "OP_RETURN " + hex.EncodeToString(o.ScriptPubKeyHash)
+	case OutputHTLC:
+		c, err := ParseHTLC(o.ScriptPubKeyHash)
+		if err != nil {
+			return "HTLC " + hex.EncodeToString(o.ScriptPubKeyHash)
+		}
+		return fmt.Sprintf("HTLC %x to %s, refund to %s after %s", c.SecretHash,
+			EncodeAddress(OutputPubKeyHash, c.Recipient), EncodeAddress(OutputPubKeyHash, c.Refund), lockTimeString(c.LockTime))
+	}
+	return ""
+}
+
+// PaysTo tells if the output is locked to pubKeyHash alone, null data and contracts never are
+func (o *TxOutput) PaysTo(pubKeyHash []byte) bool {
+	return (o.Type == OutputPubKeyHash || o.Type == OutputWitnessPubKeyHash) && bytes.Equal(o.ScriptPubKeyHash, pubKeyHash)
+}
+
+// Check validates fields that don't depend on the chain: a transaction spends something
+// once, output values don't overflow, null data is small, holds no value and appears at
+// most once, and contracts are valid
+func (tx *Transaction) Check() error {
+	if len(tx.TxInputs) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	spent := make(map[string]bool)
+	for _, input := range tx.TxInputs {
+		key := string(input.TxId) + string(UintToByte(uint64(input.Index)))
+		if spent[key] {
+			return fmt.Errorf("output %x:%d is spent twice", input.TxId, input.Index)
+		}
+		spent[key] = true
+	}
+	nullData := 0
+	var total int64
+	for _, output := range tx.TxOutputs {
+		if output.Value < 0 {
+			return errors.New("output value is negative")
+		}
+		if total += output.Value; total < 0 {
+			return errors.New("total output value overflows")
+		}
+		if output.Type == OutputHTLC {
+			if _, err := ParseHTLC(output.ScriptPubKeyHash); err != nil {
+				return err
+			}
+		}
+		if output.Type != OutputNullData {
+			continue
+		}
+		if nullData++; nullData > 1 {
+			return errors.New("more than one null data output")
+		}
+		if output.Value != 0 {
+			return errors.New("null data output holds value")
+		}
+		if len(output.ScriptPubKeyHash) > MaxNullDataSize {
+			return fmt.Errorf("null data is more than %d bytes", MaxNullDataSize)
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
 }
 
 func (t *Transaction) SetHash() {
 	t.Id = nil // if don't set it, multiple calls will get different results
 
+	hashBytes := sha256.Sum256(t.hashData())
+
+	t.Id = hashBytes[:]
+}
+
+// hashData serializes every field but Id in a fixed layout, gob isn't used here
+// as the type ids it writes depend on the order types are first encoded in a
+// process, so the same tx would get different ids on different nodes. Ids of
+// chains made with gob don't match this layout, such chains have to be mined again
+func (t *Transaction) hashData() []byte {
 	var buf bytes.Buffer
-	e := gob.NewEncoder(&buf)
+	writeBytes := func(b []byte) {
+		buf.Write(UintToByte(uint64(len(b))))
+		buf.Write(b)
+	}
 
-	err := e.Encode(&t)
-	if err != nil {
-		fmt.Println("encode transaction fail: ", err)
-		panic(err)
+	buf.Write(UintToByte(uint64(len(t.TxInputs))))
+	for _, input := range t.TxInputs {
+		writeBytes(input.TxId)
+		buf.Write(UintToByte(uint64(input.Index)))
+		writeBytes(input.ScriptSig)
+		writeBytes(input.PubKey)
+	}
+	buf.Write(UintToByte(uint64(len(t.TxOutputs))))
+	for _, output := range t.TxOutputs {
+		writeBytes(output.ScriptPubKeyHash)
+		buf.Write(UintToByte(uint64(output.Value)))
+		buf.WriteByte(byte(output.Type))
+	}
+	buf.Write(UintToByte(uint64(t.TimeStamp)))
+	// locks and secrets are written only if set, so ids of transactions made before
+	// them don't change
+	locked := t.LockTime != 0
+	for _, input := range t.TxInputs {
+		locked = locked || input.Sequence != 0
+	}
+	if locked {
+		buf.Write(UintToByte(uint64(t.LockTime)))
+		for _, input := range t.TxInputs {
+			buf.Write(UintToByte(uint64(input.Sequence)))
+		}
+	}
+	hasSecret := false
+	for _, input := range t.TxInputs {
+		hasSecret = hasSecret || input.Secret != nil
 	}
-	hashBytes := sha256.Sum256(buf.Bytes())
+	if hasSecret {
+		for _, input := range t.TxInputs {
+			writeBytes(input.Secret)
+		}
+	}
+	return buf.Bytes()
+}
 
-	t.Id = hashBytes[:]
+// CalcId computes the id that tx should have, transactions are signed after
+// their id is set, so signatures are excluded except for the mining transaction
+func (t *Transaction) CalcId() []byte {
+	txCopy := *t
+	if !t.IsMiningTx() {
+		txCopy.TxInputs = make([]TxInput, len(t.TxInputs))
+		for i, input := range t.TxInputs {
+			input.ScriptSig = nil
+			txCopy.TxInputs[i] = input
+		}
+	}
+	txCopy.SetHash()
+	return txCopy.Id
 }
 
 func NewMiningTx(
 	address string, // miner's public key
 	data string, // mining reward have no input, write data to sig
+	height uint64, // height of the block, decides the reward
 ) *Transaction {
-	log.Println("Start creating new mining transaction")
-	minerPubKeyHash, err := GetPubKeyHashFromAddress(address)
+	logDebug("Start creating new mining transaction")
+	minerOutputType, minerPubKeyHash, err := DecodeAddress(address)
 	if err != nil {
 		panic("invalid address")
 	}
 
-	txInput := TxInput{nil, 0, []byte(data), nil}
-	txOutput := TxOutput{minerPubKeyHash, Reward}
+	// mining input refers to no output, it's Index stores the height to make
+	// transaction id unique, otherwise same miner and data produce same id (BIP34)
+	txInput := TxInput{nil, int64(height), []byte(data), nil, 0, nil}
+	txOutput := TxOutput{minerPubKeyHash, activeNetwork.BlockReward(height), minerOutputType}
 
 	tx := &Transaction{
 		TxInputs:  []TxInput{txInput},
 		TxOutputs: []TxOutput{txOutput},
-		TimeStamp: time.Now().Unix(),
+		TimeStamp: GetTime(),
 	}
 	tx.SetHash()
 
-	log.Println("New mining transaction")
+	logDebug("New mining transaction")
 	return tx
 }
 
@@ -93,8 +271,32 @@ func NewTransaction(
 	from string, // sender's address
 	to string, // receiver's address
 	amount int64, // transfer amount
+	fee int64, // left to the miner, inputs minus outputs
+	lockTime uint32, // see Transaction.LockTime, 0 means unlocked
+	sequence uint32, // Sequence of every input, 0 means unlocked
 	bc *BlockChain,
+	wm *WalletManager, // holds the key of from
 ) (*Transaction, error) {
+	toOutputType, toPubKeyHash, err := DecodeAddress(to)
+	if err != nil {
+		return nil, errors.New("invalid address")
+	}
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	return newWalletTx(from, TxOutput{toPubKeyHash, amount, toOutputType}, fee, lockTime, sequence, bc, wm)
+}
+
+// NewDataTransaction commits data in a null data output, from pays fee and gets the change
+func NewDataTransaction(from string, data []byte, fee int64, bc *BlockChain, wm *WalletManager) (*Transaction, error) {
+	if len(data) == 0 || len(data) > MaxNullDataSize {
+		return nil, fmt.Errorf("data must be 1 to %d bytes", MaxNullDataSize)
+	}
+	return newWalletTx(from, TxOutput{data, 0, OutputNullData}, fee, 0, 0, bc, wm)
+}
+
+// newWalletTx spends outputs of from in wallet to output, see NewTransaction for the rest
+func newWalletTx(from string, output TxOutput, fee int64, lockTime, sequence uint32, bc *BlockChain, wm *WalletManager) (*Transaction, error) {
 	// 1. 遍历账本，找到关于from的utxo集合，返回总金额
 	// 2. 金额不足，创建失败
 	// 3. 拼接 inputs
@@ -103,26 +305,26 @@ func NewTransaction(
 	//     创建一个属于to的output
 	//     如果总金额大于转账金额，给from创建找零output
 	// 5. 设置hash
-	wm := NewWalletManager()
-	if wm == nil {
-		return nil, errors.New("can't get wallet manager")
-	}
-	wallet, ok := wm.Wallets[from]
-	if !ok {
+	wallet := wm.GetWallet(from)
+	if wallet == nil {
 		return nil, errors.New("can't find sender's wallet")
 	}
 
-	fromPubKeyHash, err := GetPubKeyHashFromAddress(from)
+	fromOutputType, fromPubKeyHash, err := DecodeAddress(from)
 	if err != nil {
 		return nil, errors.New("invalid address")
 	}
-	toPubKeyHash, err := GetPubKeyHashFromAddress(to)
-	if err != nil {
-		return nil, errors.New("invalid address")
+	if fee < 0 {
+		return nil, errors.New("fee must not be negative")
 	}
-	total, utxoInfos := bc.FindNeededUtxo(fromPubKeyHash, amount)
-	if total < amount {
-		log.Printf("Transfer %s to %s: not enough money\n", from, to)
+	amount := output.Value
+	if amount < 0 || fee > math.MaxInt64-amount {
+		return nil, errors.New("amount and fee are out of range")
+	}
+	total, utxoInfos := bc.FindNeededUtxo(fromPubKeyHash, amount+fee)
+	// a transaction spends at least one output, even if it sends nothing
+	if total < amount+fee || len(utxoInfos) == 0 {
+		logInfo("Spend %d from %s: not enough money", amount+fee, from)
 		return nil, errors.New("not enough money")
 	}
 	inputs := make([]TxInput, 0)
@@ -130,45 +332,53 @@ func NewTransaction(
 
 	for txId, indexes := range utxoInfos {
 		for _, index := range indexes {
-			input := TxInput{[]byte(txId), index, nil, wallet.PubKey}
+			input := TxInput{[]byte(txId), index, nil, wallet.PubKey, sequence, nil}
 			inputs = append(inputs, input)
 		}
 	}
 
-	outputs = append(outputs, TxOutput{toPubKeyHash, amount})
-	if total > amount {
-		outputs = append(outputs, TxOutput{fromPubKeyHash, (total - amount)})
+	outputs = append(outputs, output)
+	if total > amount+fee {
+		outputs = append(outputs, TxOutput{fromPubKeyHash, (total - amount - fee), fromOutputType})
 	}
 
 	tx := &Transaction{
 		TxInputs:  inputs,
 		TxOutputs: outputs,
-		TimeStamp: time.Now().Unix(),
+		TimeStamp: GetTime(),
+		LockTime:  lockTime,
 	}
 
 	tx.SetHash()
 
-	// i, i2 := elliptic.P256().ScalarBaseMult(wallet.PriKey)
-	priKey := ecdsa.PrivateKey{
-		PublicKey: ecdsa.PublicKey{
-			Curve: elliptic.P256(),
-			X:     new(big.Int).SetBytes(wallet.PubKey[:len(wallet.PubKey)/2]),
-			Y:     new(big.Int).SetBytes(wallet.PubKey[len(wallet.PubKey)/2:]),
-		},
-		D: new(big.Int).SetBytes(wallet.PriKey),
-	}
-	log.Printf("Created private key:\n\t%X\n\t%#X\n\t%#X", priKey.D.Bytes(), priKey.PublicKey.X.Bytes(), priKey.PublicKey.Y.Bytes())
-	if !bc.SignTransaction(tx, &priKey) {
-		log.Println("sign transaction failed")
+	priKey := wallet.PrivateKey()
+	logDebug("Created private key:\n\t%X\n\t%#X\n\t%#X", priKey.D.Bytes(), priKey.PublicKey.X.Bytes(), priKey.PublicKey.Y.Bytes())
+	if !bc.SignTransaction(tx, priKey) {
+		logWarn("sign transaction failed")
 		return nil, errors.New("sign transaction failed")
 	}
 
-	log.Println("Create new transaction")
+	logDebug("Create new transaction")
 	return tx, nil
 }
 
 func (tx *Transaction) IsMiningTx() bool {
-	return len(tx.TxInputs) == 1 && len(tx.TxInputs[0].TxId) == 0 && tx.TxInputs[0].Index == 0
+	return len(tx.TxInputs) == 1 && len(tx.TxInputs[0].TxId) == 0
+}
+
+// ConflictsWith tells if both transactions spend a same output
+func (tx *Transaction) ConflictsWith(other *Transaction) bool {
+	if tx.IsMiningTx() || other.IsMiningTx() {
+		return false
+	}
+	for _, input := range tx.TxInputs {
+		for _, otherInput := range other.TxInputs {
+			if bytes.Equal(input.TxId, otherInput.TxId) && input.Index == otherInput.Index {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // copy transaction, remove signature and public key
@@ -177,10 +387,10 @@ func (tx *Transaction) TrimmedCopy() *Transaction {
 	outputs := make([]TxOutput, 0)
 
 	for _, input := range tx.TxInputs {
-		inputs = append(inputs, TxInput{input.TxId, input.Index, nil, nil})
+		inputs = append(inputs, TxInput{input.TxId, input.Index, nil, nil, input.Sequence, input.Secret})
 	}
 	for _, output := range tx.TxOutputs {
-		outputs = append(outputs, TxOutput{output.ScriptPubKeyHash, output.Value})
+		outputs = append(outputs, TxOutput{output.ScriptPubKeyHash, output.Value, output.Type})
 	}
 
 	return &Transaction{
@@ -188,59 +398,85 @@ func (tx *Transaction) TrimmedCopy() *Transaction {
 		TxInputs:  inputs,
 		TxOutputs: outputs,
 		TimeStamp: tx.TimeStamp,
+		LockTime:  tx.LockTime,
 	}
 }
 
-func (tx *Transaction) Sign(priKey *ecdsa.PrivateKey, referencedTxs map[string]*Transaction) bool {
+// Sign signs every input, prevOutputs holds the outputs spent by tx keyed by outPointKey
+func (tx *Transaction) Sign(priKey *ecdsa.PrivateKey, prevOutputs map[string]TxOutput) bool {
 	if tx.IsMiningTx() {
 		return true
 	}
-	log.Println("Start Transaction.Sign()")
+	logDebug("Start Transaction.Sign()")
 	txCopy := tx.TrimmedCopy()
 	for i, input := range txCopy.TxInputs {
-		refedTx := referencedTxs[string(input.TxId)]
-		if refedTx == nil {
+		refedOutput, ok := prevOutputs[outPointKey(input.TxId, input.Index)]
+		if !ok {
 			return false
 		}
 
-		refedOutput := refedTx.TxOutputs[input.Index]
 		txCopy.TxInputs[i].PubKey = refedOutput.ScriptPubKeyHash
 		txCopy.SetHash()
 		txCopy.TxInputs[i].PubKey = nil
 		hashData := txCopy.Id
-		log.Printf("In Sign() hashData: %X\n", hashData)
+		logDebug("In Sign() hashData: %X", hashData)
 
 		r, s, err := ecdsa.Sign(rand.Reader, priKey, hashData)
 		if err != nil {
 			return false
 		}
-		tx.TxInputs[i].ScriptSig = append(r.Bytes(), s.Bytes()...)
-		log.Printf("In Sign() signature: [%X]\n", tx.TxInputs[i].ScriptSig)
-		log.Printf("In Sign() pubKey: %X\n", priKey.PublicKey)
+		// r and s in 32 bytes each, Verify splits the signature in halves
+		tx.TxInputs[i].ScriptSig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		logDebug("In Sign() signature: [%X]", tx.TxInputs[i].ScriptSig)
+		logDebug("In Sign() pubKey: %X", priKey.PublicKey)
 	}
 	return true
 }
 
-func (tx *Transaction) Verify(refedTxs map[string]*Transaction) bool {
-	log.Println("Start Transaction.Verify()")
+// spendKeyHash returns the public key hash input i must present to spend output
+func (tx *Transaction) spendKeyHash(i int, output TxOutput) ([]byte, error) {
+	switch output.Type {
+	case OutputPubKeyHash, OutputWitnessPubKeyHash:
+		return output.ScriptPubKeyHash, nil
+	case OutputHTLC:
+		return tx.htlcKeyHash(tx.TxInputs[i], output)
+	}
+	return nil, fmt.Errorf("output of type %s can't be spent", output.Type)
+}
+
+func (tx *Transaction) Verify(prevOutputs map[string]TxOutput) bool {
+	logDebug("Start Transaction.Verify()")
 	// copy a transaction, remove signature and public key
 	txCopy := tx.TrimmedCopy()
 	// traverse all inputs
 	for i, input := range tx.TxInputs {
-		// get referenced transaction
-		refedTx := refedTxs[string(input.TxId)]
-		if refedTx == nil {
-			log.Printf("can't find referenced transaction: %X\n", input.TxId)
+		// get referenced output, get public key hash, restore pubKey field
+		refedOutput, ok := prevOutputs[outPointKey(input.TxId, input.Index)]
+		if !ok {
+			logWarn("can't find referenced output: %X:%d", input.TxId, input.Index)
+			return false
+		}
+		// transactions come from peers, check the key before slicing it and the signature
+		if len(input.PubKey) != 64 || len(input.ScriptSig) == 0 || len(input.ScriptSig)%2 != 0 {
+			logWarn("input %d has a malformed public key or signature", i)
+			return false
+		}
+		keyHash, err := tx.spendKeyHash(i, refedOutput)
+		if err != nil {
+			logWarn("can't spend output %X:%d: %v", input.TxId, input.Index, err)
+			return false
+		}
+		// the key must be the one the output pays to, or anyone could sign for it
+		if !bytes.Equal(GetPubKeyHashFromPubKey(input.PubKey), keyHash) {
+			logWarn("public key of input %d doesn't match output %X:%d", i, input.TxId, input.Index)
 			return false
 		}
-		// get referenced output, get public key hash, restore pubKey field
-		refedOutput := refedTx.TxOutputs[input.Index]
 		txCopy.TxInputs[i].PubKey = refedOutput.ScriptPubKeyHash
 		txCopy.SetHash()
 		txCopy.TxInputs[i].PubKey = nil
 		hashData := txCopy.Id
-		log.Printf("In Verify() hashData: %X\n", hashData)
-		log.Printf("In Verify() signature: [%X]\n", input.ScriptSig)
+		logDebug("In Verify() hashData: %X", hashData)
+		logDebug("In Verify() signature: [%X]", input.ScriptSig)
 
 		// verify signature
 		signature := input.ScriptSig
@@ -252,10 +488,10 @@ func (tx *Transaction) Verify(refedTxs map[string]*Transaction) bool {
 		y.SetBytes(input.PubKey[32:])
 
 		pubKeyNew := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
-		log.Printf("In Verify() pubKey: %X\n", pubKeyNew)
+		logDebug("In Verify() pubKey: %X", pubKeyNew)
 
 		if !ecdsa.Verify(&pubKeyNew, hashData, &r, &s) {
-			log.Println("verify signature failed: ecdsa.Verify() return false")
+			logWarn("verify signature failed: ecdsa.Verify() return false")
 			return false
 		}
 	}
@@ -273,12 +509,15 @@ func (t *TxInput) String() string {
 		return fmt.Sprintf(format, t.TxId, t.Index, t.PubKey, t.ScriptSig)
 	} else {
 		// coinbase
-		return fmt.Sprintf("<Coinbase Transaction>\nside message:%s", t.ScriptSig)
+		return fmt.Sprintf("<Coinbase Transaction>\nheight:%d\nside message:%s", t.Index, t.ScriptSig)
 	}
 }
 func (t *TxOutput) String() string {
-	format := `%d => %X`
-	return fmt.Sprintf(format, t.Value, t.ScriptPubKeyHash)
+	format := `%d => %s`
+	if script := t.Script(); script != "" {
+		return fmt.Sprintf(format, t.Value, script)
+	}
+	return fmt.Sprintf(format, t.Value, t.Address())
 }
 func (t *Transaction) String() string {
 	strInputs := ""
@@ -300,6 +539,8 @@ func (t *Transaction) String() string {
 %s[TxOutputs]
 %s[TimeStamp]
 %d
+[LockTime]
+%d
 `
-	return fmt.Sprintf(format, t.Id, strInputs, strOutputs, t.TimeStamp)
+	return fmt.Sprintf(format, t.Id, strInputs, strOutputs, t.TimeStamp, t.LockTime)
 }
@@ -0,0 +1,313 @@
+// undo data of a block is the outputs it spends, stored with the block so the tail can
+// be disconnected by putting them back into the utxo index
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// metadata key prefix of branches disconnected by InvalidateBlock
+const invalidKeyPrefix = "invalid"
+
+// UndoEntry is an output spent by a block
+type UndoEntry struct {
+	TxId  []byte
+	Index int64
+	Entry UtxoEntry
+}
+
+// BlockUndo holds outputs spent by a block in the order of its inputs
+type BlockUndo struct {
+	Spent []UndoEntry
+}
+
+func (u *BlockUndo) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(u); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DeserializeBlockUndo(data []byte) (*BlockUndo, error) {
+	var undo BlockUndo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+// blockUndo looks up outputs spent by block in the utxo index, which must have all
+// of them, an output spent twice in the block is an error
+func (bc *BlockChain) blockUndo(block *Block) (*BlockUndo, error) {
+	undo := &BlockUndo{Spent: make([]UndoEntry, 0)}
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if tx.IsMiningTx() {
+			continue
+		}
+		for _, input := range tx.TxInputs {
+			key := outPointKey(input.TxId, input.Index)
+			if spent[key] {
+				return nil, fmt.Errorf("output %x:%d is spent twice in block", input.TxId, input.Index)
+			}
+			spent[key] = true
+			entry, err := bc.GetUtxo(input.TxId, input.Index)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				return nil, fmt.Errorf("output %x:%d is spent or doesn't exist", input.TxId, input.Index)
+			}
+			undo.Spent = append(undo.Spent, UndoEntry{input.TxId, input.Index, *entry})
+		}
+	}
+	return undo, nil
+}
+
+// disconnectTip moves the tail to its previous block, removes outputs of the block
+// from the utxo index and puts back those it spent. The block is deleted unless keep
+// is set.
+func (bc *BlockChain) disconnectTip(keep bool) (*Block, error) {
+	blockBytes, err := bc.store.GetBlock(bc.tail)
+	if err != nil {
+		return nil, err
+	}
+	if blockBytes == nil {
+		return nil, errors.New("blockchain is empty")
+	}
+	block, err := Deserialize(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	if block.IsPruned() {
+		return nil, bc.prunedError(block)
+	}
+	if block.Height == 0 {
+		return nil, errors.New("genesis block can't be disconnected")
+	}
+	undoBytes, err := bc.store.GetUndo(block.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if undoBytes == nil {
+		return nil, fmt.Errorf("block %x at height %d has no undo data", block.Hash, block.Height)
+	}
+	undo, err := DeserializeBlockUndo(undoBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := bc.blockSize
+	err = bc.store.Update(func(batch StoreBatch) error {
+		for _, tx := range block.Transactions {
+			for i := range tx.TxOutputs {
+				if err := batch.DeleteUtxo([]byte(outPointKey(tx.Id, int64(i)))); err != nil {
+					return err
+				}
+			}
+		}
+		for _, spent := range undo.Spent {
+			data, err := spent.Entry.Serialize()
+			if err != nil {
+				return err
+			}
+			if err := batch.PutUtxo([]byte(outPointKey(spent.TxId, spent.Index)), data); err != nil {
+				return err
+			}
+		}
+		if !keep {
+			if err := batch.DeleteBlock(block.Hash); err != nil {
+				return err
+			}
+			if err := batch.DeleteUndo(block.Hash); err != nil {
+				return err
+			}
+			blockSize -= uint64(len(blockBytes))
+		}
+		if err := batch.PutMeta(blockSizeKey, UintToByte(blockSize)); err != nil {
+			return err
+		}
+		if err := batch.PutMeta(utxoTipKey, block.PrevHash); err != nil {
+			return err
+		}
+		return batch.SetTip(block.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bc.tail = block.PrevHash
+	bc.blockSize = blockSize
+	logInfo("Disconnected block %x at height %d", block.Hash, block.Height)
+	return block, nil
+}
+
+// disconnectTo disconnects blocks above height and returns them from the tail down
+func (bc *BlockChain) disconnectTo(height uint64, keep bool) ([]*Block, error) {
+	if bc.tail != nil && height+1 < bc.pruneHeight {
+		return nil, fmt.Errorf("blocks below height %d are pruned, can't disconnect them", bc.pruneHeight)
+	}
+	var blocks []*Block
+	for bc.tail != nil && bc.GetBestHeight() > height {
+		block, err := bc.disconnectTip(keep)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Rollback disconnects and deletes blocks above height, peers can send them again.
+// Returns the disconnected blocks from the tail down.
+func (bc *BlockChain) Rollback(height uint64) ([]*Block, error) {
+	if bc.tail == nil || height >= bc.GetBestHeight() {
+		return nil, fmt.Errorf("height %d is not below the best height", height)
+	}
+	return bc.disconnectTo(height, false)
+}
+
+// isActive tells if block is in the chain ending at the tail
+func (bc *BlockChain) isActive(block *Block) bool {
+	active, err := bc.GetBlockByHeight(block.Height)
+	return err == nil && bytes.Equal(active.Hash, block.Hash)
+}
+
+// InvalidateBlock disconnects the block with hash and blocks after it. They are kept
+// in the store, so peers can't bring them back, until ReconsiderBlock reconnects them.
+// Returns the disconnected blocks from the tail down.
+func (bc *BlockChain) InvalidateBlock(hash []byte) ([]*Block, error) {
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !bc.isActive(block) {
+		return nil, fmt.Errorf("block %x is not in the chain", hash)
+	}
+	if block.Height == 0 {
+		return nil, errors.New("genesis block can't be invalidated")
+	}
+	blocks, err := bc.disconnectTo(block.Height-1, true)
+	// remember what is disconnected, even if it's stopped by an error
+	if len(blocks) > 0 {
+		var branch []byte
+		for i := len(blocks) - 1; i >= 0; i-- {
+			branch = append(branch, blocks[i].Hash...)
+		}
+		key := invalidKeyPrefix + string(blocks[len(blocks)-1].Hash)
+		if err := bc.store.Update(func(batch StoreBatch) error { return batch.PutMeta(key, branch) }); err != nil {
+			return blocks, err
+		}
+	}
+	return blocks, err
+}
+
+// ReconsiderBlock reconnects the branch disconnected by InvalidateBlock(hash) if it's
+// higher than the chain, which is disconnected down to where the branch forks. The chain
+// is restored if the branch can't be connected. Returns blocks disconnected from the
+// tail down and blocks connected from old to new.
+func (bc *BlockChain) ReconsiderBlock(hash []byte) ([]*Block, []*Block, error) {
+	key := invalidKeyPrefix + string(hash)
+	branch, err := bc.store.GetMeta(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if branch == nil {
+		return nil, nil, fmt.Errorf("block %x is not invalidated", hash)
+	}
+	first, err := bc.GetBlock(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	fork, err := bc.GetBlockByHeight(first.Height - 1)
+	if err != nil || !bytes.Equal(fork.Hash, first.PrevHash) {
+		return nil, nil, fmt.Errorf("parent of block %x is not in the chain, reconsider the blocks invalidated before it", hash)
+	}
+	forget := func() error {
+		return bc.store.Update(func(batch StoreBatch) error { return batch.DeleteMeta(key) })
+	}
+	hashSize := len(hash)
+	if bc.GetBestHeight() >= fork.Height+uint64(len(branch)/hashSize) {
+		logInfo("Chain is kept, it's not lower than the branch of block %x", hash)
+		return nil, nil, forget()
+	}
+
+	var blocks []*Block
+	for i := 0; i < len(branch); i += hashSize {
+		block, err := bc.GetBlock(branch[i : i+hashSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	disconnected, connected, err := bc.switchBranch(fork.Height, blocks)
+	if err != nil {
+		return disconnected, connected, err
+	}
+	return disconnected, connected, forget()
+}
+
+// switchBranch disconnects the chain down to height and connects branch, the blocks
+// from old to new building on the block at height. The chain is restored if the branch
+// can't be connected. Returns blocks disconnected from the tail down and blocks connected.
+func (bc *BlockChain) switchBranch(height uint64, branch []*Block) ([]*Block, []*Block, error) {
+	// the chain is kept in the store until the branch is connected, so it can be
+	// restored if a block of the branch turns out invalid
+	disconnected, err := bc.disconnectTo(height, true)
+	if err != nil {
+		return bc.restoreChain(height, disconnected, nil, err)
+	}
+	var connected []*Block
+	for _, block := range branch {
+		if err := bc.connectBlock(block); err != nil {
+			return bc.restoreChain(height, disconnected, connected, fmt.Errorf("connect branch fail: %v", err))
+		}
+		connected = append(connected, block)
+	}
+	return disconnected, connected, bc.deleteBlocks(disconnected)
+}
+
+// restoreChain undoes a failed switch to a branch: it disconnects the blocks connected
+// above height and connects the disconnected ones, given from the tail down, again.
+// Returns the blocks changed if the chain can't be restored, with cause.
+func (bc *BlockChain) restoreChain(height uint64, disconnected, connected []*Block, cause error) ([]*Block, []*Block, error) {
+	if _, err := bc.disconnectTo(height, true); err != nil {
+		return disconnected, connected, fmt.Errorf("%v, restore chain fail: %v", cause, err)
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := bc.connectBlock(disconnected[i]); err != nil {
+			return disconnected[:i+1], nil, fmt.Errorf("%v, restore chain fail: %v", cause, err)
+		}
+	}
+	logInfo("Chain is restored to block %x", bc.tail)
+	return nil, nil, cause
+}
+
+// deleteBlocks removes blocks which are out of the chain and their undo data from the store
+func (bc *BlockChain) deleteBlocks(blocks []*Block) error {
+	blockSize := bc.blockSize
+	err := bc.store.Update(func(batch StoreBatch) error {
+		for _, block := range blocks {
+			blockBytes, err := block.Serialize()
+			if err != nil {
+				return err
+			}
+			if err := batch.DeleteBlock(block.Hash); err != nil {
+				return err
+			}
+			if err := batch.DeleteUndo(block.Hash); err != nil {
+				return err
+			}
+			blockSize -= uint64(len(blockBytes))
+		}
+		return batch.PutMeta(blockSizeKey, UintToByte(blockSize))
+	})
+	if err != nil {
+		return err
+	}
+	bc.blockSize = blockSize
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestDisconnectBlocks(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	wallet := NewWalletKeyPair()
+	from := wallet.GetAddress()
+	wm := &WalletManager{Wallets: map[string]*Wallet{from: wallet}, Meta: map[string]*AddressMeta{}}
+	bc := newTestChain(t, from, 3)
+	balance := func(address string) int64 {
+		pubKeyHash, _ := GetPubKeyHashFromAddress(address)
+		_, total := bc.FindUtxo(pubKeyHash)
+		return total
+	}
+	fromBalance := balance(from)
+
+	tx, err := NewTransaction(from, miner, 5, 1, 0, 0, bc, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "test", 3), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	minerBalance := balance(miner)
+
+	blocks, err := bc.Rollback(2)
+	if err != nil || len(blocks) != 1 || bc.GetBestHeight() != 2 {
+		t.Fatalf("got %d blocks disconnected to height %d: %v", len(blocks), bc.GetBestHeight(), err)
+	}
+	if balance(from) != fromBalance || balance(miner) != 0 {
+		t.Errorf("got balances %d and %d after rollback, want %d and 0", balance(from), balance(miner), fromBalance)
+	}
+	if bc.HasBlock(block.Hash) {
+		t.Error("block is kept after rollback")
+	}
+	if _, err := bc.Rollback(2); err == nil {
+		t.Error("rollback to the best height")
+	}
+
+	// spent outputs are back, so the transaction is valid again
+	if err := bc.AcceptBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := bc.InvalidateBlock(block.Hash); err != nil || len(blocks) != 1 {
+		t.Fatalf("got %d blocks invalidated: %v", len(blocks), err)
+	}
+	if err := bc.AcceptBlock(block); err != ErrBlockExists {
+		t.Errorf("got %v accepting invalidated block, want ErrBlockExists", err)
+	}
+	disconnected, connected, err := bc.ReconsiderBlock(block.Hash)
+	if err != nil || len(disconnected) != 0 || len(connected) != 1 {
+		t.Fatalf("got %d blocks disconnected and %d connected: %v", len(disconnected), len(connected), err)
+	}
+	if balance(miner) != minerBalance {
+		t.Errorf("got balance %d after reconsider, want %d", balance(miner), minerBalance)
+	}
+
+	genesis, _ := bc.GetBlockByHeight(0)
+	if _, err := bc.InvalidateBlock(genesis.Hash); err == nil {
+		t.Error("genesis block is invalidated")
+	}
+}
+
+func TestReconsiderBlockRestore(t *testing.T) {
+	const miner = "mu68xiAkBxM5DuStCqD43szfxVpvryyHpB"
+	bc := newTestChain(t, miner, 4)
+	blocks := chainBlocks(bc)
+	if _, err := bc.InvalidateBlock(blocks[2].Hash); err != nil {
+		t.Fatal(err)
+	}
+	competing, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "competing", 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the branch is higher, but its last block is invalid in the store
+	putBlock := func(block *Block) {
+		blockBytes, err := block.Serialize()
+		if err == nil {
+			err = bc.store.Update(func(batch StoreBatch) error { return batch.PutBlock(blocks[3].Hash, blockBytes) })
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := *blocks[3]
+	bad.Nonce++
+	putBlock(&bad)
+	disconnected, connected, err := bc.ReconsiderBlock(blocks[2].Hash)
+	if err == nil || len(disconnected) != 0 || len(connected) != 0 {
+		t.Fatalf("got %d blocks disconnected and %d connected: %v", len(disconnected), len(connected), err)
+	}
+	if tail := bc.GetTail(); string(tail) != string(competing.Hash) {
+		t.Errorf("got tail %x, want the competing block %x", tail, competing.Hash)
+	}
+
+	putBlock(blocks[3])
+	disconnected, connected, err = bc.ReconsiderBlock(blocks[2].Hash)
+	if err != nil || len(disconnected) != 1 || len(connected) != 2 {
+		t.Fatalf("got %d blocks disconnected and %d connected: %v", len(disconnected), len(connected), err)
+	}
+	if bc.HasBlock(competing.Hash) {
+		t.Error("block out of the chain is kept after reconsider")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 func UintToByte(num uint64) []byte {
@@ -23,3 +25,18 @@ func IsFileExist(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
 }
+
+// set by -mocktime or setmocktime on regtest, 0 means the clock is used
+var mockTime int64
+
+func SetMockTime(t int64) {
+	atomic.StoreInt64(&mockTime, t)
+}
+
+// GetTime returns unix time used for timestamps of new blocks and transactions
+func GetTime() int64 {
+	if t := atomic.LoadInt64(&mockTime); t != 0 {
+		return t
+	}
+	return time.Now().Unix()
+}
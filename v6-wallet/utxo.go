@@ -0,0 +1,194 @@
+// unspent outputs are indexed in the store by outpoint, so balances and input checks
+// don't walk the chain and keep working after old blocks are pruned
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+)
+
+// metadata key of the block the utxo index is built up to
+const utxoTipKey = "utxoTip"
+
+// UtxoEntry is an unspent output with where it was created
+type UtxoEntry struct {
+	Output   TxOutput
+	Height   uint64
+	Coinbase bool
+}
+
+func (e *UtxoEntry) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DeserializeUtxoEntry(data []byte) (*UtxoEntry, error) {
+	var entry UtxoEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetUtxo returns the unspent output, nil if it's spent or doesn't exist
+func (bc *BlockChain) GetUtxo(txid []byte, index int64) (*UtxoEntry, error) {
+	data, err := bc.store.GetUtxo([]byte(outPointKey(txid, index)))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return DeserializeUtxoEntry(data)
+}
+
+// updateUtxo spends the inputs of block and adds its outputs in batch
+func updateUtxo(batch StoreBatch, block *Block) error {
+	for _, tx := range block.Transactions {
+		if !tx.IsMiningTx() {
+			for _, input := range tx.TxInputs {
+				if err := batch.DeleteUtxo([]byte(outPointKey(input.TxId, input.Index))); err != nil {
+					return err
+				}
+			}
+		}
+		for i, output := range tx.TxOutputs {
+			// null data can't be spent, so it's never indexed
+			if output.Type == OutputNullData {
+				continue
+			}
+			entry := &UtxoEntry{output, block.Height, tx.IsMiningTx()}
+			data, err := entry.Serialize()
+			if err != nil {
+				return err
+			}
+			if err := batch.PutUtxo([]byte(outPointKey(tx.Id, int64(i))), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reindexUtxo builds the index and undo data of blocks from genesis, stores created
+// before the index have full blocks as none could be pruned
+func (bc *BlockChain) reindexUtxo() error {
+	var blocks []*Block
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		blocks = append(blocks, block)
+	}
+	// outpoints of a stale index are collected first, bolt can't read in an update
+	var stale [][]byte
+	err := bc.store.ForEachUtxo(func(outpoint, data []byte) error {
+		stale = append(stale, append([]byte{}, outpoint...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logInfo("Reindexing unspent outputs of %d blocks", len(blocks))
+	return bc.store.Update(func(batch StoreBatch) error {
+		for _, outpoint := range stale {
+			if err := batch.DeleteUtxo(outpoint); err != nil {
+				return err
+			}
+		}
+		// the batch can't be read, so outputs are tracked here for undo data
+		utxos := make(map[string]UtxoEntry)
+		var size int
+		for i := len(blocks) - 1; i >= 0; i-- {
+			block := blocks[i]
+			if block.IsPruned() {
+				return fmt.Errorf("block %x at height %d is pruned, can't reindex", block.Hash, block.Height)
+			}
+			undo := &BlockUndo{Spent: make([]UndoEntry, 0)}
+			for _, tx := range block.Transactions {
+				if !tx.IsMiningTx() {
+					for _, input := range tx.TxInputs {
+						key := outPointKey(input.TxId, input.Index)
+						undo.Spent = append(undo.Spent, UndoEntry{input.TxId, input.Index, utxos[key]})
+						delete(utxos, key)
+					}
+				}
+				for j, output := range tx.TxOutputs {
+					utxos[outPointKey(tx.Id, int64(j))] = UtxoEntry{output, block.Height, tx.IsMiningTx()}
+				}
+			}
+			undoBytes, err := undo.Serialize()
+			if err != nil {
+				return err
+			}
+			if err := batch.PutUndo(block.Hash, undoBytes); err != nil {
+				return err
+			}
+			if err := updateUtxo(batch, block); err != nil {
+				return err
+			}
+			blockBytes, err := block.Serialize()
+			if err != nil {
+				return err
+			}
+			size += len(blockBytes)
+		}
+		if err := batch.PutMeta(blockSizeKey, UintToByte(uint64(size))); err != nil {
+			return err
+		}
+		return batch.PutMeta(utxoTipKey, bc.tail)
+	})
+}
+
+type UTXOInfo struct {
+	TxId   []byte
+	Index  int64
+	Output TxOutput
+	Height uint64 // of the block holding the transaction
+}
+
+// FindUtxo returns unspent outputs to pubKeyHash and their total value
+func (bc *BlockChain) FindUtxo(pubKeyHash []byte) ([]UTXOInfo, int64) {
+	var utxos []UTXOInfo
+	var total int64 = 0
+
+	err := bc.store.ForEachUtxo(func(outpoint, data []byte) error {
+		entry, err := DeserializeUtxoEntry(data)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(entry.Output.ScriptPubKeyHash, pubKeyHash) {
+			return nil
+		}
+		// outpoint is txid + 8 bytes index
+		txid := append([]byte{}, outpoint[:len(outpoint)-8]...)
+		index := int64(binary.LittleEndian.Uint64(outpoint[len(outpoint)-8:]))
+		utxos = append(utxos, UTXOInfo{txid, index, entry.Output, entry.Height})
+		total += entry.Output.Value
+		return nil
+	})
+	if err != nil {
+		logWarn("find utxo fail: %v", err)
+	}
+	return utxos, total
+}
+
+func (bc *BlockChain) FindNeededUtxo(pubKeyHash []byte, amount int64) (int64, map[string][]int64) {
+	utxoInfos, total := bc.FindUtxo(pubKeyHash)
+	if total < amount {
+		return total, nil
+	}
+	retUtxoInfos := make(map[string][]int64)
+	var retTotal int64 = 0
+	// traversal utxo, compare with amount
+	for _, utxoInfo := range utxoInfos {
+		retTotal += utxoInfo.Output.Value
+		key := string(utxoInfo.TxId)
+		retUtxoInfos[key] = append(retUtxoInfos[key], utxoInfo.Index)
+		// if utxo is enough, return
+		if retTotal >= amount {
+			break
+		}
+	}
+	return retTotal, retUtxoInfos
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// VanityDifficulty returns the expected number of keys to generate until the Base58Check
+// address starts with prefix, error if no address of the active network can match it
+//
+// address = leading '1' for every leading zero byte + base58 of the remaining number,
+// so counting matched numbers of every possible length of base58 digits gives the probability
+func VanityDifficulty(prefix string) (*big.Float, error) {
+	if prefix == "" {
+		return nil, errors.New("empty prefix")
+	}
+	for i, c := range prefix {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return nil, fmt.Errorf("invalid Base58 character %q at position %d, 0, O, I and l are not used", c, i)
+		}
+	}
+	rest := strings.TrimLeft(prefix, "1")
+	ones := len(prefix) - len(rest)
+	if len(prefix) > 34 || ones > 24 {
+		return nil, fmt.Errorf("prefix %s is too long", prefix)
+	}
+
+	// numbers of the 25 bytes payload
+	version := big.NewInt(int64(activeNetwork.AddressVersion))
+	unit := new(big.Int).Lsh(big.NewInt(1), 24*8)
+	total := unit
+	var lo, hi *big.Int
+	if activeNetwork.AddressVersion == 0 {
+		// version 0 always brings one leading '1', more ones need more zero bytes in hash
+		if ones == 0 {
+			return nil, notAchievableError(prefix)
+		}
+		hi = new(big.Int).Lsh(big.NewInt(1), uint(25-ones)*8)
+		if rest == "" {
+			return new(big.Float).Quo(new(big.Float).SetInt(total), new(big.Float).SetInt(hi)), nil
+		}
+		lo = new(big.Int).Lsh(big.NewInt(1), uint(24-ones)*8)
+	} else {
+		if ones > 0 {
+			return nil, notAchievableError(prefix)
+		}
+		lo = new(big.Int).Mul(version, unit)
+		hi = new(big.Int).Add(lo, unit)
+	}
+	hi.Sub(hi, big.NewInt(1))
+
+	value := big.NewInt(0)
+	for _, c := range rest {
+		value.Mul(value, big.NewInt(58))
+		value.Add(value, big.NewInt(int64(strings.IndexRune(base58Alphabet, c))))
+	}
+	// numbers of m base58 digits starting with rest
+	matched := big.NewInt(0)
+	base := big.NewInt(58)
+	for m := len(rest); m <= 35; m++ {
+		scale := new(big.Int).Exp(base, big.NewInt(int64(m-len(rest))), nil)
+		from := new(big.Int).Mul(value, scale)
+		to := new(big.Int).Add(from, scale)
+		to.Sub(to, big.NewInt(1))
+		if from.Cmp(lo) < 0 {
+			from.Set(lo)
+		}
+		if to.Cmp(hi) > 0 {
+			to.Set(hi)
+		}
+		if from.Cmp(to) <= 0 {
+			count := new(big.Int).Sub(to, from)
+			matched.Add(matched, count.Add(count, big.NewInt(1)))
+		}
+	}
+	if matched.Sign() == 0 {
+		return nil, notAchievableError(prefix)
+	}
+	return new(big.Float).Quo(new(big.Float).SetInt(total), new(big.Float).SetInt(matched)), nil
+}
+
+// tell the range of addresses that can be generated in the active network
+func notAchievableError(prefix string) error {
+	payload := make([]byte, 25)
+	payload[0] = activeNetwork.AddressVersion
+	first := base58.Encode(payload)
+	for i := 1; i < len(payload); i++ {
+		payload[i] = 0xff
+	}
+	last := base58.Encode(payload)
+	return fmt.Errorf("prefix %s is not achievable, %s addresses are between %s and %s",
+		prefix, activeNetwork.Name, first[:3], last[:3])
+}
+
+// SearchVanity generates keys on all cores until the address starts with prefix,
+// tried is increased for every key generated
+func SearchVanity(prefix string, tried *uint64) *Wallet {
+	found := make(chan *Wallet, 1)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				wallet := NewWalletKeyPair()
+				atomic.AddUint64(tried, 1)
+				if strings.HasPrefix(wallet.GetAddress(), prefix) {
+					select {
+					case found <- wallet:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	wallet := <-found
+	close(stop)
+	wg.Wait()
+	return wallet
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVanityDifficulty(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	tests := []struct {
+		params *NetworkParams
+		prefix string
+		valid  bool
+	}{
+		{&MainNetParams, "", false},
+		{&MainNetParams, "10", false},
+		{&MainNetParams, "1O", false},
+		{&MainNetParams, "1I", false},
+		{&MainNetParams, "1l", false},
+		// version 0 addresses start with '1', version 0x6f ones with 'm' or 'n'
+		{&MainNetParams, "2", false},
+		{&TestNetParams, "1", false},
+		{&TestNetParams, "a", false},
+		{&TestNetParams, "nBob", false}, // past the highest address n4r...
+		{&MainNetParams, "1", true},
+		{&MainNetParams, "1Bob", true},
+		{&TestNetParams, "m", true},
+		{&TestNetParams, "mzBob", true},
+	}
+	for _, test := range tests {
+		activeNetwork = test.params
+		difficulty, err := VanityDifficulty(test.prefix)
+		if test.valid && (err != nil || difficulty.Sign() <= 0) {
+			t.Errorf("%s %q: got difficulty %v: %v", test.params.Name, test.prefix, difficulty, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s %q: got difficulty %v, want an error", test.params.Name, test.prefix, difficulty)
+		}
+	}
+
+	// each more character makes the prefix about 58 times harder
+	activeNetwork = &MainNetParams
+	prefix := "1"
+	last, _ := VanityDifficulty(prefix)
+	for _, c := range "Bitcoin" {
+		prefix += string(c)
+		difficulty, err := VanityDifficulty(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if difficulty.Cmp(last) <= 0 {
+			t.Errorf("difficulty of %s %v isn't greater than %v", prefix, difficulty, last)
+		}
+		last = difficulty
+	}
+}
@@ -8,11 +8,15 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/btcsuite/btcutil/base58"
 	"golang.org/x/crypto/ripemd160"
 )
 
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
 type Wallet struct {
 	PriKey []byte
 	PubKey []byte
@@ -24,18 +28,46 @@ func NewWalletKeyPair() *Wallet {
 	if err != nil {
 		panic(err)
 	}
-	x, y := priKey.PublicKey.X.Bytes(), priKey.PublicKey.Y.Bytes()
+	return &Wallet{priKey.D.Bytes(), marshalPubKey(priKey.PublicKey.X, priKey.PublicKey.Y)}
+}
+
+// marshalPubKey encodes x and y in 32 bytes each, Transaction.Verify only takes 64 bytes
+func marshalPubKey(x, y *big.Int) []byte {
+	return append(x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32))...)
+}
+
+// PrivateKey restores the ecdsa private key used for signing
+func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
+	x, y := elliptic.P256().ScalarBaseMult(w.PriKey)
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(w.PriKey),
+	}
+}
 
-	return &Wallet{priKey.D.Bytes(), append(x, y...)}
+// IsValidKeyPair checks the public key is derived from the private key
+func (w *Wallet) IsValidKeyPair() bool {
+	x, y := elliptic.P256().ScalarBaseMult(w.PriKey)
+	// keys created before marshalPubKey may be shorter than 64 bytes
+	return bytes.Equal(marshalPubKey(x, y), w.PubKey) || bytes.Equal(append(x.Bytes(), y.Bytes()...), w.PubKey)
 }
 
 func (w *Wallet) GetAddress() string {
-	pubKeyHash := GetPubKeyHashFromPubKey(w.PubKey)
-	versionedPayload := append([]byte{0x00}, pubKeyHash...)
+	return EncodeAddress(OutputPubKeyHash, GetPubKeyHashFromPubKey(w.PubKey))
+}
+
+// GetBech32Address returns segwit version 0 address of the same key
+func (w *Wallet) GetBech32Address() string {
+	return EncodeAddress(OutputWitnessPubKeyHash, GetPubKeyHashFromPubKey(w.PubKey))
+}
+
+// ExportWIF encodes private key in Wallet Import Format of the active network
+func (w *Wallet) ExportWIF() string {
+	priKey := make([]byte, 32)
+	copy(priKey[32-len(w.PriKey):], w.PriKey)
+	versionedPayload := append([]byte{activeNetwork.WIFPrefix}, priKey...)
 	checksum := Checksum(versionedPayload)
-	fullPayload := append(versionedPayload, checksum...)
-	address := base58.Encode(fullPayload)
-	return address
+	return base58.Encode(append(versionedPayload, checksum...))
 }
 
 // Checksum calculates the checksum of payload, return the first 4 bytes
@@ -53,22 +85,76 @@ func GetPubKeyHashFromPubKey(pubKey []byte) []byte {
 	return ripemd160Hash.Sum(nil)
 }
 func GetPubKeyHashFromAddress(address string) ([]byte, error) {
-	fullPayload := base58.Decode(address)
-	if len(fullPayload) != 25 {
-		return nil, errors.New("address'length is not 25, invalid address")
-	}
-	return fullPayload[1 : len(fullPayload)-4], nil
+	_, pubKeyHash, err := DecodeAddress(address)
+	return pubKeyHash, err
 }
 func IsValidAddress(address string) bool {
+	return ValidateAddress(address) == nil
+}
+
+// ValidateAddress tells why address is invalid, nil if it is valid
+func ValidateAddress(address string) error {
+	_, _, err := DecodeAddress(address)
+	return err
+}
+
+// EncodeAddress encodes pubKeyHash to an address of the active network,
+// Base58Check for OutputPubKeyHash and bech32 for OutputWitnessPubKeyHash
+func EncodeAddress(t OutputType, pubKeyHash []byte) string {
+	if t == OutputWitnessPubKeyHash {
+		address, err := EncodeSegWitAddress(activeNetwork.Bech32HRP, 0, pubKeyHash)
+		if err != nil {
+			panic(err)
+		}
+		return address
+	}
+	versionedPayload := append([]byte{activeNetwork.AddressVersion}, pubKeyHash...)
+	checksum := Checksum(versionedPayload)
+	fullPayload := append(versionedPayload, checksum...)
+	return base58.Encode(fullPayload)
+}
+
+// DecodeAddress accepts Base58Check and bech32 addresses of the active network,
+// returns the output type and public key hash it locks to
+func DecodeAddress(address string) (OutputType, []byte, error) {
+	lower := strings.ToLower(address)
+	for _, params := range []*NetworkParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if !strings.HasPrefix(lower, params.Bech32HRP+"1") {
+			continue
+		}
+		if params.Bech32HRP != activeNetwork.Bech32HRP {
+			return 0, nil, fmt.Errorf("address belongs to %s, not %s", params.Name, activeNetwork.Name)
+		}
+		version, program, err := DecodeSegWitAddress(activeNetwork.Bech32HRP, address)
+		if err != nil {
+			return 0, nil, err
+		}
+		if version != 0 || len(program) != 20 {
+			return 0, nil, fmt.Errorf("unsupported witness version %d with %d bytes program", version, len(program))
+		}
+		return OutputWitnessPubKeyHash, program, nil
+	}
+
+	for i, c := range address {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return 0, nil, fmt.Errorf("invalid Base58 character %q at position %d", c, i)
+		}
+	}
 	fullPayload := base58.Decode(address)
 	if len(fullPayload) != 25 {
-		return false
+		return 0, nil, errors.New("address'length is not 25, invalid address")
 	}
 	versionedPayload := fullPayload[:len(fullPayload)-4]
 	checksum := fullPayload[len(fullPayload)-4:]
-	return bytes.Equal(Checksum(versionedPayload), checksum)
+	if !bytes.Equal(Checksum(versionedPayload), checksum) {
+		return 0, nil, errors.New("invalid checksum")
+	}
+	if fullPayload[0] != activeNetwork.AddressVersion {
+		return 0, nil, fmt.Errorf("address doesn't belong to %s", activeNetwork.Name)
+	}
+	return OutputPubKeyHash, versionedPayload[1:], nil
 }
 
 func (w *Wallet) String() string {
-	return fmt.Sprintf("Address: %s\nPubKey: %#X\nPriKey:%#X", w.GetAddress(), w.PubKey, w.PriKey)
+	return fmt.Sprintf("Address: %s\nBech32: %s\nPubKey: %#X\nPriKey:%#X", w.GetAddress(), w.GetBech32Address(), w.PubKey, w.PriKey)
 }
@@ -0,0 +1,141 @@
+// wallet file layout:
+//
+//	magic(8) | version(4, little endian) | sha256 of payload(32) | payload(gob encoded WalletManager)
+//
+// files written before the header was introduced contain payload only, they are version 0
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	walletMagic       = "BCWALLET"
+	walletFileVersion = 1
+	walletHeaderSize  = len(walletMagic) + 4 + sha256.Size
+
+	walletBackupCount = 5 // wallet.dat.1 is the newest one
+)
+
+func encodeWalletFile(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(walletMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(walletFileVersion))
+	checksum := sha256.Sum256(payload)
+	buf.Write(checksum[:])
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// decodeWalletFile verifies the header and returns payload and file version
+func decodeWalletFile(data []byte) ([]byte, uint32, error) {
+	if !bytes.HasPrefix(data, []byte(walletMagic)) {
+		return data, 0, nil
+	}
+	if len(data) < walletHeaderSize {
+		return nil, 0, errors.New("wallet file header is truncated")
+	}
+	version := binary.LittleEndian.Uint32(data[len(walletMagic):])
+	if version > walletFileVersion {
+		return nil, version, fmt.Errorf("unsupported wallet file version %d", version)
+	}
+	checksum := data[len(walletMagic)+4 : walletHeaderSize]
+	payload := data[walletHeaderSize:]
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(sum[:], checksum) {
+		return nil, version, errors.New("wallet file checksum mismatch, the file is corrupted")
+	}
+	return payload, version, nil
+}
+
+// writeFileAtomic writes data to a temp file in the same dir, flushes it to disk
+// and renames it to filename, a crash leaves either the old or the new file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName) // no-op after rename succeeds
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	// persist the rename, opening a dir isn't supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func walletBackupPath(filename string, n int) string {
+	return fmt.Sprintf("%s.%d", filename, n)
+}
+
+// rotateWalletBackups keeps the current content of filename as backup 1,
+// older backups shift by one and the oldest is dropped
+func rotateWalletBackups(filename string) error {
+	current, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for n := walletBackupCount - 1; n >= 1; n-- {
+		if IsFileExist(walletBackupPath(filename, n)) {
+			err = os.Rename(walletBackupPath(filename, n), walletBackupPath(filename, n+1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeFileAtomic(walletBackupPath(filename, 1), current, 0600)
+}
+
+// VerifyWalletFile checks header, checksum and every key pair of a wallet file
+func VerifyWalletFile(filename string) (*WalletManager, uint32, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, version, err := decodeWalletFile(data)
+	if err != nil {
+		return nil, version, err
+	}
+	wm := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
+	if err = wm.decode(payload); err != nil {
+		return nil, version, err
+	}
+	for address, wallet := range wm.Wallets {
+		if wallet.GetAddress() != address {
+			return nil, version, fmt.Errorf("key of %s doesn't match the address", address)
+		}
+		if !wallet.IsValidKeyPair() {
+			return nil, version, fmt.Errorf("private key of %s doesn't match the public key", address)
+		}
+	}
+	return wm, version, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/gob"
+	"os"
+	"testing"
+)
+
+func TestWalletFile(t *testing.T) {
+	defer func(dir string) { dataDir = dir }(dataDir)
+	dataDir = t.TempDir()
+	wallet := NewWalletKeyPair()
+	wm := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
+	address := wm.AddWallet(wallet, "first")
+	filename := GetDataPath(walletFile)
+
+	var buffer bytes.Buffer
+	gob.Register(elliptic.P256())
+	if err := gob.NewEncoder(&buffer).Encode(wm); err != nil {
+		t.Fatal(err)
+	}
+	payload := buffer.Bytes()
+	data := encodeWalletFile(payload)
+	got, version, err := decodeWalletFile(data)
+	if err != nil || version != walletFileVersion || !bytes.Equal(got, payload) {
+		t.Errorf("got version %d, payload equal: %v: %v", version, bytes.Equal(got, payload), err)
+	}
+	corrupted := append([]byte(nil), data...)
+	corrupted[walletHeaderSize] ^= 1
+	if _, _, err := decodeWalletFile(corrupted); err == nil {
+		t.Error("wallet file with a flipped payload byte is accepted")
+	}
+	if _, _, err := decodeWalletFile(data[:walletHeaderSize-1]); err == nil {
+		t.Error("wallet file with a truncated header is accepted")
+	}
+
+	// files written before the header are loaded as version 0
+	if err := os.WriteFile(filename, payload, 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, version, err := VerifyWalletFile(filename)
+	if err != nil || version != 0 || loaded.Wallets[address] == nil {
+		t.Fatalf("got version %d of the legacy file: %v", version, err)
+	}
+	legacy := &WalletManager{Wallets: make(map[string]*Wallet), Meta: make(map[string]*AddressMeta)}
+	legacy.LoadFile()
+	if legacy.GetWallet(address) == nil || legacy.GetMeta(address).Label != "first" {
+		t.Error("wallet of the legacy file is not loaded")
+	}
+
+	// each save keeps the previous file as backup 1, backups past walletBackupCount are dropped
+	var previous []byte
+	for i := 0; i <= walletBackupCount; i++ {
+		if previous, err = os.ReadFile(filename); err != nil {
+			t.Fatal(err)
+		}
+		wm.CreateWallet("more")
+	}
+	for n := 1; n <= walletBackupCount; n++ {
+		if !IsFileExist(walletBackupPath(filename, n)) {
+			t.Errorf("backup %d is missing", n)
+		}
+	}
+	if IsFileExist(walletBackupPath(filename, walletBackupCount+1)) {
+		t.Errorf("got more than %d backups", walletBackupCount)
+	}
+	if backup, err := os.ReadFile(walletBackupPath(filename, 1)); err != nil || !bytes.Equal(backup, previous) {
+		t.Errorf("backup 1 isn't the previous wallet file: %v", err)
+	}
+
+	loaded, version, err = VerifyWalletFile(filename)
+	if err != nil || version != walletFileVersion || len(loaded.Wallets) != len(wm.Wallets) {
+		t.Errorf("got version %d and %d wallets, want %d: %v", version, len(loaded.Wallets), len(wm.Wallets), err)
+	}
+	if err := os.WriteFile(filename, corrupted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := VerifyWalletFile(filename); err == nil {
+		t.Error("corrupted wallet file is verified")
+	}
+}
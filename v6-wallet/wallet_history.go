@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WalletTx is a transaction that pays to or spends from keys in wallet
+type WalletTx struct {
+	TxId          []byte
+	BlockHash     []byte
+	Height        uint64
+	Time          int64 // timestamp of the block
+	Confirmations uint64
+	Category      string // generate, receive, send or self
+	Amount        int64  // net amount to wallet, fee excluded
+	Fee           int64  // only known if wallet funds all inputs
+	Tx            *Transaction
+}
+
+// BuildWalletHistory scans the whole chain for outputs to and inputs from keys in wm,
+// returns wallet transactions ordered from the oldest to the newest. Pruned blocks
+// are skipped, so values spent from their outputs are unknown.
+func BuildWalletHistory(bc *BlockChain, wm *WalletManager) []*WalletTx {
+	ownKeys := make(map[string]bool)
+	for _, wallet := range wm.Wallets {
+		ownKeys[string(GetPubKeyHashFromPubKey(wallet.PubKey))] = true
+	}
+
+	// iterator walks from tail to genesis, reverse it
+	var blocks []*Block
+	iter := bc.NewIterator()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		blocks = append(blocks, block)
+	}
+	bestHeight := uint64(0)
+	if len(blocks) > 0 {
+		bestHeight = blocks[0].Height
+	}
+
+	// values of all outputs in unpruned blocks, key: txid + index
+	outputValues := make(map[string]int64)
+	history := make([]*WalletTx, 0)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		for _, tx := range block.Transactions {
+			var received, sent, inputTotal, outputTotal int64
+			isOwnInputs, hasOwnInput, knownInputs := true, false, true
+
+			if !tx.IsMiningTx() {
+				for _, input := range tx.TxInputs {
+					value, ok := outputValues[outPointKey(input.TxId, input.Index)]
+					knownInputs = knownInputs && ok
+					inputTotal += value
+					if ownKeys[string(GetPubKeyHashFromPubKey(input.PubKey))] {
+						sent += value
+						hasOwnInput = true
+					} else {
+						isOwnInputs = false
+					}
+				}
+			}
+			hasOwnOutput := false
+			for idx, output := range tx.TxOutputs {
+				outputValues[outPointKey(tx.Id, int64(idx))] = output.Value
+				outputTotal += output.Value
+				if output.Type != OutputNullData && ownKeys[string(output.ScriptPubKeyHash)] {
+					received += output.Value
+					hasOwnOutput = true
+				}
+			}
+			if !hasOwnInput && !hasOwnOutput {
+				continue
+			}
+
+			wtx := &WalletTx{
+				TxId:          tx.Id,
+				BlockHash:     block.Hash,
+				Height:        block.Height,
+				Time:          int64(block.TimeStamp),
+				Confirmations: bestHeight - block.Height + 1,
+				Amount:        received - sent,
+				Tx:            tx,
+			}
+			switch {
+			case tx.IsMiningTx():
+				wtx.Category = "generate"
+			case !hasOwnInput:
+				wtx.Category = "receive"
+			case isOwnInputs && knownInputs:
+				wtx.Fee = inputTotal - outputTotal
+				wtx.Amount += wtx.Fee
+				wtx.Category = "send"
+				if received == outputTotal {
+					wtx.Category = "self"
+				}
+			default:
+				wtx.Category = "send"
+			}
+			history = append(history, wtx)
+		}
+	}
+	return history
+}
+
+// ListTransactions skips the `skip` most recent transactions and returns at most `count`
+// transactions before them, ordered from the oldest to the newest like bitcoin does
+func ListTransactions(history []*WalletTx, count, skip int) []*WalletTx {
+	end := len(history) - skip
+	if end <= 0 {
+		return nil
+	}
+	start := end - count
+	if start < 0 {
+		start = 0
+	}
+	return history[start:end]
+}
+
+func GetWalletTransaction(history []*WalletTx, txid []byte) (*WalletTx, error) {
+	for _, wtx := range history {
+		if bytes.Equal(wtx.TxId, txid) {
+			return wtx, nil
+		}
+	}
+	return nil, errors.New("invalid or non-wallet transaction id")
+}
+
+func outPointKey(txid []byte, index int64) string {
+	return string(txid) + string(UintToByte(uint64(index)))
+}
+
+func (wtx *WalletTx) String() string {
+	format := `TxId          : %x
+Category      : %s
+Amount        : %d
+Fee           : %d
+Confirmations : %d
+BlockHash     : %x
+Height        : %d
+Time          : %s`
+	return fmt.Sprintf(format, wtx.TxId, wtx.Category, wtx.Amount, wtx.Fee, wtx.Confirmations,
+		wtx.BlockHash, wtx.Height, time.Unix(wtx.Time, 0).Format(time.DateTime))
+}
+
+// Details lists every output of the transaction and marks outputs to wallet
+func (wtx *WalletTx) Details(wm *WalletManager) string {
+	str := strings.Builder{}
+	for idx, output := range wtx.Tx.TxOutputs {
+		mine := ""
+		if wm.GetWallet(output.Address()) != nil {
+			mine = " (mine)"
+		}
+		str.WriteString(fmt.Sprintf("  [%d] %s%s\n", idx, output.String(), mine))
+	}
+	return str.String()
+}
+
+type WalletTxOutputJSON struct {
+	N       int    `json:"n"`
+	Value   int64  `json:"value"`
+	Address string `json:"address"`
+	Mine    bool   `json:"mine"`
+}
+
+type WalletTxJSON struct {
+	Txid          string               `json:"txid"`
+	Category      string               `json:"category"`
+	Amount        int64                `json:"amount"`
+	Fee           int64                `json:"fee"`
+	Confirmations uint64               `json:"confirmations"`
+	BlockHash     string               `json:"blockhash"`
+	Height        uint64               `json:"height"`
+	Time          int64                `json:"time"`
+	Outputs       []WalletTxOutputJSON `json:"outputs,omitempty"` // only with details
+}
+
+// NewWalletTxJSON marks outputs to wm with details
+func NewWalletTxJSON(wtx *WalletTx, wm *WalletManager, details bool) *WalletTxJSON {
+	wtxJSON := &WalletTxJSON{
+		Txid:          hex.EncodeToString(wtx.TxId),
+		Category:      wtx.Category,
+		Amount:        wtx.Amount,
+		Fee:           wtx.Fee,
+		Confirmations: wtx.Confirmations,
+		BlockHash:     hex.EncodeToString(wtx.BlockHash),
+		Height:        wtx.Height,
+		Time:          wtx.Time,
+	}
+	if details {
+		for i, output := range wtx.Tx.TxOutputs {
+			wtxJSON.Outputs = append(wtxJSON.Outputs, WalletTxOutputJSON{
+				N:       i,
+				Value:   output.Value,
+				Address: output.Address(),
+				Mine:    wm.GetWallet(output.Address()) != nil,
+			})
+		}
+	}
+	return wtxJSON
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWalletHistory(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	other, mine, second := NewWalletKeyPair(), NewWalletKeyPair(), NewWalletKeyPair()
+	otherWm := &WalletManager{Wallets: map[string]*Wallet{other.GetAddress(): other}}
+	wm := &WalletManager{Wallets: map[string]*Wallet{mine.GetAddress(): mine, second.GetAddress(): second}}
+	bc := newTestChain(t, other.GetAddress(), 2)
+	mineBlock := func(miner, from, to string, amount, fee int64, wm *WalletManager) *Transaction {
+		tx, err := NewTransaction(from, to, amount, fee, 0, 0, bc, wm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bc.AddBlock([]*Transaction{NewMiningTx(miner, "test", bc.GetBestHeight()+1), tx}); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	received := mineBlock(mine.GetAddress(), other.GetAddress(), mine.GetAddress(), 10, 0, otherWm)
+	sent := mineBlock(other.GetAddress(), mine.GetAddress(), other.GetAddress(), 3, 1, wm)
+	self := mineBlock(other.GetAddress(), mine.GetAddress(), second.GetAddress(), 2, 1, wm)
+
+	history := BuildWalletHistory(bc, wm)
+	tests := []struct {
+		txid          []byte
+		category      string
+		amount, fee   int64
+		confirmations uint64
+	}{
+		{nil, "generate", RegTestParams.BlockReward(2), 0, 3},
+		{received.Id, "receive", 10, 0, 3},
+		{sent.Id, "send", -3, 1, 2},
+		{self.Id, "self", 0, 1, 1},
+	}
+	if len(history) != len(tests) {
+		t.Fatalf("got %d wallet transactions, want %d", len(history), len(tests))
+	}
+	for i, test := range tests {
+		wtx := history[i]
+		if test.txid != nil && !bytes.Equal(wtx.TxId, test.txid) {
+			t.Errorf("%s: got transaction %x, want %x", test.category, wtx.TxId, test.txid)
+		}
+		if wtx.Category != test.category || wtx.Amount != test.amount || wtx.Fee != test.fee || wtx.Confirmations != test.confirmations {
+			t.Errorf("got %s amount %d fee %d with %d confirmations, want %s amount %d fee %d with %d",
+				wtx.Category, wtx.Amount, wtx.Fee, wtx.Confirmations, test.category, test.amount, test.fee, test.confirmations)
+		}
+	}
+
+	// the other wallet has 4 mining transactions and the other side of the first two
+	if otherHistory := BuildWalletHistory(bc, otherWm); len(otherHistory) != 6 || otherHistory[2].Category != "send" || otherHistory[4].Category != "receive" {
+		t.Errorf("got %d transactions of the other wallet, want 6", len(otherHistory))
+	}
+
+	if got := ListTransactions(history, 2, 1); len(got) != 2 || got[0] != history[1] || got[1] != history[2] {
+		t.Errorf("got %v, want transactions 1 and 2", got)
+	}
+	if got := ListTransactions(history, 10, 0); len(got) != len(history) {
+		t.Errorf("got %d transactions, want all %d", len(got), len(history))
+	}
+	if got := ListTransactions(history, 10, len(history)); got != nil {
+		t.Errorf("got %v after skipping all", got)
+	}
+
+	if wtx, err := GetWalletTransaction(history, sent.Id); err != nil || wtx != history[2] {
+		t.Errorf("got %v: %v", wtx, err)
+	}
+	if _, err := GetWalletTransaction(history, []byte("unknown")); err == nil {
+		t.Error("unknown transaction is found")
+	}
+}
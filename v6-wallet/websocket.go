@@ -0,0 +1,171 @@
+// notifications over websocket at /ws, with the same authentication as JSON-RPC.
+// A client sends commands to choose what it receives:
+//
+//	{"method":"subscribe","topics":["newtip","wallettx"],"addresses":["mu68x..."]}
+//	{"method":"unsubscribe","topics":["newtip"]}
+//
+// and gets {"result":{"topics":[...],"addresses":[...]}} or {"error":"..."} for each of
+// them, then events as {"topic":"newtip","time":...,"height":...,"hash":"..."}
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsEventBuffer  = 256
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = wsPongTimeout * 9 / 10
+	wsMaxMessage   = 64 << 10
+)
+
+type WSCommand struct {
+	Method    string   `json:"method"`
+	Topics    []string `json:"topics"`
+	Addresses []string `json:"addresses"`
+}
+
+type WSSubscriptionJSON struct {
+	Topics    []string `json:"topics"`
+	Addresses []string `json:"addresses"`
+}
+
+type WSReply struct {
+	Result *WSSubscriptionJSON `json:"result,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// clients are authenticated, they don't have to be pages of the same origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ServeWebSocket upgrades an authenticated request and streams events until either side closes
+func (s *RPCServer) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has replied
+	}
+	logInfo("websocket client %s connected", r.RemoteAddr)
+	sub := s.node.events.Subscribe(wsEventBuffer)
+	replies := make(chan *WSReply, 16)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go s.wsReadLoop(conn, sub, replies, done, stop)
+	s.wsWriteLoop(conn, sub, replies, done)
+	close(stop)
+	s.node.events.Unsubscribe(sub)
+	conn.Close()
+	logInfo("websocket client %s disconnected", r.RemoteAddr)
+}
+
+// wsReadLoop handles commands of the client, done is closed when the connection fails,
+// stop is closed when the writer is gone
+func (s *RPCServer) wsReadLoop(conn *websocket.Conn, sub *Subscription, replies chan<- *WSReply, done, stop chan struct{}) {
+	defer close(done)
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	for {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			return
+		}
+		var reply *WSReply
+		var cmd WSCommand
+		if err := json.NewDecoder(r).Decode(&cmd); err != nil {
+			// the rest of the message is skipped by the next read
+			reply = &WSReply{Error: "invalid command: " + err.Error()}
+		} else {
+			reply = handleWSCommand(sub, &cmd)
+		}
+		select {
+		case replies <- reply:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func handleWSCommand(sub *Subscription, cmd *WSCommand) *WSReply {
+	var add bool
+	switch cmd.Method {
+	case "subscribe":
+		add = true
+	case "unsubscribe":
+	default:
+		return &WSReply{Error: "unknown method " + cmd.Method}
+	}
+	for _, topic := range cmd.Topics {
+		if !validTopic(topic) {
+			return &WSReply{Error: "unknown topic " + topic}
+		}
+	}
+	// events carry the Base58Check address of every key, so filters of bech32 addresses
+	// are kept in that form too
+	filters := make([]string, 0, len(cmd.Addresses))
+	for _, addr := range cmd.Addresses {
+		if err := ValidateAddress(addr); err != nil {
+			return &WSReply{Error: "invalid address " + addr + ": " + err.Error()}
+		}
+		_, pubKeyHash, err := DecodeAddress(addr)
+		if err != nil {
+			return &WSReply{Error: "invalid address " + addr + ": " + err.Error()}
+		}
+		filters = append(filters, EncodeAddress(OutputPubKeyHash, pubKeyHash))
+	}
+	topics, addrs := sub.Update(add, cmd.Topics, filters)
+	return &WSReply{Result: &WSSubscriptionJSON{topics, addrs}}
+}
+
+func validTopic(topic string) bool {
+	for _, t := range eventTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// wsWriteLoop is the only writer of conn, it returns when the client is gone or too slow
+func (s *RPCServer) wsWriteLoop(conn *websocket.Conn, sub *Subscription, replies <-chan *WSReply, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(reply)
+		case e, ok := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow to receive events"))
+				return
+			}
+			err = conn.WriteJSON(e)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
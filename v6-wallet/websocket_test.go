@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketFilter(t *testing.T) {
+	defer func(params *NetworkParams) { activeNetwork = params }(activeNetwork)
+	activeNetwork = &RegTestParams
+	watched, other := NewWalletKeyPair(), NewWalletKeyPair()
+	n := NewNode(newTestChain(t, other.GetAddress(), 1), "test", "")
+	s := &RPCServer{node: n, user: "user", password: "secret"}
+	server := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("connected without auth: %v", err)
+	}
+	header := http.Header{}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.SetBasicAuth("user", "secret")
+	header.Set("Authorization", req.Header.Get("Authorization"))
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.WriteJSON(&WSCommand{Method: "subscribe", Topics: []string{"unknown"}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply WSReply
+	if err := conn.ReadJSON(&reply); err != nil || reply.Error == "" {
+		t.Errorf("got reply %+v to an unknown topic: %v", reply, err)
+	}
+	// the bech32 form of the key matches outputs to its Base58Check address
+	cmd := &WSCommand{Method: "subscribe", Topics: []string{TopicTxAccepted}, Addresses: []string{watched.GetBech32Address()}}
+	if err := conn.WriteJSON(cmd); err != nil {
+		t.Fatal(err)
+	}
+	reply = WSReply{}
+	if err := conn.ReadJSON(&reply); err != nil || reply.Result == nil || len(reply.Result.Addresses) != 1 {
+		t.Fatalf("got reply %+v: %v", reply, err)
+	}
+
+	skipped, matched := NewMiningTx(other.GetAddress(), "skipped", 1), NewMiningTx(watched.GetAddress(), "matched", 1)
+	n.publishTx(skipped)
+	n.publishTx(matched)
+	var event Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Topic != TopicTxAccepted || event.Tx == nil || event.Tx.Txid != hex.EncodeToString(matched.Id) {
+		t.Errorf("got event %+v, want transaction %x", event, matched.Id)
+	}
+}